@@ -1,6 +1,8 @@
 package adapter
 
 import (
+	"context"
+
 	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 	"golang.org/x/oauth2"
@@ -13,12 +15,28 @@ type SpotifyAuthWrapperInterface interface {
 }
 
 type SpotifyWrapperInterface interface {
-	GetAllPlaylistsForUser(username string) ([]spotify.SimplePlaylist, error)
-	GetAllPlaylistTracks(playlistID spotify.ID) ([]spotify.PlaylistTrack, error)
-	RemoveTracksFromPlaylist(playlistID spotify.ID, trackIDs ...spotify.ID) error
-	GetAuthURL() string
-	GetTokenFromResponseCode(responseCode string) (*oauth2.Token, error)
-	LoginAndCreateClient(token *oauth2.Token)
+	GetAllPlaylistsForUser(ctx context.Context, username string) ([]spotify.SimplePlaylist, error)
+	GetAllPlaylistTracks(ctx context.Context, playlistID spotify.ID) ([]spotify.PlaylistTrack, error)
+	RemoveTracksFromPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) error
+	RemoveTrackPositionsFromPlaylist(ctx context.Context, playlistID spotify.ID, snapshotID string, tracks ...spotify.TrackToRemove) (string, error)
+	CreatePlaylist(ctx context.Context, name string, description string) (spotify.SimplePlaylist, error)
+	AddTracksToPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) error
+	ReplacePlaylistTracks(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) error
+	SearchTracks(ctx context.Context, query string, limit int) ([]spotify.FullTrack, error)
+	GetAuthURL(state string) string
+	GetTokenFromResponseCode(ctx context.Context, responseCode string) (*oauth2.Token, error)
+	LoginAndCreateClient(ctx context.Context, token *oauth2.Token, saveToken func(token *oauth2.Token) error)
 	CreateAuthenticator(redirectURL string)
+	GetCodeVerifier() string
+	SetCodeVerifier(verifier string)
 	GetToken() (*oauth2.Token, error)
+	GetRequestStats() RequestStats
+}
+
+// RequestStats counts the requests made to Spotify during a run
+type RequestStats struct {
+	Requests  int // every attempt, including retries
+	Throttled int // responses with status 429
+	Retried   int // attempts repeated after a 429 or 5xx response
+	Failed    int // requests which still failed after the last retry
 }
@@ -0,0 +1,243 @@
+package spotifywrapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/mocks/fakespotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+// newTestWrapper creates a wrapper logged in to a fake Spotify server
+func newTestWrapper(t *testing.T) (*wrapper, *fakespotify.Server) {
+	server := fakespotify.NewServer()
+	t.Cleanup(server.Close)
+
+	w := NewWrapper(WithClientCredentials("id", "secret"), WithBaseURLs(server.APIURL(), server.AccountsURL()))
+	w.CreateAuthenticator("http://localhost/callback")
+
+	server.AddAuthCode("code")
+	token, err := w.GetTokenFromResponseCode(context.Background(), "code")
+	assert.NoError(t, err)
+	w.LoginAndCreateClient(context.Background(), token, nil)
+	return w, server
+}
+
+func Test_GetTokenFromResponseCode_InvalidCode(t *testing.T) {
+	w, _ := newTestWrapper(t)
+
+	_, err := w.GetTokenFromResponseCode(context.Background(), "unknown")
+	assert.Error(t, err)
+}
+
+// authorizeCode follows the authorize URL of the wrapper as if the user had authorized
+// the app, and returns the code passed to the redirect URL
+func authorizeCode(t *testing.T, w *wrapper) string {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(w.GetAuthURL("state"))
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	location, err := resp.Location()
+	assert.NoError(t, err)
+	return location.Query().Get("code")
+}
+
+func Test_GetTokenFromResponseCode_PKCE(t *testing.T) {
+	server := fakespotify.NewServer()
+	t.Cleanup(server.Close)
+	w := NewWrapper(WithClientCredentials("id", ""), WithBaseURLs(server.APIURL(), server.AccountsURL()), WithPKCE())
+	w.CreateAuthenticator("http://localhost/callback")
+	verifier := w.GetCodeVerifier()
+	assert.Len(t, verifier, 43)
+
+	code := authorizeCode(t, w)
+	w.CreateAuthenticator("http://localhost/callback")
+	_, err := w.GetTokenFromResponseCode(context.Background(), code)
+	assert.Error(t, err, "a new verifier does not match the code")
+
+	w.SetCodeVerifier(verifier)
+	token, err := w.GetTokenFromResponseCode(context.Background(), code)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.RefreshToken)
+
+	// refresh tokens issued to PKCE clients are replaced each time they are used
+	token.Expiry = time.Now().Add(-time.Minute)
+	w.LoginAndCreateClient(context.Background(), token, nil)
+	refreshed, err := w.GetToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token.RefreshToken, refreshed.RefreshToken)
+	assert.True(t, refreshed.Expiry.After(time.Now()))
+}
+
+func Test_GetAllPlaylistsForUser_Paging(t *testing.T) {
+	w, server := newTestWrapper(t)
+	for i := 0; i < 120; i++ {
+		server.AddPlaylist("user", fmt.Sprintf("Playlist %d", i))
+	}
+
+	result, err := w.GetAllPlaylistsForUser(context.Background(), "user")
+	assert.NoError(t, err)
+	assert.Len(t, result, 120)
+	assert.Equal(t, "Playlist 0", result[0].Name)
+	assert.Equal(t, "Playlist 119", result[119].Name)
+	assert.Equal(t, 3, server.RequestCount("GET /v1/users/user/playlists"))
+}
+
+func Test_GetAllPlaylistTracks_Paging(t *testing.T) {
+	w, server := newTestWrapper(t)
+	var tracks []spotify.PlaylistTrack
+	for i := 0; i < 250; i++ {
+		tracks = append(tracks, fakespotify.NewTrack(fmt.Sprintf("track%d", i), "Song", "Artist", 200000))
+	}
+	playlist := server.AddPlaylist("user", "Favorites", tracks...)
+
+	result, err := w.GetAllPlaylistTracks(context.Background(), playlist.ID)
+	assert.NoError(t, err)
+	assert.Len(t, result, 250)
+	assert.Equal(t, spotify.ID("track249"), result[249].Track.ID)
+}
+
+func Test_RemoveTracksFromPlaylist(t *testing.T) {
+	w, server := newTestWrapper(t)
+	playlist := server.AddPlaylist("user", "Favorites",
+		fakespotify.NewTrack("a", "Song A", "Artist", 200000),
+		fakespotify.NewTrack("b", "Song B", "Artist", 200000),
+		fakespotify.NewTrack("a", "Song A", "Artist", 200000),
+		fakespotify.NewTrack("c", "Song C", "Artist", 200000))
+
+	err := w.RemoveTracksFromPlaylist(context.Background(), playlist.ID, "a", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, []spotify.ID{"b"}, server.TrackIDs(playlist.ID))
+}
+
+func Test_RemoveTrackPositionsFromPlaylist(t *testing.T) {
+	w, server := newTestWrapper(t)
+	var tracks []spotify.PlaylistTrack
+	for i := 0; i < 250; i++ {
+		tracks = append(tracks, fakespotify.NewTrack(fmt.Sprintf("track%d", i%2), "Song", "Artist", 200000))
+	}
+	playlist := server.AddPlaylist("user", "Favorites", tracks...)
+
+	// remove every track0 except the first, across 2 batches
+	var positions []int
+	for i := 2; i < 250; i += 2 {
+		positions = append(positions, i)
+	}
+	snapshotID, err := w.RemoveTrackPositionsFromPlaylist(context.Background(), playlist.ID, playlist.SnapshotID,
+		spotify.NewTrackToRemove("track0", positions))
+	assert.NoError(t, err)
+	assert.Equal(t, server.Playlist(playlist.ID).SnapshotID, snapshotID)
+	assert.Equal(t, 2, server.RequestCount("DELETE /v1/playlists/"+string(playlist.ID)+"/tracks"))
+
+	result := server.TrackIDs(playlist.ID)
+	assert.Len(t, result, 126)
+	assert.Equal(t, spotify.ID("track0"), result[0])
+	for _, id := range result[1:] {
+		assert.Equal(t, spotify.ID("track1"), id)
+	}
+}
+
+func Test_RemoveTrackPositionsFromPlaylist_StaleSnapshot(t *testing.T) {
+	w, server := newTestWrapper(t)
+	playlist := server.AddPlaylist("user", "Favorites",
+		fakespotify.NewTrack("a", "Song A", "Artist", 200000),
+		fakespotify.NewTrack("a", "Song A", "Artist", 200000))
+
+	_, err := w.RemoveTrackPositionsFromPlaylist(context.Background(), playlist.ID, "old-snapshot", spotify.NewTrackToRemove("a", []int{1}))
+	assert.Error(t, err)
+	assert.Len(t, server.Tracks(playlist.ID), 2)
+}
+
+func Test_GetAllPlaylistTracks_Cancelled(t *testing.T) {
+	w, server := newTestWrapper(t)
+	playlist := server.AddPlaylist("user", "Favorites", fakespotify.NewTrack("a", "Song A", "Artist", 200000))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := w.GetAllPlaylistTracks(ctx, playlist.ID)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, server.RequestCount("GET /v1/playlists/"+string(playlist.ID)+"/tracks"))
+}
+
+func Test_GetAllPlaylistTracks_Throttled(t *testing.T) {
+	w, server := newTestWrapper(t)
+	playlist := server.AddPlaylist("user", "Favorites", fakespotify.NewTrack("a", "Song A", "Artist", 200000))
+	server.FailRequests(http.StatusTooManyRequests, 2)
+
+	result, err := w.GetAllPlaylistTracks(context.Background(), playlist.ID)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+
+	stats := w.GetRequestStats()
+	assert.Equal(t, 2, stats.Throttled)
+	assert.Equal(t, 2, stats.Retried)
+	assert.Equal(t, 0, stats.Failed)
+}
+
+func Test_GetAllPlaylistTracks_ServerError(t *testing.T) {
+	w, server := newTestWrapper(t)
+	w.transport.sleep = func(ctx context.Context, delay time.Duration) error { return nil }
+	playlist := server.AddPlaylist("user", "Favorites", fakespotify.NewTrack("a", "Song A", "Artist", 200000))
+	server.FailRequests(http.StatusBadGateway, defaultMaxRetries+1)
+
+	_, err := w.GetAllPlaylistTracks(context.Background(), playlist.ID)
+	assert.Error(t, err)
+	assert.Equal(t, 1, w.GetRequestStats().Failed)
+}
+
+func Test_CreatePlaylist(t *testing.T) {
+	w, server := newTestWrapper(t)
+
+	result, err := w.CreatePlaylist(context.Background(), "New Playlist", "description")
+	assert.NoError(t, err)
+	assert.Equal(t, "New Playlist", result.Name)
+	assert.Equal(t, "user", result.Owner.ID)
+	assert.Equal(t, result.ID, server.Playlist(result.ID).ID)
+}
+
+func Test_ReplacePlaylistTracks(t *testing.T) {
+	w, server := newTestWrapper(t)
+	var tracks []spotify.PlaylistTrack
+	var ids []spotify.ID
+	for i := 0; i < 250; i++ {
+		track := fakespotify.NewTrack(fmt.Sprintf("track%d", i), "Song", "Artist", 200000)
+		tracks = append(tracks, track)
+		ids = append(ids, track.Track.ID)
+	}
+	server.AddTracks(tracks...)
+	playlist := server.AddPlaylist("user", "Favorites", fakespotify.NewTrack("old", "Old Song", "Artist", 200000))
+
+	err := w.ReplacePlaylistTracks(context.Background(), playlist.ID, ids...)
+	assert.NoError(t, err)
+	assert.Equal(t, ids, server.TrackIDs(playlist.ID))
+	assert.Equal(t, 1, server.RequestCount("PUT /v1/playlists/"+string(playlist.ID)+"/tracks"))
+	assert.Equal(t, 2, server.RequestCount("POST /v1/playlists/"+string(playlist.ID)+"/tracks"))
+}
+
+func Test_SearchTracks(t *testing.T) {
+	w, server := newTestWrapper(t)
+	isrcTrack := fakespotify.NewTrack("track1", "Some Song", "Artist", 200000)
+	isrcTrack.Track.ExternalIDs = map[string]string{"isrc": "USABC2200001"}
+	server.AddTracks(isrcTrack, fakespotify.NewTrack("track2", "Some Song", "Other", 200000),
+		fakespotify.NewTrack("track3", "Another Song", "Artist", 200000))
+
+	result, err := w.SearchTracks(context.Background(), "isrc:USABC2200001", 10)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.Equal(t, spotify.ID("track1"), result[0].ID)
+
+	result, err = w.SearchTracks(context.Background(), `track:"some song" artist:"other"`, 10)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.Equal(t, spotify.ID("track2"), result[0].ID)
+
+	result, err = w.SearchTracks(context.Background(), "song artist", 1)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+}
@@ -0,0 +1,26 @@
+package spotifywrapper
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// codeVerifierSize is the number of random bytes in a code verifier, which encode to
+// 43 characters, the minimum length allowed
+const codeVerifierSize = 32
+
+// newCodeVerifier returns a random PKCE code verifier
+func newCodeVerifier() string {
+	b := make([]byte, codeVerifierSize)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// codeChallenge returns the S256 code challenge of a code verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -2,62 +2,209 @@ package spotifywrapper
 
 import (
 	"context"
+	"net/http"
+	"sort"
+	"strings"
 
+	"github.com/reeves122/spotify-automation-go/adapter"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
 )
 
-const state = "spotify-automation-go"
+const (
+	defaultAPIURL      = "https://api.spotify.com/v1/"
+	defaultAccountsURL = "https://accounts.spotify.com"
+)
+
+// maxTracksPerRequest is the maximum number of tracks which can be added or
+// removed in a single request
+const maxTracksPerRequest = 100
 
 type wrapper struct {
 	client *spotify.Client
-	auth   *spotifyauth.Authenticator
+	auth   *oauth2.Config
+
+	clientID     string
+	clientSecret string
+	pkce         bool   // authorize with a code verifier instead of the client secret
+	codeVerifier string // code verifier of the current authorization, PKCE only
+	apiURL       string // ex: "https://api.spotify.com/v1/"
+	accountsURL  string // ex: "https://accounts.spotify.com"
+
+	limiter       *rate.Limiter // shared by every request, nil for no limit
+	maxRetries    int
+	requestBudget int // maximum requests per run, 0 for no limit
+	transport     *retryTransport
+}
+
+// Option configures the wrapper
+type Option func(w *wrapper)
+
+// WithClientCredentials sets the client ID and secret of the Spotify app
+func WithClientCredentials(clientID string, clientSecret string) Option {
+	return func(w *wrapper) {
+		w.clientID = clientID
+		w.clientSecret = clientSecret
+	}
+}
+
+// WithPKCE authorizes the app with the PKCE flow, which proves the login with a code
+// verifier instead of the client secret, so the secret is not needed
+func WithPKCE() Option {
+	return func(w *wrapper) {
+		w.pkce = true
+	}
+}
+
+// WithBaseURLs points the wrapper at an alternative Web API and accounts service,
+// ex: a local fake for testing. Empty values keep the Spotify defaults.
+func WithBaseURLs(apiURL string, accountsURL string) Option {
+	return func(w *wrapper) {
+		if apiURL != "" {
+			w.apiURL = strings.TrimSuffix(apiURL, "/") + "/"
+		}
+		if accountsURL != "" {
+			w.accountsURL = strings.TrimSuffix(accountsURL, "/")
+		}
+	}
+}
+
+// WithRateLimit limits the requests made to Spotify, including token requests, to
+// the given number per second. Zero disables the limit.
+func WithRateLimit(requestsPerSecond int) Option {
+	return func(w *wrapper) {
+		w.limiter = nil
+		if requestsPerSecond > 0 {
+			w.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+		}
+	}
+}
+
+// WithRetries sets how many times a throttled or failed request is retried
+func WithRetries(maxRetries int) Option {
+	return func(w *wrapper) {
+		w.maxRetries = maxRetries
+	}
+}
+
+// WithRequestBudget limits the total number of requests made by the wrapper,
+// including retries. Zero disables the limit.
+func WithRequestBudget(requests int) Option {
+	return func(w *wrapper) {
+		w.requestBudget = requests
+	}
 }
 
-func NewWrapper(client spotify.Client, auth spotifyauth.Authenticator) *wrapper {
-	return &wrapper{
-		client: &client,
-		auth:   &auth,
+func NewWrapper(opts ...Option) *wrapper {
+	w := &wrapper{
+		client:      &spotify.Client{},
+		auth:        &oauth2.Config{},
+		apiURL:      defaultAPIURL,
+		accountsURL: defaultAccountsURL,
+		maxRetries:  defaultMaxRetries,
+	}
+	for _, opt := range opts {
+		opt(w)
 	}
+	w.transport = newRetryTransport(http.DefaultTransport, w.limiter, w.maxRetries, w.requestBudget)
+	return w
 }
 
+// CreateAuthenticator configures the OAuth flow of the app. With PKCE, a new code
+// verifier is generated for the authorization, which SetCodeVerifier can replace with
+// the verifier of an authorization started by an earlier run.
 func (w *wrapper) CreateAuthenticator(redirectURL string) {
-	var redirect = spotifyauth.WithRedirectURL(redirectURL)
-	var scopes = spotifyauth.WithScopes(
-		spotifyauth.ScopeUserLibraryRead,
-		spotifyauth.ScopeUserLibraryModify,
-		spotifyauth.ScopeUserReadRecentlyPlayed,
-		spotifyauth.ScopePlaylistReadPrivate,
-		spotifyauth.ScopePlaylistModifyPrivate,
-		spotifyauth.ScopePlaylistModifyPublic,
-	)
-	w.auth = spotifyauth.New(redirect, scopes)
+	clientSecret := w.clientSecret
+	authStyle := oauth2.AuthStyleAutoDetect
+	if w.pkce {
+		// the client ID is sent in the request body in place of the client credentials
+		clientSecret = ""
+		authStyle = oauth2.AuthStyleInParams
+		w.codeVerifier = newCodeVerifier()
+	}
+
+	w.auth = &oauth2.Config{
+		ClientID:     w.clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes: []string{
+			spotifyauth.ScopeUserLibraryRead,
+			spotifyauth.ScopeUserLibraryModify,
+			spotifyauth.ScopeUserReadRecentlyPlayed,
+			spotifyauth.ScopePlaylistReadPrivate,
+			spotifyauth.ScopePlaylistModifyPrivate,
+			spotifyauth.ScopePlaylistModifyPublic,
+		},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   w.accountsURL + "/authorize",
+			TokenURL:  w.accountsURL + "/api/token",
+			AuthStyle: authStyle,
+		},
+	}
+}
+
+// GetCodeVerifier returns the code verifier of the current authorization, or an empty
+// string if the PKCE flow is not used
+func (w *wrapper) GetCodeVerifier() string {
+	return w.codeVerifier
+}
+
+// SetCodeVerifier sets the code verifier the response code is exchanged with
+func (w *wrapper) SetCodeVerifier(verifier string) {
+	w.codeVerifier = verifier
 }
 
-func (w *wrapper) GetAuthURL() string {
-	return w.auth.AuthURL(state)
+// GetAuthURL returns the URL at which the user authorizes the app. Spotify passes the
+// state back to the redirect URL along with the code.
+func (w *wrapper) GetAuthURL(state string) string {
+	if !w.pkce {
+		return w.auth.AuthCodeURL(state)
+	}
+	return w.auth.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge(w.codeVerifier)))
 }
 
-func (w *wrapper) GetTokenFromResponseCode(responseCode string) (*oauth2.Token, error) {
-	return w.auth.Exchange(context.Background(), responseCode)
+func (w *wrapper) GetTokenFromResponseCode(ctx context.Context, responseCode string) (*oauth2.Token, error) {
+	if !w.pkce {
+		return w.auth.Exchange(w.withHTTPClient(ctx), responseCode)
+	}
+	return w.auth.Exchange(w.withHTTPClient(ctx), responseCode,
+		oauth2.SetAuthURLParam("code_verifier", w.codeVerifier))
 }
 
-func (w *wrapper) LoginAndCreateClient(token *oauth2.Token) {
-	client := spotify.New(w.auth.Client(context.Background(), token), spotify.WithRetry(true))
+// LoginAndCreateClient creates the client of the logged in user. The token is
+// refreshed as it expires, and each refreshed token is passed to saveToken.
+func (w *wrapper) LoginAndCreateClient(ctx context.Context, token *oauth2.Token, saveToken func(token *oauth2.Token) error) {
+	ctx = w.withHTTPClient(ctx)
+	source := newSavingTokenSource(w.auth.TokenSource(ctx, token), token, saveToken)
+	client := spotify.New(oauth2.NewClient(ctx, source),
+		spotify.WithBaseURL(w.apiURL))
 	w.client = client
 }
 
+// withHTTPClient returns a context which makes oauth2 send requests through the
+// wrapper's transport
+func (w *wrapper) withHTTPClient(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: w.transport})
+}
+
+// GetRequestStats returns the counts of requests made to Spotify so far
+func (w *wrapper) GetRequestStats() adapter.RequestStats {
+	return w.transport.Stats()
+}
+
 func (w *wrapper) GetToken() (*oauth2.Token, error) {
 	newtoken, err := w.client.Token()
 	return newtoken, err
 }
 
-func (w *wrapper) GetAllPlaylistsForUser(username string) ([]spotify.SimplePlaylist, error) {
+func (w *wrapper) GetAllPlaylistsForUser(ctx context.Context, username string) ([]spotify.SimplePlaylist, error) {
 	log.Infof("Getting list of playlists for user: %s", username)
-	ctx := context.Background()
 	playlists, err := w.client.GetPlaylistsForUser(ctx, username, spotify.Limit(50))
 	if err != nil {
 		return nil, err
@@ -85,8 +232,7 @@ func (w *wrapper) GetAllPlaylistsForUser(username string) ([]spotify.SimplePlayl
 	return allPlaylists, nil
 }
 
-func (w *wrapper) GetAllPlaylistTracks(playlistID spotify.ID) ([]spotify.PlaylistTrack, error) {
-	ctx := context.Background()
+func (w *wrapper) GetAllPlaylistTracks(ctx context.Context, playlistID spotify.ID) ([]spotify.PlaylistTrack, error) {
 	tracks, err := w.client.GetPlaylistTracks(ctx, playlistID)
 	if err != nil {
 		return nil, err
@@ -114,9 +260,143 @@ func (w *wrapper) GetAllPlaylistTracks(playlistID spotify.ID) ([]spotify.Playlis
 	return allTracks, nil
 }
 
-func (w *wrapper) RemoveTracksFromPlaylist(playlistID spotify.ID, trackIDs ...spotify.ID) error {
-	log.Debugf("Removing tracks %s from playlist %s", trackIDs, playlistID)
-	ctx := context.Background()
-	_, err := w.client.RemoveTracksFromPlaylist(ctx, playlistID, trackIDs...)
-	return err
+// RemoveTracksFromPlaylist removes every occurrence of the tracks from a playlist,
+// in batches of the maximum number of tracks the API accepts per request
+func (w *wrapper) RemoveTracksFromPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) error {
+	for _, batch := range batchTrackIDs(trackIDs) {
+		log.Debugf("Removing tracks %s from playlist %s", batch, playlistID)
+		_, err := w.client.RemoveTracksFromPlaylist(ctx, playlistID, batch...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreatePlaylist creates a private playlist owned by the logged in user
+func (w *wrapper) CreatePlaylist(ctx context.Context, name string, description string) (spotify.SimplePlaylist, error) {
+	user, err := w.client.CurrentUser(ctx)
+	if err != nil {
+		return spotify.SimplePlaylist{}, err
+	}
+
+	log.Infof("Creating playlist %s for user %s", name, user.ID)
+	playlist, err := w.client.CreatePlaylistForUser(ctx, user.ID, name, description, false, false)
+	if err != nil {
+		return spotify.SimplePlaylist{}, err
+	}
+	return playlist.SimplePlaylist, nil
+}
+
+// AddTracksToPlaylist appends the tracks to a playlist in order, in batches of the
+// maximum number of tracks the API accepts per request
+func (w *wrapper) AddTracksToPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) error {
+	for _, batch := range batchTrackIDs(trackIDs) {
+		log.Debugf("Adding %d tracks to playlist %s", len(batch), playlistID)
+		_, err := w.client.AddTracksToPlaylist(ctx, playlistID, batch...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplacePlaylistTracks replaces every track of a playlist with the given tracks in
+// order. The API replaces at most one batch of tracks, so the rest are added after it.
+func (w *wrapper) ReplacePlaylistTracks(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) error {
+	first := trackIDs
+	if len(first) > maxTracksPerRequest {
+		first = first[:maxTracksPerRequest]
+	}
+
+	log.Debugf("Replacing tracks of playlist %s with %d tracks", playlistID, len(trackIDs))
+	err := w.client.ReplacePlaylistTracks(ctx, playlistID, first...)
+	if err != nil {
+		return err
+	}
+	return w.AddTracksToPlaylist(ctx, playlistID, trackIDs[len(first):]...)
+}
+
+// SearchTracks returns the first tracks found by a search query, which may use
+// field filters such as isrc:, track: and artist:
+func (w *wrapper) SearchTracks(ctx context.Context, query string, limit int) ([]spotify.FullTrack, error) {
+	log.Debugf("Searching for tracks: %s", query)
+	result, err := w.client.Search(ctx, query, spotify.SearchTypeTrack, spotify.Limit(limit))
+	if err != nil {
+		return nil, err
+	}
+	if result.Tracks == nil {
+		return nil, nil
+	}
+	return result.Tracks.Tracks, nil
+}
+
+// batchTrackIDs splits track IDs into batches of at most maxTracksPerRequest
+func batchTrackIDs(trackIDs []spotify.ID) [][]spotify.ID {
+	var batches [][]spotify.ID
+	for start := 0; start < len(trackIDs); start += maxTracksPerRequest {
+		end := start + maxTracksPerRequest
+		if end > len(trackIDs) {
+			end = len(trackIDs)
+		}
+		batches = append(batches, trackIDs[start:end])
+	}
+	return batches
+}
+
+// RemoveTrackPositionsFromPlaylist removes the tracks at specific positions of the given
+// snapshot of a playlist. Positions are removed from the end of the playlist first, so
+// that each batch leaves the positions of the following batches unchanged. Returns the
+// snapshot ID of the playlist after the last batch.
+func (w *wrapper) RemoveTrackPositionsFromPlaylist(ctx context.Context, playlistID spotify.ID, snapshotID string, tracks ...spotify.TrackToRemove) (string, error) {
+	for _, batch := range batchTrackPositions(tracks) {
+		log.Debugf("Removing %d track positions from playlist %s at snapshot %s", len(batch), playlistID, snapshotID)
+		newSnapshotID, err := w.client.RemoveTracksFromPlaylistOpt(ctx, playlistID, batch, snapshotID)
+		if err != nil {
+			return "", err
+		}
+		snapshotID = newSnapshotID
+	}
+	return snapshotID, nil
+}
+
+// batchTrackPositions splits the positions to remove into batches of at most
+// maxTracksPerRequest positions, starting with the highest positions
+func batchTrackPositions(tracks []spotify.TrackToRemove) [][]spotify.TrackToRemove {
+	type trackPosition struct {
+		uri      string
+		position int
+	}
+
+	var positions []trackPosition
+	for _, track := range tracks {
+		for _, position := range track.Positions {
+			positions = append(positions, trackPosition{uri: track.URI, position: position})
+		}
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].position > positions[j].position
+	})
+
+	var batches [][]spotify.TrackToRemove
+	for start := 0; start < len(positions); start += maxTracksPerRequest {
+		end := start + maxTracksPerRequest
+		if end > len(positions) {
+			end = len(positions)
+		}
+
+		var batch []spotify.TrackToRemove
+		index := map[string]int{}
+		for _, p := range positions[start:end] {
+			i, present := index[p.uri]
+			if !present {
+				i = len(batch)
+				index[p.uri] = i
+				batch = append(batch, spotify.TrackToRemove{URI: p.uri})
+			}
+			batch[i].Positions = append(batch[i].Positions, p.position)
+		}
+		batches = append(batches, batch)
+	}
+	return batches
 }
@@ -0,0 +1,35 @@
+package spotifywrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+func Test_BatchTrackPositions(t *testing.T) {
+	tracks := []spotify.TrackToRemove{
+		spotify.NewTrackToRemove("a", []int{0, 5}),
+		spotify.NewTrackToRemove("b", []int{3}),
+	}
+
+	result := batchTrackPositions(tracks)
+	assert.Equal(t, [][]spotify.TrackToRemove{{
+		spotify.NewTrackToRemove("a", []int{5, 0}),
+		spotify.NewTrackToRemove("b", []int{3}),
+	}}, result)
+}
+
+func Test_BatchTrackPositions_Split(t *testing.T) {
+	var positions []int
+	for i := 0; i < 250; i++ {
+		positions = append(positions, i)
+	}
+
+	result := batchTrackPositions([]spotify.TrackToRemove{spotify.NewTrackToRemove("a", positions)})
+	assert.Len(t, result, 3)
+	assert.Len(t, result[0][0].Positions, 100)
+	assert.Len(t, result[2][0].Positions, 50)
+	assert.Equal(t, 249, result[0][0].Positions[0])
+	assert.Equal(t, 0, result[2][0].Positions[49])
+}
@@ -0,0 +1,75 @@
+package spotifywrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// ErrReauthorizationRequired is returned for requests made after Spotify rejected the
+// refresh token, ex. when the app's access was revoked or a rotated token was lost
+var ErrReauthorizationRequired = errors.New("re-authorization required: the saved refresh token was " +
+	"rejected, run auth login or remove the token file and log in again")
+
+// savingTokenSource hands out the token of the logged in client from a source which
+// refreshes it when it expires, and saves every refreshed token so that a rotated
+// refresh token is never lost
+type savingTokenSource struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+	last   string // access token last handed out
+	save   func(token *oauth2.Token) error
+}
+
+func newSavingTokenSource(source oauth2.TokenSource, token *oauth2.Token, save func(token *oauth2.Token) error) *savingTokenSource {
+	return &savingTokenSource{
+		source: source,
+		last:   token.AccessToken,
+		save:   save,
+	}
+}
+
+// Token returns the current token. A refreshed token which fails to save is still
+// used, so that the run can go on.
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.source.Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && refreshRejected(retrieveErr) {
+			return nil, fmt.Errorf("%w: %v", ErrReauthorizationRequired, err)
+		}
+		return nil, err
+	}
+
+	if token.AccessToken != s.last && s.save != nil {
+		if err := s.save(token); err != nil {
+			log.Errorf("Unable to save refreshed token: %v", err)
+		}
+	}
+	s.last = token.AccessToken
+	return token, nil
+}
+
+// refreshRejected reports whether Spotify rejected the refresh token itself, with an
+// invalid_grant error, rather than failing to refresh it, ex. when it is overloaded,
+// rate limiting or rejecting the request for another reason, which a later run can retry
+func refreshRejected(err *oauth2.RetrieveError) bool {
+	if err.Response == nil {
+		return false
+	}
+	if err.Response.StatusCode != http.StatusBadRequest && err.Response.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	return json.Unmarshal(err.Body, &body) == nil && body.Error == "invalid_grant"
+}
@@ -0,0 +1,112 @@
+package spotifywrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/mocks/fakespotify"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// newExpiredTokenWrapper creates a wrapper logged in to a fake Spotify server with an
+// expired token, which is refreshed with the given refresh token
+func newExpiredTokenWrapper(t *testing.T, refreshToken string, save func(token *oauth2.Token) error) *wrapper {
+	server := fakespotify.NewServer()
+	t.Cleanup(server.Close)
+	server.AddRefreshToken("refresh")
+
+	w := NewWrapper(WithClientCredentials("id", "secret"), WithBaseURLs(server.APIURL(), server.AccountsURL()))
+	w.CreateAuthenticator("http://localhost/callback")
+	w.LoginAndCreateClient(context.Background(), &oauth2.Token{
+		AccessToken:  "expired",
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		Expiry:       time.Now().Add(-time.Minute),
+	}, save)
+	return w
+}
+
+func Test_SavingTokenSource_SavesRefreshedToken(t *testing.T) {
+	var saved []*oauth2.Token
+	w := newExpiredTokenWrapper(t, "refresh", func(token *oauth2.Token) error {
+		saved = append(saved, token)
+		return nil
+	})
+
+	token, err := w.GetToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, "expired", token.AccessToken)
+	_, err = w.GetAllPlaylistsForUser(context.Background(), "user")
+	assert.NoError(t, err)
+
+	// the token is saved once, when it is refreshed
+	assert.Len(t, saved, 1)
+	assert.Equal(t, token.AccessToken, saved[0].AccessToken)
+}
+
+func Test_SavingTokenSource_SaveError(t *testing.T) {
+	w := newExpiredTokenWrapper(t, "refresh", func(token *oauth2.Token) error {
+		return fmt.Errorf("test error")
+	})
+
+	_, err := w.GetAllPlaylistsForUser(context.Background(), "user")
+	assert.NoError(t, err)
+}
+
+func Test_SavingTokenSource_Rejected(t *testing.T) {
+	w := newExpiredTokenWrapper(t, "revoked", func(token *oauth2.Token) error {
+		t.Error("no token should be saved")
+		return nil
+	})
+
+	_, err := w.GetAllPlaylistsForUser(context.Background(), "user")
+	assert.ErrorIs(t, err, ErrReauthorizationRequired)
+	_, err = w.GetToken()
+	assert.ErrorIs(t, err, ErrReauthorizationRequired)
+}
+
+// failingTokenSource fails to refresh the token with the given error
+type failingTokenSource struct {
+	err error
+}
+
+func (s failingTokenSource) Token() (*oauth2.Token, error) {
+	return nil, s.err
+}
+
+// Test_SavingTokenSource_RefreshFailed tests that only a rejected refresh token requires
+// re-authorization, and that other failures to refresh are returned as they are
+func Test_SavingTokenSource_RefreshFailed(t *testing.T) {
+	tests := []struct {
+		status      int
+		body        string
+		reauthorize bool
+	}{
+		{http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Invalid refresh token"}`, true},
+		{http.StatusUnauthorized, `{"error":"invalid_grant"}`, true},
+		{http.StatusBadRequest, `{"error":"invalid_request"}`, false},
+		{http.StatusUnauthorized, `{"error":"invalid_client"}`, false},
+		{http.StatusBadRequest, `Bad Request`, false},
+		{http.StatusTooManyRequests, `{"error":"invalid_grant"}`, false},
+		{http.StatusInternalServerError, ``, false},
+		{http.StatusServiceUnavailable, ``, false},
+	}
+	for _, test := range tests {
+		refreshErr := &oauth2.RetrieveError{
+			Response: &http.Response{StatusCode: test.status, Status: http.StatusText(test.status)},
+			Body:     []byte(test.body),
+		}
+		source := newSavingTokenSource(failingTokenSource{err: refreshErr}, &oauth2.Token{AccessToken: "expired"}, nil)
+
+		_, err := source.Token()
+		assert.Equal(t, test.reauthorize, errors.Is(err, ErrReauthorizationRequired), test)
+		if !test.reauthorize {
+			assert.ErrorIs(t, err, refreshErr, test)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package spotifywrapper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/adapter"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMaxRetries = 5
+	baseRetryDelay    = 500 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+)
+
+// ErrRequestBudgetExceeded is returned for every request once a run has made the
+// maximum number of requests allowed by its budget
+var ErrRequestBudgetExceeded = errors.New("spotify request budget exceeded")
+
+// retryTransport sends every request to Spotify. It keeps requests within a shared
+// rate limit, waits for the Retry-After delay of throttled (429) responses, retries
+// failed connections and the server errors of idempotent requests with exponential
+// backoff and jitter, and stops sending requests once the request budget is used up.
+// A POST which got a server error may have been applied, ex. adding tracks to a
+// playlist, so it is not sent again.
+type retryTransport struct {
+	base       http.RoundTripper
+	limiter    *rate.Limiter // nil for no limit
+	maxRetries int
+	budget     int // maximum requests per run, 0 for no limit
+
+	// sleep waits for the delay before a retry, replaced in tests
+	sleep func(ctx context.Context, delay time.Duration) error
+
+	mu    sync.Mutex
+	stats adapter.RequestStats
+}
+
+func newRetryTransport(base http.RoundTripper, limiter *rate.Limiter, maxRetries int, budget int) *retryTransport {
+	return &retryTransport{
+		base:       base,
+		limiter:    limiter,
+		maxRetries: maxRetries,
+		budget:     budget,
+		sleep:      sleepContext,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, errors.New("unable to retry request without a replayable body")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		resp, err := t.send(req)
+		if err != nil {
+			if !connectionFailed(err) {
+				return nil, err
+			}
+			if attempt >= t.maxRetries {
+				t.count(func(s *adapter.RequestStats) { s.Failed++ })
+				return nil, err
+			}
+			delay := backoff(attempt)
+			log.Warnf("Unable to connect to Spotify for %s %s, retrying in %s: %v", req.Method, req.URL.Path, delay, err)
+			t.count(func(s *adapter.RequestStats) { s.Retried++ })
+			if err := t.sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var delay time.Duration
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			t.count(func(s *adapter.RequestStats) { s.Throttled++ })
+			delay = retryAfter(resp, attempt)
+		case resp.StatusCode >= http.StatusInternalServerError && req.Method == http.MethodPost:
+			t.count(func(s *adapter.RequestStats) { s.Failed++ })
+			return resp, nil
+		case resp.StatusCode >= http.StatusInternalServerError:
+			delay = backoff(attempt)
+		default:
+			return resp, nil
+		}
+
+		if attempt >= t.maxRetries {
+			t.count(func(s *adapter.RequestStats) { s.Failed++ })
+			return resp, nil
+		}
+
+		log.Warnf("Spotify responded %s to %s %s, retrying in %s", resp.Status, req.Method, req.URL.Path, delay)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		t.count(func(s *adapter.RequestStats) { s.Retried++ })
+
+		err = t.sleep(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send makes a single attempt of a request, if the budget and rate limit allow it
+func (t *retryTransport) send(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	if t.budget > 0 && t.stats.Requests >= t.budget {
+		t.mu.Unlock()
+		return nil, ErrRequestBudgetExceeded
+	}
+	t.stats.Requests++
+	t.mu.Unlock()
+
+	if t.limiter != nil {
+		err := t.limiter.Wait(req.Context())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (t *retryTransport) count(update func(s *adapter.RequestStats)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	update(&t.stats)
+}
+
+// Stats returns the counts of requests made so far
+func (t *retryTransport) Stats() adapter.RequestStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// connectionFailed reports whether a request failed to connect, so it was never sent
+// and can be retried whatever its method
+func connectionFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryAfter returns the delay requested by the Retry-After header of a throttled
+// response, given either in seconds or as a date, capped at the maximum retry delay so
+// that a bad header can't stall the run. Without a valid header the exponential
+// backoff is used.
+func retryAfter(resp *http.Response, attempt int) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		if seconds > int(maxRetryDelay/time.Second) {
+			return maxRetryDelay
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	} else {
+		return backoff(attempt)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// backoff returns the delay before the given retry attempt: the base delay doubled
+// for every attempt, capped at the maximum, with a random jitter of up to half of it
+func backoff(attempt int) time.Duration {
+	delay := maxRetryDelay
+	if attempt < 16 {
+		delay = baseRetryDelay << attempt
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// sleepContext waits for the delay or until the context is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
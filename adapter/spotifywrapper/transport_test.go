@@ -0,0 +1,175 @@
+package spotifywrapper
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/adapter"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+// newScriptedServer responds to each request with the next status code, then 200
+func newScriptedServer(t *testing.T, statuses ...int) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+
+		if len(bodies) > len(statuses) {
+			return
+		}
+		status := statuses[len(bodies)-1]
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &bodies
+}
+
+// newTestTransport creates a transport which records its delays instead of sleeping
+func newTestTransport(limiter *rate.Limiter, maxRetries int, budget int) (*retryTransport, *[]time.Duration) {
+	var delays []time.Duration
+	transport := newRetryTransport(http.DefaultTransport, limiter, maxRetries, budget)
+	transport.sleep = func(ctx context.Context, delay time.Duration) error {
+		delays = append(delays, delay)
+		return ctx.Err()
+	}
+	return transport, &delays
+}
+
+func Test_RetryTransport_RetryAfter(t *testing.T) {
+	server, bodies := newScriptedServer(t, http.StatusTooManyRequests, http.StatusTooManyRequests)
+	transport, delays := newTestTransport(nil, 5, 0)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"a":1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []time.Duration{7 * time.Second, 7 * time.Second}, *delays)
+	assert.Equal(t, []string{`{"a":1}`, `{"a":1}`, `{"a":1}`}, *bodies)
+	assert.Equal(t, adapter.RequestStats{Requests: 3, Throttled: 2, Retried: 2}, transport.Stats())
+}
+
+func Test_RetryTransport_ServerErrorBackoff(t *testing.T) {
+	server, _ := newScriptedServer(t, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	transport, delays := newTestTransport(nil, 2, 0)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Len(t, *delays, 2)
+	assert.True(t, (*delays)[0] >= baseRetryDelay/2 && (*delays)[0] <= baseRetryDelay)
+	assert.True(t, (*delays)[1] >= baseRetryDelay && (*delays)[1] <= 2*baseRetryDelay)
+	assert.Equal(t, adapter.RequestStats{Requests: 3, Retried: 2, Failed: 1}, transport.Stats())
+}
+
+// Test_RetryTransport_NoRetryOnPostServerError tests that a POST which may have been
+// applied is not sent again
+func Test_RetryTransport_NoRetryOnPostServerError(t *testing.T) {
+	server, bodies := newScriptedServer(t, http.StatusBadGateway)
+	transport, delays := newTestTransport(nil, 5, 0)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"a":1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Empty(t, *delays)
+	assert.Len(t, *bodies, 1)
+	assert.Equal(t, adapter.RequestStats{Requests: 1, Failed: 1}, transport.Stats())
+}
+
+// Test_RetryTransport_ConnectionFailed tests that a request which never connected is
+// retried, including a POST
+func Test_RetryTransport_ConnectionFailed(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	url := "http://" + listener.Addr().String()
+	_ = listener.Close()
+	transport, delays := newTestTransport(nil, 2, 0)
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Post(url, "application/json", strings.NewReader(`{"a":1}`))
+	assert.Error(t, err)
+	assert.Len(t, *delays, 2)
+	assert.Equal(t, adapter.RequestStats{Requests: 3, Retried: 2, Failed: 1}, transport.Stats())
+}
+
+func Test_RetryTransport_NoRetryOnClientError(t *testing.T) {
+	server, _ := newScriptedServer(t, http.StatusBadRequest)
+	transport, delays := newTestTransport(nil, 5, 0)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, *delays)
+	assert.Equal(t, adapter.RequestStats{Requests: 1}, transport.Stats())
+}
+
+func Test_RetryTransport_Budget(t *testing.T) {
+	server, bodies := newScriptedServer(t, http.StatusBadGateway)
+	transport, _ := newTestTransport(nil, 5, 2)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+
+	_, err = client.Get(server.URL)
+	assert.ErrorIs(t, err, ErrRequestBudgetExceeded)
+	assert.Len(t, *bodies, 2)
+}
+
+func Test_RetryTransport_RateLimit(t *testing.T) {
+	server, bodies := newScriptedServer(t)
+	transport, _ := newTestTransport(rate.NewLimiter(rate.Every(time.Hour), 1), 5, 0)
+	client := &http.Client{Transport: transport}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+
+	// the next request is not allowed before the context expires
+	_, err = client.Do(req)
+	assert.Error(t, err)
+	assert.Len(t, *bodies, 1)
+}
+
+func Test_RetryAfter_Date(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Retry-After", time.Now().Add(20*time.Second).UTC().Format(http.TimeFormat))
+
+	delay := retryAfter(resp, 0)
+	assert.True(t, delay > 15*time.Second && delay <= 20*time.Second)
+}
+
+// Test_RetryAfter_Max tests that a delay beyond the maximum retry delay is capped
+func Test_RetryAfter_Max(t *testing.T) {
+	for _, value := range []string{"86400", "99999999999999", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)} {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Retry-After", value)
+		assert.Equal(t, maxRetryDelay, retryAfter(resp, 0), value)
+	}
+}
+
+func Test_Backoff_Max(t *testing.T) {
+	assert.True(t, backoff(30) <= maxRetryDelay)
+	assert.True(t, backoff(30) >= maxRetryDelay/2)
+}
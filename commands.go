@@ -0,0 +1,791 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/adapter"
+	"github.com/reeves122/spotify-automation-go/adapter/spotifywrapper"
+	"github.com/reeves122/spotify-automation-go/config"
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/auth"
+	"github.com/reeves122/spotify-automation-go/service/diff"
+	"github.com/reeves122/spotify-automation-go/service/formats"
+	"github.com/reeves122/spotify-automation-go/service/musicbrainz"
+	"github.com/reeves122/spotify-automation-go/service/plan"
+	"github.com/reeves122/spotify-automation-go/service/storage"
+	"github.com/reeves122/spotify-automation-go/service/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+)
+
+// loginKeys are the config keys needed by every command which logs in
+var loginKeys = []string{"user_name", "spotify_id", "spotify_secret", "redirect_url", "token_file", "cache_dir"}
+
+// loginFlags are the flags of every command which logs in
+var loginFlags = []string{"user", "redirect-url", "token-file", "token-key-file", "cache-dir", "auth-flow", "storage", "sqlite-file", "compression", "lock-wait", "rate-limit", "max-retries", "request-budget"}
+
+// syncFlags are the flags of every command which downloads playlists
+var syncFlags = []string{"workers"}
+
+// planFlags are the flags of every command which can do a dry run
+var planFlags = []string{"dry-run", "plan-format", "plan-file"}
+
+// commandLine parses the flags of a command and loads the config
+type commandLine struct {
+	fs         *flag.FlagSet
+	configFile string
+	flags      map[string]string // flags which were set, keyed by config key
+}
+
+// flagValue records the value of a flag for a config key
+type flagValue struct {
+	flags  map[string]string
+	key    string
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	return ""
+}
+
+func (f *flagValue) Set(value string) error {
+	f.flags[f.key] = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// newCommandLine creates the flag set of a command with the -config flag and the
+// given config flags
+func newCommandLine(name string, description string, flagNames ...string) *commandLine {
+	c := &commandLine{
+		fs:    flag.NewFlagSet(name, flag.ContinueOnError),
+		flags: map[string]string{},
+	}
+	c.fs.Usage = func() {
+		_, _ = fmt.Fprintf(c.fs.Output(), "Usage: spotify-automation-go %s [flags]\n\n%s\n\nFlags:\n", name, description)
+		c.fs.PrintDefaults()
+	}
+	c.fs.StringVar(&c.configFile, "config", os.Getenv("CONFIG_FILE"), "YAML config file (env CONFIG_FILE)")
+
+	defaults := config.Default()
+	for _, flagName := range flagNames {
+		for _, option := range config.Options {
+			if option.Flag != flagName {
+				continue
+			}
+			value := &flagValue{flags: c.flags, key: option.Key, isBool: config.IsBool(option.Key)}
+			c.fs.Var(value, option.Flag, fmt.Sprintf("%s (env %s)", option.Usage, option.Env))
+			if def := defaults.Get(option.Key); def != "" && !value.isBool {
+				c.fs.Lookup(option.Flag).DefValue = fmt.Sprintf("%q", def)
+			}
+		}
+	}
+	return c
+}
+
+// load parses the arguments, loads the config and checks that every required key is set
+func (c *commandLine) load(args []string, required ...string) (*config.Config, error) {
+	err := c.fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.Load(c.configFile, os.Getenv, c.flags)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Auth.Flow == "pkce" {
+		// the PKCE flow proves the login without the client secret
+		required = slices.DeleteFunc(slices.Clone(required), func(key string) bool {
+			return key == "spotify_secret"
+		})
+	}
+	err = cfg.Require(required...)
+	if err != nil {
+		c.fs.Usage()
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// utilService is the part of the util service used by the commands
+type utilService interface {
+	Plan() *plan.Plan
+	ApplyPlan(ctx context.Context, p *plan.Plan) error
+	GetAllPlaylistsForUser(ctx context.Context, username string) ([]spotify.SimplePlaylist, error)
+	UpdateLocalCache(ctx context.Context, playlists []spotify.SimplePlaylist) error
+	BackupPlaylists(ctx context.Context, playlists []spotify.SimplePlaylist) error
+	GetCacheStatus(ctx context.Context, playlists []spotify.SimplePlaylist) ([]util.CacheStatus, error)
+	LoadAllDislikedTracks(ctx context.Context, playlists []spotify.SimplePlaylist) ([]spotify.PlaylistTrack, error)
+	ScanPlaylistsForDislikedTracks(ctx context.Context, playlists []spotify.SimplePlaylist, disliked []spotify.PlaylistTrack, username string) error
+	ProcessQueuePlaylists(ctx context.Context, playlists []spotify.SimplePlaylist, username string) error
+	FindPossibleDuplicateTracks(ctx context.Context, playlists []spotify.SimplePlaylist, username string) ([]util.DuplicateReport, error)
+	RestorePlaylist(ctx context.Context, playlists []spotify.SimplePlaylist, username string, name string, asNew bool, newName string) error
+	ExportPlaylists(ctx context.Context, playlists []spotify.SimplePlaylist, dir string, format string, options formats.ExportOptions) error
+	ImportPlaylist(ctx context.Context, fileName string, minConfidence float64) (*service.CachedPlaylist, error)
+	ImportCSVPlaylist(ctx context.Context, playlists []spotify.SimplePlaylist, username string, fileName string, name string, minConfidence float64) (*util.ImportReport, error)
+	DiffPlaylist(ctx context.Context, playlists []spotify.SimplePlaylist, name string, from time.Time, to time.Time) (*diff.Diff, error)
+}
+
+// session is a logged in set of services
+type session struct {
+	spotify  adapter.SpotifyWrapperInterface
+	storage  service.StorageInterface
+	util     utilService
+	username string
+	cfg      *config.Config
+	lock     io.Closer
+}
+
+// login logs in to Spotify and creates the services used by every command
+func login(ctx context.Context, cfg *config.Config) (*session, error) {
+	return openSession(ctx, cfg, false)
+}
+
+// openSession logs in to Spotify, with the saved token or, with callback, by receiving
+// the authorization callback, and creates the services used by every command
+func openSession(ctx context.Context, cfg *config.Config, callback bool) (*session, error) {
+	opts := []spotifywrapper.Option{
+		spotifywrapper.WithClientCredentials(cfg.SpotifyID, cfg.SpotifySecret),
+		spotifywrapper.WithBaseURLs(cfg.APIURL, cfg.AccountsURL),
+		spotifywrapper.WithRateLimit(cfg.RateLimit),
+		spotifywrapper.WithRetries(cfg.MaxRetries),
+		spotifywrapper.WithRequestBudget(cfg.RequestBudget),
+	}
+	if cfg.Auth.Flow == "pkce" {
+		opts = append(opts, spotifywrapper.WithPKCE())
+	}
+	wrapper := spotifywrapper.NewWrapper(opts...)
+	cacheLock, err := storage.Lock(ctx, cfg.CacheDir, time.Duration(cfg.Storage.LockWait)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	storageService, err := newStorage(ctx, cfg)
+	if err != nil {
+		closeLock(cacheLock)
+		return nil, err
+	}
+	authService := auth.NewAuth(wrapper, storageService)
+	if callback {
+		err = authService.LoginWithCallback(ctx, cfg.RedirectURL, cfg.Auth.CallbackListen, cfg.TokenFile,
+			time.Duration(cfg.Auth.CallbackTimeout)*time.Second)
+	} else {
+		err = authService.Login(ctx, cfg.RedirectURL, cfg.TokenFile)
+	}
+	if err != nil {
+		closeStorage(storageService)
+		closeLock(cacheLock)
+		return nil, err
+	}
+
+	return &session{
+		spotify:  wrapper,
+		storage:  storageService,
+		util:     util.NewUtil(wrapper, storageService, cfg.DislikedPrefix, cfg.QueueSuffix, cfg.Dedupe.Remove, cfg.DryRun, cfg.Sync.Workers),
+		username: cfg.UserName,
+		cfg:      cfg,
+		lock:     cacheLock,
+	}, nil
+}
+
+// newStorage creates the storage of the configured cache backend
+func newStorage(ctx context.Context, cfg *config.Config) (service.StorageInterface, error) {
+	secret, err := tokenSecret(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts := []storage.Option{
+		storage.WithHistoryRetention(cfg.History.MaxVersions, time.Duration(cfg.History.MaxAgeDays)*24*time.Hour),
+		storage.WithCompression(cfg.Storage.Compression),
+		storage.WithTokenSecret(secret),
+	}
+	if cfg.Storage.Backend == "sqlite" {
+		return storage.NewSQLiteStorage(ctx, cfg.CacheDir, cfg.Storage.SQLiteFile, opts...)
+	}
+	return storage.NewStorage(cfg.CacheDir, false, opts...), nil
+}
+
+// tokenSecret returns the passphrase or key file contents the auth token is encrypted
+// with, or nil if neither is configured
+func tokenSecret(cfg *config.Config) ([]byte, error) {
+	if cfg.TokenPassphrase != "" {
+		return []byte(cfg.TokenPassphrase), nil
+	}
+	if cfg.TokenKeyFile == "" {
+		return nil, nil
+	}
+
+	key, err := os.ReadFile(cfg.TokenKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token key file: %w", err)
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) == 0 {
+		return nil, fmt.Errorf("token key file %s is empty", cfg.TokenKeyFile)
+	}
+	return key, nil
+}
+
+// closeStorage closes the storage if its backend needs closing
+func closeStorage(storageService service.StorageInterface) {
+	if closer, ok := storageService.(io.Closer); ok {
+		err := closer.Close()
+		if err != nil {
+			log.Errorf("Failed to close the cache: %v", err)
+		}
+	}
+}
+
+// closeLock releases the lock on the cache dir
+func closeLock(lock io.Closer) {
+	err := lock.Close()
+	if err != nil {
+		log.Errorf("Failed to unlock the cache dir: %v", err)
+	}
+}
+
+// close logs the counts of requests made to Spotify during the run, closes the storage
+// and releases the lock on the cache dir
+func (s *session) close() {
+	defer closeLock(s.lock)
+	defer closeStorage(s.storage)
+	stats := s.spotify.GetRequestStats()
+	log.WithFields(log.Fields{
+		"requests":  stats.Requests,
+		"throttled": stats.Throttled,
+		"retried":   stats.Retried,
+		"failed":    stats.Failed}).
+		Info("Spotify API requests made")
+}
+
+// syncPlaylists gets every playlist of the user and updates the local cache
+func (s *session) syncPlaylists(ctx context.Context) ([]spotify.SimplePlaylist, error) {
+	playlists, err := s.util.GetAllPlaylistsForUser(ctx, s.username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.util.UpdateLocalCache(ctx, playlists)
+	if err != nil {
+		return nil, err
+	}
+	return playlists, nil
+}
+
+// writePlan prints and saves the plan of a dry run
+func (s *session) writePlan() error {
+	if !s.cfg.DryRun {
+		return nil
+	}
+
+	err := s.util.Plan().Write(os.Stdout, s.cfg.Plan.Format)
+	if err != nil {
+		return err
+	}
+
+	if s.cfg.Plan.File != "" {
+		return s.util.Plan().Save(s.cfg.Plan.File)
+	}
+	return nil
+}
+
+func runAll(ctx context.Context, args []string) error {
+	cl := newCommandLine("run", "Run the full pipeline: sync, prune-disliked, process-queues and dedupe.\n"+
+		"Steps can be disabled with the features section of the config.",
+		flagList(loginFlags, syncFlags, planFlags, []string{"disliked-prefix", "queue-suffix", "remove"})...)
+	cfg, err := cl.load(args, loginKeys...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	playlists, err := s.syncPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Features.PruneDisliked {
+		err = s.pruneDisliked(ctx, playlists)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Features.ProcessQueues {
+		err = s.util.ProcessQueuePlaylists(ctx, playlists, s.username)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Features.Dedupe {
+		_, err = s.util.FindPossibleDuplicateTracks(ctx, playlists, s.username)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.writePlan()
+	if err != nil {
+		return err
+	}
+
+	log.Info("Done processing!")
+	return nil
+}
+
+// pruneDisliked removes the tracks of all disliked playlists from every other playlist
+func (s *session) pruneDisliked(ctx context.Context, playlists []spotify.SimplePlaylist) error {
+	disliked, err := s.util.LoadAllDislikedTracks(ctx, playlists)
+	if err != nil {
+		return err
+	}
+
+	return s.util.ScanPlaylistsForDislikedTracks(ctx, playlists, disliked, s.username)
+}
+
+func runAuth(ctx context.Context, args []string) error {
+	if len(args) > 0 && args[0] == "login" {
+		return runAuthLogin(ctx, args[1:])
+	}
+
+	cl := newCommandLine("auth", "Log in to Spotify and save the auth token. On first use, follow the logged\n"+
+		"URL and set RESPONSE_CODE to the code Spotify responds with, or use auth login.", loginFlags...)
+	cfg, err := cl.load(args, loginKeys...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	log.Info("Logged in and saved auth token")
+	return nil
+}
+
+func runAuthLogin(ctx context.Context, args []string) error {
+	cl := newCommandLine("auth login", "Log in to Spotify by opening the logged URL in a browser. The redirect back\n"+
+		"to REDIRECT_URL is received by a local server, and the auth token is saved.",
+		flagList(loginFlags, []string{"listen", "timeout"})...)
+	cfg, err := cl.load(args, loginKeys...)
+	if err != nil {
+		return err
+	}
+
+	s, err := openSession(ctx, cfg, true)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	log.Info("Logged in and saved auth token")
+	return nil
+}
+
+func runSync(ctx context.Context, args []string) error {
+	cl := newCommandLine("sync", "Update the local cache of playlists which have changed.",
+		flagList(loginFlags, syncFlags)...)
+	cfg, err := cl.load(args, loginKeys...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	_, err = s.syncPlaylists(ctx)
+	return err
+}
+
+func runPruneDisliked(ctx context.Context, args []string) error {
+	cl := newCommandLine("prune-disliked", "Sync the cache and remove tracks found in disliked playlists from every\n"+
+		"playlist owned by the user.", flagList(loginFlags, syncFlags, planFlags, []string{"disliked-prefix"})...)
+	cfg, err := cl.load(args, loginKeys...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	playlists, err := s.syncPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.pruneDisliked(ctx, playlists)
+	if err != nil {
+		return err
+	}
+	return s.writePlan()
+}
+
+func runProcessQueues(ctx context.Context, args []string) error {
+	cl := newCommandLine("process-queues", "Sync the cache and remove tracks from queue playlists which have been\n"+
+		"added to the destination playlist.", flagList(loginFlags, syncFlags, planFlags, []string{"queue-suffix"})...)
+	cfg, err := cl.load(args, loginKeys...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	playlists, err := s.syncPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.util.ProcessQueuePlaylists(ctx, playlists, s.username)
+	if err != nil {
+		return err
+	}
+	return s.writePlan()
+}
+
+func runDedupe(ctx context.Context, args []string) error {
+	cl := newCommandLine("dedupe", "Sync the cache and report duplicate tracks in every playlist. With -remove,\n"+
+		"extra copies of probable duplicates are removed from playlists owned by the user.",
+		flagList(loginFlags, syncFlags, planFlags, []string{"remove"})...)
+	cfg, err := cl.load(args, loginKeys...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	playlists, err := s.syncPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.util.FindPossibleDuplicateTracks(ctx, playlists, s.username)
+	if err != nil {
+		return err
+	}
+	return s.writePlan()
+}
+
+func runApplyPlan(ctx context.Context, args []string) error {
+	cl := newCommandLine("apply-plan", "Apply exactly the changes listed in a plan saved by a previous dry run.",
+		flagList(loginFlags, []string{"plan-file"})...)
+	cfg, err := cl.load(args, append(loginKeys, "plan.file")...)
+	if err != nil {
+		return err
+	}
+
+	savedPlan, err := plan.Load(cfg.Plan.File)
+	if err != nil {
+		return err
+	}
+
+	cfg.DryRun = false
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	err = s.util.ApplyPlan(ctx, savedPlan)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Done applying plan!")
+	return nil
+}
+
+func runRestore(ctx context.Context, args []string) error {
+	cl := newCommandLine("restore", "Restore a playlist from the local cache. The playlist it was cached from gets\n"+
+		"the cached tracks back in their cached order, or with -new (or if it no longer exists)\n"+
+		"the tracks are added to a new playlist. The cache is not synced first.",
+		flagList(loginFlags, planFlags, []string{"playlist", "new", "name"})...)
+	cfg, err := cl.load(args, append(loginKeys, "playlist")...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	playlists, err := s.util.GetAllPlaylistsForUser(ctx, s.username)
+	if err != nil {
+		return err
+	}
+
+	err = s.util.RestorePlaylist(ctx, playlists, s.username, cfg.Playlist, cfg.Restore.AsNew, cfg.Restore.Name)
+	if err != nil {
+		return err
+	}
+	return s.writePlan()
+}
+
+func runDiff(ctx context.Context, args []string) error {
+	cl := newCommandLine("diff", "Show the tracks added, removed and moved in a playlist between two cached\n"+
+		"versions (-from and -to), or between a cached version and the live playlist (without -to).\n"+
+		"Without -from, the current cache is compared. The cache is not synced first.",
+		flagList(loginFlags, []string{"playlist", "from", "to", "format"})...)
+	cfg, err := cl.load(args, append(loginKeys, "playlist")...)
+	if err != nil {
+		return err
+	}
+	from, _ := config.ParseTime(cfg.Diff.From)
+	to, _ := config.ParseTime(cfg.Diff.To)
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	var playlists []spotify.SimplePlaylist
+	if to.IsZero() {
+		playlists, err = s.util.GetAllPlaylistsForUser(ctx, s.username)
+		if err != nil {
+			return err
+		}
+	}
+
+	playlistDiff, err := s.util.DiffPlaylist(ctx, playlists, cfg.Playlist, from, to)
+	if err != nil {
+		return err
+	}
+	return playlistDiff.Write(os.Stdout, cfg.Diff.Format)
+}
+
+func runExport(ctx context.Context, args []string) error {
+	cl := newCommandLine("export", "Export cached playlists to CSV, M3U8, XSPF or JSPF files in -dir, one per playlist.\n"+
+		"CSV exports also get all_playlists.csv holding every playlist. With -playlist, only that\n"+
+		"playlist is exported. The cache is not synced first.",
+		flagList(loginFlags, []string{"playlist", "dir", "export-format", "columns", "path-template", "musicbrainz"})...)
+	cfg, err := cl.load(args, append(loginKeys, "export.dir")...)
+	if err != nil {
+		return err
+	}
+	columns, err := formats.ParseColumns(cfg.Export.Columns)
+	if err != nil {
+		return fmt.Errorf("invalid value for export.columns: %w", err)
+	}
+	pathTemplate, err := formats.ParsePathTemplate(cfg.Export.PathTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid value for export.path_template: %w", err)
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	playlists, err := s.util.GetAllPlaylistsForUser(ctx, s.username)
+	if err != nil {
+		return err
+	}
+	if cfg.Playlist != "" {
+		playlists = filterPlaylists(playlists, cfg.Playlist)
+		if len(playlists) == 0 {
+			return fmt.Errorf("playlist %s not found", cfg.Playlist)
+		}
+	}
+
+	options := formats.ExportOptions{Columns: columns, PathTemplate: pathTemplate}
+	if cfg.Export.MusicBrainz {
+		options.MusicBrainz = musicbrainz.NewClient(cfg.Export.MusicBrainzURL)
+	}
+	return s.util.ExportPlaylists(ctx, playlists, cfg.Export.Dir, cfg.Export.Format, options)
+}
+
+func runImport(ctx context.Context, args []string) error {
+	cl := newCommandLine("import", "Import a playlist file. The tracks of a CSV file are matched to Spotify tracks by\n"+
+		"ISRC or search and added to the user's playlist named -playlist (the file name by default),\n"+
+		"which is created if needed, and a match report is written. A JSPF file is imported into\n"+
+		"the cache, replacing any cached playlist with the same name. Use restore to put it into Spotify.",
+		flagList(loginFlags, planFlags, []string{"file", "playlist", "report", "min-confidence"})...)
+	cfg, err := cl.load(args, append(loginKeys, "import.file")...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	if strings.EqualFold(filepath.Ext(cfg.Import.File), ".csv") {
+		return s.importCSV(ctx)
+	}
+
+	playlist, err := s.util.ImportPlaylist(ctx, cfg.Import.File, float64(cfg.Import.MinConfidence)/100)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Imported playlist %s, restore it with: restore -playlist %q", playlist.Name, playlist.Name)
+	return nil
+}
+
+// importCSV imports a CSV playlist into Spotify and writes its match report
+func (s *session) importCSV(ctx context.Context) error {
+	playlists, err := s.util.GetAllPlaylistsForUser(ctx, s.username)
+	if err != nil {
+		return err
+	}
+
+	report, err := s.util.ImportCSVPlaylist(ctx, playlists, s.username, s.cfg.Import.File, s.cfg.Playlist,
+		float64(s.cfg.Import.MinConfidence)/100)
+	if report != nil {
+		reportFile := s.cfg.Import.Report
+		if reportFile == "" {
+			reportFile = strings.TrimSuffix(s.cfg.Import.File, filepath.Ext(s.cfg.Import.File)) + "_report.csv"
+		}
+		log.Infof("Writing match report to file: %s", reportFile)
+		if writeErr := formats.WriteFile(reportFile, report.WriteCSV); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return s.writePlan()
+}
+
+// filterPlaylists returns the playlists with the given name or ID
+func filterPlaylists(playlists []spotify.SimplePlaylist, name string) []spotify.SimplePlaylist {
+	var result []spotify.SimplePlaylist
+	for _, playlist := range playlists {
+		if playlist.Name == name || string(playlist.ID) == name {
+			result = append(result, playlist)
+		}
+	}
+	return result
+}
+
+func runBackup(ctx context.Context, args []string) error {
+	cl := newCommandLine("backup", "Download every playlist in full into the cache dir, regardless of whether\n"+
+		"it appears to have changed.", flagList(loginFlags, syncFlags)...)
+	cfg, err := cl.load(args, loginKeys...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	playlists, err := s.util.GetAllPlaylistsForUser(ctx, s.username)
+	if err != nil {
+		return err
+	}
+	return s.util.BackupPlaylists(ctx, playlists)
+}
+
+func runConvertCache(ctx context.Context, args []string) error {
+	cl := newCommandLine("convert-cache", "Rewrite every cached playlist and version in the format set by -compression.\n"+
+		"Only the JSON cache is converted.", "cache-dir", "compression", "lock-wait")
+	cfg, err := cl.load(args, "cache_dir")
+	if err != nil {
+		return err
+	}
+
+	cacheLock, err := storage.Lock(ctx, cfg.CacheDir, time.Duration(cfg.Storage.LockWait)*time.Second)
+	if err != nil {
+		return err
+	}
+	defer closeLock(cacheLock)
+
+	converted, err := storage.NewStorage(cfg.CacheDir, false, storage.WithCompression(cfg.Storage.Compression)).ConvertCache(ctx)
+	if err != nil {
+		return err
+	}
+	log.Infof("Converted %d cache files to %s", converted, cfg.Storage.Compression)
+	return nil
+}
+
+func runStatus(ctx context.Context, args []string) error {
+	cl := newCommandLine("status", "Show the auth token and whether the cache of every playlist matches its current snapshot.",
+		loginFlags...)
+	cfg, err := cl.load(args, loginKeys...)
+	if err != nil {
+		return err
+	}
+
+	s, err := login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	token, err := s.storage.LoadToken(ctx, cfg.TokenFile)
+	if err != nil {
+		return err
+	}
+
+	playlists, err := s.util.GetAllPlaylistsForUser(ctx, s.username)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := s.util.GetCacheStatus(ctx, playlists)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Token expires:\t%s\n", token.Expiry.Local())
+	_, _ = fmt.Fprintf(tw, "Cache dir:\t%s\n\n", cfg.CacheDir)
+	_, _ = fmt.Fprintln(tw, "PLAYLIST\tOWNER\tLIVE\tCACHED\tSTATE")
+	for _, status := range statuses {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", status.Name, status.Owner, status.Live, status.Cached, status.State)
+	}
+	return tw.Flush()
+}
+
+// flagList joins lists of flag names
+func flagList(lists ...[]string) []string {
+	var flags []string
+	for _, list := range lists {
+		flags = append(flags, list...)
+	}
+	return flags
+}
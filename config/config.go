@@ -0,0 +1,513 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the application. Values are resolved with the
+// precedence: flags > env variables > config file > defaults.
+type Config struct {
+	UserName        string `yaml:"user_name"`
+	SpotifyID       string `yaml:"spotify_id"`
+	SpotifySecret   string `yaml:"spotify_secret"`
+	APIURL          string `yaml:"api_url"`      // empty for the Spotify Web API
+	AccountsURL     string `yaml:"accounts_url"` // empty for the Spotify accounts service
+	RedirectURL     string `yaml:"redirect_url"`
+	TokenFile       string `yaml:"token_file"`
+	TokenPassphrase string `yaml:"token_passphrase"` // passphrase the auth token file is encrypted with
+	TokenKeyFile    string `yaml:"token_key_file"`   // file holding the key the auth token file is encrypted with
+	CacheDir        string `yaml:"cache_dir"`
+	DislikedPrefix  string `yaml:"disliked_prefix"`
+	QueueSuffix     string `yaml:"queue_suffix"`
+	DryRun          bool   `yaml:"dry_run"`
+	RateLimit       int    `yaml:"rate_limit"`     // requests per second, 0 for no limit
+	MaxRetries      int    `yaml:"max_retries"`    // retries of throttled or failed requests
+	RequestBudget   int    `yaml:"request_budget"` // requests per run, 0 for no limit
+	Playlist        string `yaml:"playlist"`       // name or ID of the playlist to restore, diff, export or import
+
+	Auth     AuthConfig     `yaml:"auth"`
+	Sync     SyncConfig     `yaml:"sync"`
+	Plan     PlanConfig     `yaml:"plan"`
+	Dedupe   DedupeConfig   `yaml:"dedupe"`
+	Restore  RestoreConfig  `yaml:"restore"`
+	Diff     DiffConfig     `yaml:"diff"`
+	Export   ExportConfig   `yaml:"export"`
+	Import   ImportConfig   `yaml:"import"`
+	History  HistoryConfig  `yaml:"history"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Features FeaturesConfig `yaml:"features"`
+}
+
+// AuthConfig configures how the app is authorized and the callback server of auth login
+type AuthConfig struct {
+	Flow            string `yaml:"flow"`             // secret, or pkce to authorize without the client secret
+	CallbackListen  string `yaml:"callback_listen"`  // address to listen on, the host and port of the redirect URL if empty
+	CallbackTimeout int    `yaml:"callback_timeout"` // seconds to wait for the callback
+}
+
+type SyncConfig struct {
+	Workers int `yaml:"workers"` // number of playlists downloaded at the same time
+}
+
+type PlanConfig struct {
+	Format string `yaml:"format"` // "text" or "json"
+	File   string `yaml:"file"`
+}
+
+type DedupeConfig struct {
+	Remove bool `yaml:"remove"`
+}
+
+// RestoreConfig selects where to restore a cached playlist to
+type RestoreConfig struct {
+	AsNew bool   `yaml:"as_new"` // create a new playlist even if the original still exists
+	Name  string `yaml:"name"`   // name of the new playlist, the cached name if empty
+}
+
+// DiffConfig selects the versions of a playlist to compare. Times are RFC 3339 or
+// a date, and select the cached version at or before them.
+type DiffConfig struct {
+	From   string `yaml:"from"`   // cached version to compare from, the current cache if empty
+	To     string `yaml:"to"`     // cached version to compare to, the live playlist if empty
+	Format string `yaml:"format"` // "text" or "json"
+}
+
+// ExportConfig selects where and how cached playlists are exported
+type ExportConfig struct {
+	Dir            string `yaml:"dir"`             // directory the export files are written to
+	Format         string `yaml:"format"`          // "csv", "m3u8", "xspf" or "jspf"
+	Columns        string `yaml:"columns"`         // comma separated CSV columns, every column if empty
+	PathTemplate   string `yaml:"path_template"`   // Go template of the local file path of each track
+	MusicBrainz    bool   `yaml:"musicbrainz"`     // identify JSPF tracks by the MusicBrainz recording of their ISRC
+	MusicBrainzURL string `yaml:"musicbrainz_url"` // empty for the MusicBrainz web service
+}
+
+// ImportConfig selects the playlist file to import and how CSV rows are matched
+type ImportConfig struct {
+	File          string `yaml:"file"`           // CSV or JSPF file
+	Report        string `yaml:"report"`         // CSV match report, <file>_report.csv if empty
+	MinConfidence int    `yaml:"min_confidence"` // percentage a CSV row or JSPF track must score to be matched
+}
+
+// HistoryConfig limits the dated versions kept of each cached playlist
+type HistoryConfig struct {
+	MaxVersions int `yaml:"max_versions"` // versions kept per playlist, 0 for no limit
+	MaxAgeDays  int `yaml:"max_age_days"` // days after which versions are removed, 0 for no limit
+}
+
+// StorageConfig selects where the playlist cache is kept
+type StorageConfig struct {
+	Backend     string `yaml:"backend"`     // "json" or "sqlite"
+	SQLiteFile  string `yaml:"sqlite_file"` // database file, relative to the cache dir unless absolute
+	Compression string `yaml:"compression"` // "none", "gzip" or "zstd", the format JSON cache files are written in
+	LockWait    int    `yaml:"lock_wait"`   // seconds to wait for another run to unlock the cache, 0 to exit at once
+}
+
+// FeaturesConfig enables or disables the steps of the full pipeline
+type FeaturesConfig struct {
+	PruneDisliked bool `yaml:"prune_disliked"`
+	ProcessQueues bool `yaml:"process_queues"`
+	Dedupe        bool `yaml:"dedupe"`
+}
+
+// Option describes a config key and the env variable and flag which can set it
+type Option struct {
+	Key   string
+	Env   string
+	Flag  string
+	Usage string
+}
+
+// Options lists every key which can be set from the environment or a flag
+var Options = []Option{
+	{Key: "user_name", Env: "USER_NAME", Flag: "user", Usage: "Spotify user name"},
+	{Key: "spotify_id", Env: "SPOTIFY_ID", Usage: "Spotify app client ID"},
+	{Key: "spotify_secret", Env: "SPOTIFY_SECRET", Usage: "Spotify app client secret"},
+	{Key: "api_url", Env: "SPOTIFY_API_URL", Usage: "alternative Spotify Web API base URL"},
+	{Key: "accounts_url", Env: "SPOTIFY_ACCOUNTS_URL", Usage: "alternative Spotify accounts service base URL"},
+	{Key: "redirect_url", Env: "REDIRECT_URL", Flag: "redirect-url", Usage: "OAuth redirect URL"},
+	{Key: "token_file", Env: "TOKEN_FILE", Flag: "token-file", Usage: "auth token file name within the cache dir"},
+	{Key: "token_passphrase", Env: "TOKEN_PASSPHRASE", Usage: "passphrase the auth token file is encrypted with"},
+	{Key: "token_key_file", Env: "TOKEN_KEY_FILE", Flag: "token-key-file", Usage: "file holding the key the auth token file is encrypted with"},
+	{Key: "cache_dir", Env: "CACHE_DIR", Flag: "cache-dir", Usage: "directory of the local playlist cache"},
+	{Key: "disliked_prefix", Env: "DISLIKED_PREFIX", Flag: "disliked-prefix", Usage: "name prefix of disliked playlists"},
+	{Key: "queue_suffix", Env: "QUEUE_SUFFIX", Flag: "queue-suffix", Usage: "name suffix of queue playlists"},
+	{Key: "dry_run", Env: "DRY_RUN", Flag: "dry-run", Usage: "only print the changes which would be made"},
+	{Key: "rate_limit", Env: "RATE_LIMIT", Flag: "rate-limit", Usage: "maximum Spotify API requests per second, 0 for no limit"},
+	{Key: "max_retries", Env: "MAX_RETRIES", Flag: "max-retries", Usage: "times a throttled or failed Spotify API request is retried"},
+	{Key: "request_budget", Env: "REQUEST_BUDGET", Flag: "request-budget", Usage: "maximum Spotify API requests per run, 0 for no limit"},
+	{Key: "playlist", Env: "PLAYLIST", Flag: "playlist", Usage: "name or ID of the playlist to restore, diff, export or import"},
+	{Key: "auth.flow", Env: "AUTH_FLOW", Flag: "auth-flow", Usage: "authorization flow, secret or pkce, which doesn't need spotify_secret"},
+	{Key: "auth.callback_listen", Env: "AUTH_CALLBACK_LISTEN", Flag: "listen", Usage: "address auth login listens on for the callback, the host and port of the redirect URL if empty"},
+	{Key: "auth.callback_timeout", Env: "AUTH_CALLBACK_TIMEOUT", Flag: "timeout", Usage: "seconds auth login waits for the callback"},
+	{Key: "sync.workers", Env: "SYNC_WORKERS", Flag: "workers", Usage: "number of playlists downloaded at the same time"},
+	{Key: "plan.format", Env: "PLAN_FORMAT", Flag: "plan-format", Usage: "dry run plan output format, text or json"},
+	{Key: "plan.file", Env: "PLAN_FILE", Flag: "plan-file", Usage: "file to save the dry run plan to"},
+	{Key: "dedupe.remove", Env: "REMOVE_DUPLICATES", Flag: "remove", Usage: "remove extra copies of probable duplicates"},
+	{Key: "restore.as_new", Env: "RESTORE_AS_NEW", Flag: "new", Usage: "restore to a new playlist even if the original still exists"},
+	{Key: "restore.name", Env: "RESTORE_NAME", Flag: "name", Usage: "name of the new playlist, the cached name if empty"},
+	{Key: "diff.from", Env: "DIFF_FROM", Flag: "from", Usage: "time of the cached version to diff from, the current cache if empty"},
+	{Key: "diff.to", Env: "DIFF_TO", Flag: "to", Usage: "time of the cached version to diff to, the live playlist if empty"},
+	{Key: "diff.format", Env: "DIFF_FORMAT", Flag: "format", Usage: "diff output format, text or json"},
+	{Key: "export.dir", Env: "EXPORT_DIR", Flag: "dir", Usage: "directory the export files are written to"},
+	{Key: "export.format", Env: "EXPORT_FORMAT", Flag: "export-format", Usage: "export format, csv, m3u8, xspf or jspf"},
+	{Key: "export.columns", Env: "EXPORT_COLUMNS", Flag: "columns", Usage: "comma separated CSV columns to export, every column if empty"},
+	{Key: "export.path_template", Env: "EXPORT_PATH_TEMPLATE", Flag: "path-template", Usage: "template of the local file path of each exported track, Spotify URLs if empty"},
+	{Key: "export.musicbrainz", Env: "EXPORT_MUSICBRAINZ", Flag: "musicbrainz", Usage: "look up the MusicBrainz recording of each track's ISRC for JSPF exports"},
+	{Key: "export.musicbrainz_url", Env: "MUSICBRAINZ_URL", Usage: "alternative MusicBrainz web service base URL"},
+	{Key: "import.file", Env: "IMPORT_FILE", Flag: "file", Usage: "playlist file to import, .csv for CSV or .jspf or .json for JSPF"},
+	{Key: "import.report", Env: "IMPORT_REPORT", Flag: "report", Usage: "file to write the CSV match report to, <file>_report.csv if empty"},
+	{Key: "import.min_confidence", Env: "IMPORT_MIN_CONFIDENCE", Flag: "min-confidence", Usage: "percentage a CSV row or JSPF track without a Spotify ID must score to be matched to a track"},
+	{Key: "history.max_versions", Env: "HISTORY_MAX_VERSIONS", Usage: "dated versions kept of each cached playlist, 0 for no limit"},
+	{Key: "history.max_age_days", Env: "HISTORY_MAX_AGE_DAYS", Usage: "days after which cached playlist versions are removed, 0 for no limit"},
+	{Key: "storage.backend", Env: "STORAGE_BACKEND", Flag: "storage", Usage: "playlist cache backend, json or sqlite"},
+	{Key: "storage.sqlite_file", Env: "STORAGE_SQLITE_FILE", Flag: "sqlite-file", Usage: "SQLite cache database file, relative to the cache dir unless absolute"},
+	{Key: "storage.compression", Env: "STORAGE_COMPRESSION", Flag: "compression", Usage: "format JSON cache files are written in, none, gzip or zstd"},
+	{Key: "storage.lock_wait", Env: "STORAGE_LOCK_WAIT", Flag: "lock-wait", Usage: "seconds to wait for another run to unlock the cache dir, 0 to exit at once"},
+	{Key: "features.prune_disliked", Env: "FEATURE_PRUNE_DISLIKED", Usage: "remove disliked tracks in the full pipeline"},
+	{Key: "features.process_queues", Env: "FEATURE_PROCESS_QUEUES", Usage: "process queue playlists in the full pipeline"},
+	{Key: "features.dedupe", Env: "FEATURE_DEDUPE", Usage: "scan for duplicate tracks in the full pipeline"},
+}
+
+// Default returns the config used when a key is not set anywhere else
+func Default() *Config {
+	return &Config{
+		TokenFile:      "auth_token.json",
+		DislikedPrefix: "disliked_",
+		QueueSuffix:    " Queue",
+		RateLimit:      10,
+		MaxRetries:     5,
+		Auth: AuthConfig{
+			Flow:            "secret",
+			CallbackTimeout: 300,
+		},
+		Sync: SyncConfig{
+			Workers: 4,
+		},
+		Plan: PlanConfig{
+			Format: "text",
+		},
+		Diff: DiffConfig{
+			Format: "text",
+		},
+		Export: ExportConfig{
+			Dir:    "export",
+			Format: "csv",
+		},
+		Import: ImportConfig{
+			MinConfidence: 80,
+		},
+		History: HistoryConfig{
+			MaxVersions: 50,
+			MaxAgeDays:  365,
+		},
+		Storage: StorageConfig{
+			Backend:     "json",
+			SQLiteFile:  "cache.db",
+			Compression: "none",
+		},
+		Features: FeaturesConfig{
+			PruneDisliked: true,
+			ProcessQueues: true,
+			Dedupe:        true,
+		},
+	}
+}
+
+// Load builds the config from the defaults, the config file (if any), the env
+// variables and finally the flags which were set, keyed by config key
+func Load(configFile string, getenv func(string) string, flags map[string]string) (*Config, error) {
+	c := Default()
+
+	if configFile != "" {
+		err := c.LoadFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := c.ApplyEnv(getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range flags {
+		err = c.Set(key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, c.Validate()
+}
+
+// LoadFile sets every key found in a YAML config file
+func (c *Config) LoadFile(fileName string) error {
+	log.Infof("Loading config from file: %s", fileName)
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	var root yaml.Node
+	err = yaml.NewDecoder(bytes.NewReader(data)).Decode(&root)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", fileName, err)
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+
+	err = c.setNode("", root.Content[0])
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// setNode walks a YAML mapping and sets every scalar it contains
+func (c *Config) setNode(prefix string, node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		if prefix == "" {
+			return fmt.Errorf("line %d: expected a mapping of keys", node.Line)
+		}
+		return fmt.Errorf("line %d: %s must be a single value", node.Line, prefix)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		value := node.Content[i+1]
+		if value.Kind == yaml.MappingNode {
+			err := c.setNode(key, value)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if value.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: %s must be a single value", value.Line, key)
+		}
+
+		err := c.Set(key, value.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", value.Line, err)
+		}
+	}
+	return nil
+}
+
+// ApplyEnv sets every key whose env variable is set
+func (c *Config) ApplyEnv(getenv func(string) string) error {
+	for _, option := range Options {
+		value := getenv(option.Env)
+		if value == "" {
+			continue
+		}
+
+		err := c.Set(option.Key, value)
+		if err != nil {
+			return fmt.Errorf("env %s: %w", option.Env, err)
+		}
+	}
+	return nil
+}
+
+// Set sets a key, ex: "plan.format", from its string value
+func (c *Config) Set(key string, value string) error {
+	field, err := c.field(key)
+	if err != nil {
+		return err
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %q is not a boolean", key, value)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %q is not a number", key, value)
+		}
+		field.SetInt(int64(i))
+	default:
+		return fmt.Errorf("unsupported type for %s", key)
+	}
+	return nil
+}
+
+// Get returns the value of a key formatted as a string
+func (c *Config) Get(key string) string {
+	field, err := c.field(key)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// IsBool returns whether a key holds a boolean value
+func IsBool(key string) bool {
+	field, err := Default().field(key)
+	return err == nil && field.Kind() == reflect.Bool
+}
+
+// field returns the struct field for a key by following the yaml tags
+func (c *Config) field(key string) (reflect.Value, error) {
+	value := reflect.ValueOf(c).Elem()
+
+	for _, part := range strings.Split(key, ".") {
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("unknown config key: %s", key)
+		}
+
+		found := false
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).Tag.Get("yaml") == part {
+				value = value.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, fmt.Errorf("unknown config key: %s", key)
+		}
+	}
+
+	if value.Kind() == reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("config key %s must be a single value", key)
+	}
+	return value, nil
+}
+
+// Validate checks the values of keys which only allow certain values
+func (c *Config) Validate() error {
+	switch c.Plan.Format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("invalid value for plan.format: %q must be text or json", c.Plan.Format)
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("invalid value for rate_limit: %d must not be negative", c.RateLimit)
+	}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("invalid value for max_retries: %d must not be negative", c.MaxRetries)
+	}
+	if c.RequestBudget < 0 {
+		return fmt.Errorf("invalid value for request_budget: %d must not be negative", c.RequestBudget)
+	}
+	if c.Sync.Workers < 1 {
+		return fmt.Errorf("invalid value for sync.workers: %d must be at least 1", c.Sync.Workers)
+	}
+	switch c.Diff.Format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("invalid value for diff.format: %q must be text or json", c.Diff.Format)
+	}
+	switch c.Export.Format {
+	case "csv", "m3u8", "xspf", "jspf":
+	default:
+		return fmt.Errorf("invalid value for export.format: %q must be csv, m3u8, xspf or jspf", c.Export.Format)
+	}
+	for key, value := range map[string]string{"diff.from": c.Diff.From, "diff.to": c.Diff.To} {
+		if _, err := ParseTime(value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+	}
+	if c.Import.MinConfidence < 0 || c.Import.MinConfidence > 100 {
+		return fmt.Errorf("invalid value for import.min_confidence: %d must be between 0 and 100", c.Import.MinConfidence)
+	}
+	if c.History.MaxVersions < 0 {
+		return fmt.Errorf("invalid value for history.max_versions: %d must not be negative", c.History.MaxVersions)
+	}
+	if c.History.MaxAgeDays < 0 {
+		return fmt.Errorf("invalid value for history.max_age_days: %d must not be negative", c.History.MaxAgeDays)
+	}
+	if c.TokenPassphrase != "" && c.TokenKeyFile != "" {
+		return fmt.Errorf("only one of token_passphrase and token_key_file can be set")
+	}
+	switch c.Storage.Compression {
+	case "none", "gzip", "zstd":
+	default:
+		return fmt.Errorf("invalid value for storage.compression: %q must be none, gzip or zstd", c.Storage.Compression)
+	}
+	switch c.Auth.Flow {
+	case "secret", "pkce":
+	default:
+		return fmt.Errorf("invalid value for auth.flow: %q must be secret or pkce", c.Auth.Flow)
+	}
+	if c.Auth.CallbackTimeout < 1 {
+		return fmt.Errorf("invalid value for auth.callback_timeout: %d must be at least 1", c.Auth.CallbackTimeout)
+	}
+	if c.Storage.LockWait < 0 {
+		return fmt.Errorf("invalid value for storage.lock_wait: %d must not be negative", c.Storage.LockWait)
+	}
+	switch c.Storage.Backend {
+	case "json", "sqlite":
+	default:
+		return fmt.Errorf("invalid value for storage.backend: %q must be json or sqlite", c.Storage.Backend)
+	}
+	return nil
+}
+
+// Require checks that every given key has a value
+func (c *Config) Require(keys ...string) error {
+	for _, key := range keys {
+		field, err := c.field(key)
+		if err != nil {
+			return err
+		}
+		if field.IsZero() {
+			return fmt.Errorf("config key %s must be set%s", key, sources(key))
+		}
+	}
+	return nil
+}
+
+// sources describes where a key can be set, for error messages
+func sources(key string) string {
+	for _, option := range Options {
+		if option.Key != key {
+			continue
+		}
+		if option.Flag != "" {
+			return fmt.Sprintf(" (flag -%s, env %s or config file)", option.Flag, option.Env)
+		}
+		return fmt.Sprintf(" (env %s or config file)", option.Env)
+	}
+	return " (config file)"
+}
+
+// ParseTime parses a time given in RFC 3339 or as a date, which is midnight local
+// time. Returns the zero time for an empty value.
+func ParseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q must be a date (2006-01-02) or RFC 3339 time", value)
+	}
+	return t, nil
+}
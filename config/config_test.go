@@ -0,0 +1,174 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	fileName := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(fileName, []byte(content), 0644))
+	return fileName
+}
+
+func noEnv(string) string {
+	return ""
+}
+
+func Test_Load_Defaults(t *testing.T) {
+	cfg, err := Load("", noEnv, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func Test_Load_File(t *testing.T) {
+	fileName := writeConfigFile(t, "user_name: user1\nqueue_suffix: \" Later\"\nfeatures:\n  dedupe: false\n")
+
+	cfg, err := Load(fileName, noEnv, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", cfg.UserName)
+	assert.Equal(t, " Later", cfg.QueueSuffix)
+	assert.False(t, cfg.Features.Dedupe)
+	assert.True(t, cfg.Features.PruneDisliked)
+	assert.Equal(t, "disliked_", cfg.DislikedPrefix)
+}
+
+func Test_Load_Empty_File(t *testing.T) {
+	fileName := writeConfigFile(t, "")
+
+	cfg, err := Load(fileName, noEnv, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+// Test_Load_Precedence tests flags > env > file > defaults
+func Test_Load_Precedence(t *testing.T) {
+	fileName := writeConfigFile(t, "user_name: file\ncache_dir: file\ntoken_file: file\n")
+	env := map[string]string{
+		"USER_NAME": "env",
+		"CACHE_DIR": "env",
+	}
+	flags := map[string]string{
+		"user_name": "flag",
+	}
+
+	cfg, err := Load(fileName, func(key string) string { return env[key] }, flags)
+	assert.NoError(t, err)
+	assert.Equal(t, "flag", cfg.UserName)
+	assert.Equal(t, "env", cfg.CacheDir)
+	assert.Equal(t, "file", cfg.TokenFile)
+	assert.Equal(t, "text", cfg.Plan.Format)
+}
+
+func Test_Load_File_UnknownKey(t *testing.T) {
+	fileName := writeConfigFile(t, "plan:\n  formatt: json\n")
+
+	_, err := Load(fileName, noEnv, nil)
+	assert.EqualError(t, err, "config file "+fileName+": line 2: unknown config key: plan.formatt")
+}
+
+func Test_Load_File_BadValue(t *testing.T) {
+	fileName := writeConfigFile(t, "dedupe:\n  remove: sometimes\n")
+
+	_, err := Load(fileName, noEnv, nil)
+	assert.ErrorContains(t, err, "dedupe.remove")
+}
+
+func Test_Load_File_NotScalar(t *testing.T) {
+	fileName := writeConfigFile(t, "user_name:\n  - a\n  - b\n")
+
+	_, err := Load(fileName, noEnv, nil)
+	assert.ErrorContains(t, err, "user_name must be a single value")
+}
+
+func Test_Load_Env_BadValue(t *testing.T) {
+	env := map[string]string{"DRY_RUN": "maybe"}
+
+	_, err := Load("", func(key string) string { return env[key] }, nil)
+	assert.EqualError(t, err, "env DRY_RUN: invalid value for dry_run: \"maybe\" is not a boolean")
+}
+
+func Test_Validate_PlanFormat(t *testing.T) {
+	_, err := Load("", noEnv, map[string]string{"plan.format": "yaml"})
+	assert.ErrorContains(t, err, "plan.format")
+}
+
+func Test_Load_Int(t *testing.T) {
+	env := map[string]string{"SYNC_WORKERS": "8"}
+
+	cfg, err := Load("", func(key string) string { return env[key] }, map[string]string{"rate_limit": "0"})
+	assert.NoError(t, err)
+	assert.Equal(t, 8, cfg.Sync.Workers)
+	assert.Equal(t, 0, cfg.RateLimit)
+
+	_, err = Load("", noEnv, map[string]string{"sync.workers": "many"})
+	assert.EqualError(t, err, "invalid value for sync.workers: \"many\" is not a number")
+}
+
+func Test_Validate_SyncWorkers(t *testing.T) {
+	_, err := Load("", noEnv, map[string]string{"sync.workers": "0"})
+	assert.ErrorContains(t, err, "sync.workers")
+}
+
+func Test_Validate_StorageBackend(t *testing.T) {
+	_, err := Load("", noEnv, map[string]string{"storage.backend": "postgres"})
+	assert.ErrorContains(t, err, "storage.backend")
+}
+
+func Test_Validate_StorageCompression(t *testing.T) {
+	_, err := Load("", noEnv, map[string]string{"storage.compression": "lz4"})
+	assert.ErrorContains(t, err, "storage.compression")
+}
+
+func Test_Validate_TokenSecret(t *testing.T) {
+	_, err := Load("", noEnv, map[string]string{"token_passphrase": "secret", "token_key_file": "token.key"})
+	assert.ErrorContains(t, err, "token_passphrase")
+}
+
+func Test_Validate_LockWait(t *testing.T) {
+	_, err := Load("", noEnv, map[string]string{"storage.lock_wait": "-1"})
+	assert.ErrorContains(t, err, "storage.lock_wait")
+}
+
+func Test_Validate_AuthFlow(t *testing.T) {
+	_, err := Load("", noEnv, map[string]string{"auth.flow": "implicit"})
+	assert.ErrorContains(t, err, "auth.flow")
+
+	cfg, err := Load("", noEnv, map[string]string{"auth.flow": "pkce"})
+	assert.NoError(t, err)
+	assert.Equal(t, "pkce", cfg.Auth.Flow)
+}
+
+func Test_ParseTime(t *testing.T) {
+	result, err := ParseTime("2022-02-01T12:30:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 2, 1, 12, 30, 0, 0, time.UTC), result)
+
+	result, err = ParseTime("2022-02-01")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 2, 1, 0, 0, 0, 0, time.Local), result)
+
+	result, err = ParseTime("")
+	assert.NoError(t, err)
+	assert.True(t, result.IsZero())
+
+	_, err = Load("", noEnv, map[string]string{"diff.from": "last week"})
+	assert.ErrorContains(t, err, "diff.from")
+}
+
+func Test_Require(t *testing.T) {
+	cfg := Default()
+	assert.NoError(t, cfg.Require("token_file"))
+	assert.EqualError(t, cfg.Require("user_name"),
+		"config key user_name must be set (flag -user, env USER_NAME or config file)")
+}
+
+func Test_IsBool(t *testing.T) {
+	assert.True(t, IsBool("dedupe.remove"))
+	assert.False(t, IsBool("plan.format"))
+	assert.False(t, IsBool("plan"))
+}
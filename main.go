@@ -1,77 +1,108 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/reeves122/spotify-automation-go/adapter/spotifywrapper"
-	"github.com/reeves122/spotify-automation-go/service/auth"
-	"github.com/reeves122/spotify-automation-go/service/storage"
-	"github.com/reeves122/spotify-automation-go/service/util"
 	log "github.com/sirupsen/logrus"
-	"github.com/zmb3/spotify/v2"
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
 )
 
+// command is a single CLI subcommand
+type command struct {
+	name        string
+	description string
+	run         func(ctx context.Context, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{"run", "Run the full pipeline: sync, prune-disliked, process-queues and dedupe", runAll},
+		{"auth", "Log in to Spotify and save the auth token", runAuth},
+		{"sync", "Update the local cache of playlists which have changed", runSync},
+		{"prune-disliked", "Remove disliked tracks from all playlists", runPruneDisliked},
+		{"process-queues", "Remove tracks from queue playlists which are in the destination playlist", runProcessQueues},
+		{"dedupe", "Report (and optionally remove) duplicate tracks in playlists", runDedupe},
+		{"apply-plan", "Apply a plan saved by a previous dry run", runApplyPlan},
+		{"backup", "Download every playlist in full, regardless of cache state", runBackup},
+		{"restore", "Restore a playlist from the local cache", runRestore},
+		{"export", "Export cached playlists to CSV, M3U8, XSPF or JSPF files", runExport},
+		{"import", "Import a CSV playlist into Spotify, or a JSPF playlist into the cache", runImport},
+		{"diff", "Show the changes to a playlist between cached versions or since it was cached", runDiff},
+		{"status", "Show the auth token and the cache state of every playlist", runStatus},
+		{"convert-cache", "Rewrite the JSON cache files in the configured compression format", runConvertCache},
+	}
+}
+
 func main() {
 	log.SetLevel(log.DebugLevel)
-	username := checkAndGetEnv("USER_NAME")
-	redirectURL := checkAndGetEnv("REDIRECT_URL")
-	tokenFile := checkAndGetEnv("TOKEN_FILE")
-	cacheDir := checkAndGetEnv("CACHE_DIR")
-	dislikedPrefix := checkAndGetEnv("DISLIKED_PREFIX")
-	queueSuffix := checkAndGetEnv("QUEUE_SUFFIX")
-	_ = checkAndGetEnv("SPOTIFY_ID")
-	_ = checkAndGetEnv("SPOTIFY_SECRET")
 
-	wrapper := spotifywrapper.NewWrapper(spotify.Client{}, spotifyauth.Authenticator{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(cancel)
 
-	storageService := storage.NewStorage(cacheDir, false)
-	authService := auth.NewAuth(wrapper, storageService)
-	err := authService.Login(redirectURL, tokenFile)
-	if err != nil {
-		panic(err)
+	err := run(ctx, os.Args[1:])
+	if err == flag.ErrHelp {
+		return
 	}
-
-	utilService := util.NewUtil(wrapper, storageService, dislikedPrefix, queueSuffix)
-	playlists, err := utilService.GetAllPlaylistsForUser(username)
-	if err != nil {
-		panic(err)
+	if errors.Is(err, context.Canceled) {
+		log.Warn("Cancelled before finishing, run again to pick up where this run stopped")
+		os.Exit(1)
 	}
-
-	err = utilService.UpdateLocalCache(playlists)
 	if err != nil {
-		panic(err)
+		log.Error(err)
+		os.Exit(1)
 	}
+}
 
-	disliked, err := utilService.LoadAllDislikedTracks(playlists)
-	if err != nil {
-		panic(err)
-	}
+// handleSignals cancels the run on the first SIGINT or SIGTERM, which stops any
+// request in flight and lets the current command return without writing more of
+// the cache. A second signal exits immediately.
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-	err = utilService.ScanPlaylistsForDislikedTracks(playlists, disliked, username)
-	if err != nil {
-		panic(err)
+	go func() {
+		sig := <-signals
+		log.Warnf("Received %s, stopping. Send it again to exit immediately", sig)
+		signal.Stop(signals)
+		cancel()
+	}()
+}
+
+// run dispatches to the subcommand named by the first argument. Without any
+// arguments the full pipeline is run.
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return runAll(ctx, nil)
 	}
 
-	err = utilService.ProcessQueuePlaylists(playlists, username)
-	if err != nil {
-		panic(err)
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return nil
 	}
 
-	err = utilService.FindPossibleDuplicateTracks(playlists)
-	if err != nil {
-		panic(err)
+	for _, cmd := range commands() {
+		if cmd.name == args[0] {
+			return cmd.run(ctx, args[1:])
+		}
 	}
 
-	log.Info("Done processing!")
+	printUsage(os.Stderr)
+	return fmt.Errorf("unknown command: %s", args[0])
 }
 
-func checkAndGetEnv(envVar string) string {
-	value := os.Getenv(envVar)
-	if value == "" {
-		log.Errorf("%s env variable must be set", envVar)
-		os.Exit(1)
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Usage: spotify-automation-go <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands() {
+		_, _ = fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.description)
 	}
-	log.Debugf("%s is set to '%s'", envVar, value)
-	return value
+	_, _ = fmt.Fprintf(w, "\nRun 'spotify-automation-go <command> -h' for the flags of a command.\n"+
+		"Without a command, 'run' is used.\n")
 }
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/adapter/spotifywrapper"
+	"github.com/reeves122/spotify-automation-go/mocks/fakespotify"
+	"github.com/reeves122/spotify-automation-go/service/storage"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+type testLibrary struct {
+	favorites spotify.SimplePlaylist
+	queue     spotify.SimplePlaylist
+	other     spotify.SimplePlaylist
+}
+
+// newTestServer starts a fake Spotify server seeded with a small library and points
+// the config env variables at it
+func newTestServer(t *testing.T) (*fakespotify.Server, testLibrary) {
+	server := fakespotify.NewServer()
+	t.Cleanup(server.Close)
+	server.SetCurrentUser("testuser")
+	server.AddAuthCode("test-code")
+
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("SPOTIFY_ID", "id")
+	t.Setenv("SPOTIFY_SECRET", "secret")
+	t.Setenv("SPOTIFY_API_URL", server.APIURL())
+	t.Setenv("SPOTIFY_ACCOUNTS_URL", server.AccountsURL())
+	t.Setenv("REDIRECT_URL", "http://localhost/callback")
+	t.Setenv("RESPONSE_CODE", "test-code")
+	t.Setenv("RATE_LIMIT", "0")
+
+	disliked := fakespotify.NewTrack("bad", "Bad Song", "Artist", 200000)
+	liked := fakespotify.NewTrack("liked", "Liked Song", "Artist", 200000)
+	duplicate := fakespotify.NewTrack("dup", "Duplicate Song", "Artist", 180000)
+
+	server.AddPlaylist("testuser", "disliked_songs", disliked)
+	library := testLibrary{
+		favorites: server.AddPlaylist("testuser", "Favorites",
+			liked, disliked, duplicate, duplicate,
+			fakespotify.NewTrack("other", "Other Song", "Artist", 240000)),
+		queue: server.AddPlaylist("testuser", "Favorites Queue",
+			liked, fakespotify.NewTrack("new", "New Song", "Artist", 210000)),
+		other: server.AddPlaylist("someone", "Not Mine", disliked, duplicate, duplicate),
+	}
+	return server, library
+}
+
+func Test_Run(t *testing.T) {
+	server, library := newTestServer(t)
+
+	err := run(context.Background(), []string{"run", "-user", "testuser", "-cache-dir", t.TempDir(), "-remove"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []spotify.ID{"liked", "dup", "other"}, server.TrackIDs(library.favorites.ID))
+	assert.Equal(t, []spotify.ID{"new"}, server.TrackIDs(library.queue.ID))
+	assert.Equal(t, []spotify.ID{"bad", "dup", "dup"}, server.TrackIDs(library.other.ID))
+}
+
+func Test_Run_DryRun(t *testing.T) {
+	server, library := newTestServer(t)
+
+	err := run(context.Background(), []string{"run", "-user", "testuser", "-cache-dir", t.TempDir(), "-remove", "-dry-run"})
+	assert.NoError(t, err)
+
+	assert.Len(t, server.Tracks(library.favorites.ID), 5)
+	assert.Len(t, server.Tracks(library.queue.ID), 2)
+	assert.Equal(t, 0, server.RequestCount("DELETE /v1/playlists/"+string(library.favorites.ID)+"/tracks"))
+}
+
+func Test_Run_SavedToken(t *testing.T) {
+	server, library := newTestServer(t)
+	cacheDir := t.TempDir()
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+
+	// the auth code can only be used once, so the saved token must be used
+	t.Setenv("RESPONSE_CODE", "")
+	err = run(context.Background(), []string{"prune-disliked", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+	assert.Equal(t, []spotify.ID{"liked", "dup", "dup", "other"}, server.TrackIDs(library.favorites.ID))
+}
+
+func Test_Run_EncryptedToken(t *testing.T) {
+	newTestServer(t)
+	cacheDir := t.TempDir()
+	tokenFile := filepath.Join(cacheDir, "auth_token.json")
+	t.Setenv("TOKEN_PASSPHRASE", "passphrase")
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+	data, _ := os.ReadFile(tokenFile)
+	assert.Equal(t, "SATK", string(data[:4]))
+	info, _ := os.Stat(tokenFile)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	err = run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+
+	t.Setenv("TOKEN_PASSPHRASE", "wrong")
+	err = run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.ErrorIs(t, err, storage.ErrTokenKey)
+}
+
+func Test_AuthLogin(t *testing.T) {
+	newTestServer(t)
+	cacheDir := t.TempDir()
+	t.Setenv("RESPONSE_CODE", "")
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	redirectURL := "http://" + listener.Addr().String() + "/callback"
+	_ = listener.Close()
+	t.Setenv("REDIRECT_URL", redirectURL)
+	logs := logtest.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	// the browser follows the logged authorize URL, which redirects back to the listener
+	go func() {
+		for i := 0; i < 100; i++ {
+			for _, entry := range logs.AllEntries() {
+				if authURL, found := strings.CutPrefix(entry.Message, "Open this URL in a browser to authorize this application: "); found {
+					resp, err := http.Get(authURL)
+					assert.NoError(t, err)
+					assert.Equal(t, http.StatusOK, resp.StatusCode)
+					_ = resp.Body.Close()
+					return
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	err := run(context.Background(), []string{"auth", "login", "-user", "testuser", "-cache-dir", cacheDir, "-timeout", "10"})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+}
+
+// Test_Run_PKCE tests logging in with the PKCE flow and no client secret, copying the
+// code by hand, and refreshing the token, which is replaced every time it is used
+func Test_Run_PKCE(t *testing.T) {
+	server, _ := newTestServer(t)
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	t.Setenv("SPOTIFY_SECRET", "")
+	t.Setenv("AUTH_FLOW", "pkce")
+	t.Setenv("RESPONSE_CODE", "")
+	logs := logtest.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	err := run(ctx, []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.EqualError(t, err, "response code not found")
+	assert.FileExists(t, filepath.Join(cacheDir, "auth_token.json.verifier"))
+
+	// the user authorizes the app in a browser and copies the code from the redirect URL
+	var authURL string
+	for _, entry := range logs.AllEntries() {
+		if strings.HasPrefix(entry.Message, server.AccountsURL()+"/authorize") {
+			authURL = entry.Message
+		}
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	location, err := resp.Location()
+	assert.NoError(t, err)
+	t.Setenv("RESPONSE_CODE", location.Query().Get("code"))
+
+	err = run(ctx, []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(cacheDir, "auth_token.json.verifier"))
+	t.Setenv("RESPONSE_CODE", "")
+
+	s := storage.NewStorage(cacheDir, false)
+	for i := 0; i < 2; i++ {
+		token, err := s.LoadToken(ctx, "auth_token.json")
+		assert.NoError(t, err)
+		token.Expiry = time.Now().Add(-time.Minute)
+		assert.NoError(t, s.SaveToken(ctx, token, "auth_token.json"))
+
+		err = run(ctx, []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+		assert.NoError(t, err)
+		refreshed, _ := s.LoadToken(ctx, "auth_token.json")
+		assert.NotEqual(t, token.RefreshToken, refreshed.RefreshToken)
+	}
+}
+
+// Test_Run_RefreshRejected tests a saved token whose refresh token Spotify rejects
+func Test_Run_RefreshRejected(t *testing.T) {
+	newTestServer(t)
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+
+	err := run(ctx, []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+
+	s := storage.NewStorage(cacheDir, false)
+	token, _ := s.LoadToken(ctx, "auth_token.json")
+	token.RefreshToken = "revoked"
+	token.Expiry = time.Now().Add(-time.Minute)
+	assert.NoError(t, s.SaveToken(ctx, token, "auth_token.json"))
+
+	err = run(ctx, []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.ErrorIs(t, err, spotifywrapper.ErrReauthorizationRequired)
+	assert.ErrorContains(t, err, "re-authorization required")
+}
+
+func Test_Run_NoResponseCode(t *testing.T) {
+	newTestServer(t)
+	t.Setenv("RESPONSE_CODE", "")
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", t.TempDir()})
+	assert.EqualError(t, err, "response code not found")
+}
+
+func Test_Run_Cancelled(t *testing.T) {
+	server, library := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := run(ctx, []string{"run", "-user", "testuser", "-cache-dir", t.TempDir(), "-remove"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, server.Tracks(library.favorites.ID), 5)
+}
+
+func Test_Run_Locked(t *testing.T) {
+	server, library := newTestServer(t)
+	cacheDir := t.TempDir()
+	held, err := storage.Lock(context.Background(), cacheDir, 0)
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"run", "-user", "testuser", "-cache-dir", cacheDir, "-remove"})
+	assert.ErrorIs(t, err, storage.ErrLocked)
+	assert.Len(t, server.Tracks(library.favorites.ID), 5)
+
+	assert.NoError(t, held.Close())
+	err = run(context.Background(), []string{"run", "-user", "testuser", "-cache-dir", cacheDir, "-remove"})
+	assert.NoError(t, err)
+
+	// the lock is released when the run ends
+	held, err = storage.Lock(context.Background(), cacheDir, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, held.Close())
+}
+
+// Test_Run_LegacyCache tests a sync starting from a cache written by the original
+// version, with a file per playlist name holding only its tracks
+func Test_Run_LegacyCache(t *testing.T) {
+	server, library := newTestServer(t)
+	cacheDir := t.TempDir()
+	legacyFile := filepath.Join(cacheDir, "Favorites.json")
+	legacy, _ := json.Marshal(server.Tracks(library.queue.ID))
+	_ = os.WriteFile(legacyFile, legacy, 0644)
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, legacyFile)
+
+	s := storage.NewStorage(cacheDir, false)
+	cached, err := s.LoadPlaylistFile(context.Background(), string(library.favorites.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, "Favorites", cached.Name)
+	assert.Len(t, cached.Tracks, 5)
+
+	// the tracks of the legacy file are kept as the oldest version
+	versions, _ := s.ListPlaylistVersions(context.Background(), string(library.favorites.ID))
+	assert.Len(t, versions, 2)
+	assert.Equal(t, 2, versions[0].Tracks)
+}
+
+func Test_Run_SQLite(t *testing.T) {
+	server, library := newTestServer(t)
+	cacheDir := t.TempDir()
+	original := server.TrackIDs(library.favorites.ID)
+
+	err := run(context.Background(), []string{"run", "-user", "testuser", "-cache-dir", cacheDir, "-storage", "sqlite", "-remove"})
+	assert.NoError(t, err)
+	assert.Equal(t, []spotify.ID{"liked", "dup", "other"}, server.TrackIDs(library.favorites.ID))
+	assert.FileExists(t, filepath.Join(cacheDir, "cache.db"))
+
+	err = run(context.Background(), []string{"restore", "-user", "testuser", "-cache-dir", cacheDir, "-storage", "sqlite", "-playlist", "Favorites"})
+	assert.NoError(t, err)
+	assert.Equal(t, original, server.TrackIDs(library.favorites.ID))
+}
+
+func Test_Restore(t *testing.T) {
+	server, library := newTestServer(t)
+	cacheDir := t.TempDir()
+	original := server.TrackIDs(library.favorites.ID)
+
+	err := run(context.Background(), []string{"run", "-user", "testuser", "-cache-dir", cacheDir, "-remove"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, original, server.TrackIDs(library.favorites.ID))
+
+	// the cache still holds the playlist as it was before the run changed it
+	err = run(context.Background(), []string{"restore", "-user", "testuser", "-cache-dir", cacheDir, "-playlist", "Favorites"})
+	assert.NoError(t, err)
+	assert.Equal(t, original, server.TrackIDs(library.favorites.ID))
+}
+
+func Test_Restore_New_PlanFile(t *testing.T) {
+	server, library := newTestServer(t)
+	cacheDir := t.TempDir()
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"restore", "-user", "testuser", "-cache-dir", cacheDir,
+		"-playlist", "Favorites", "-new", "-name", "Favorites Restored", "-dry-run", "-plan-file", planFile})
+	assert.NoError(t, err)
+	assert.Len(t, server.Playlists(), 4)
+
+	err = run(context.Background(), []string{"apply-plan", "-user", "testuser", "-cache-dir", cacheDir, "-plan-file", planFile})
+	assert.NoError(t, err)
+
+	playlists := server.Playlists()
+	assert.Len(t, playlists, 5)
+	assert.Equal(t, "Favorites Restored", playlists[4].Name)
+	assert.Equal(t, "testuser", playlists[4].Owner.ID)
+	assert.Equal(t, server.TrackIDs(library.favorites.ID), server.TrackIDs(playlists[4].ID))
+}
+
+func Test_Restore_NotOwned(t *testing.T) {
+	_, library := newTestServer(t)
+	cacheDir := t.TempDir()
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"restore", "-user", "testuser", "-cache-dir", cacheDir, "-playlist", library.other.Name})
+	assert.EqualError(t, err, "playlist Not Mine is owned by someone, restore it as a new playlist instead")
+}
+
+func Test_Diff(t *testing.T) {
+	newTestServer(t)
+	cacheDir := t.TempDir()
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"dedupe", "-user", "testuser", "-cache-dir", cacheDir, "-remove"})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"diff", "-user", "testuser", "-cache-dir", cacheDir, "-playlist", "Favorites", "-format", "json"})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"diff", "-user", "testuser", "-cache-dir", cacheDir, "-playlist", "Favorites", "-from", "2000-01-01"})
+	assert.EqualError(t, err, "playlist Favorites has no cached version at "+time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local).Format(time.RFC3339))
+}
+
+func Test_ConvertCache(t *testing.T) {
+	server, library := newTestServer(t)
+	cacheDir := t.TempDir()
+	cacheFile := filepath.Join(cacheDir, "playlists", string(library.favorites.ID)+".json")
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir, "-compression", "gzip"})
+	assert.NoError(t, err)
+	data, _ := os.ReadFile(cacheFile)
+	assert.Equal(t, []byte{0x1f, 0x8b}, data[:2])
+
+	err = run(context.Background(), []string{"convert-cache", "-cache-dir", cacheDir, "-compression", "none"})
+	assert.NoError(t, err)
+	data, _ = os.ReadFile(cacheFile)
+	assert.Contains(t, string(data), `"name": "Favorites"`)
+
+	original := server.TrackIDs(library.favorites.ID)
+	err = run(context.Background(), []string{"dedupe", "-user", "testuser", "-cache-dir", cacheDir, "-compression", "zstd", "-remove"})
+	assert.NoError(t, err)
+	err = run(context.Background(), []string{"restore", "-user", "testuser", "-cache-dir", cacheDir, "-playlist", "Favorites"})
+	assert.NoError(t, err)
+	assert.Equal(t, original, server.TrackIDs(library.favorites.ID))
+}
+
+func Test_Export(t *testing.T) {
+	_, library := newTestServer(t)
+	cacheDir := t.TempDir()
+	exportDir := filepath.Join(t.TempDir(), "export")
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"export", "-user", "testuser", "-cache-dir", cacheDir, "-dir", exportDir,
+		"-playlist", "Favorites Queue", "-columns", "track,uri"})
+	assert.NoError(t, err)
+
+	files, _ := filepath.Glob(filepath.Join(exportDir, "*.csv"))
+	assert.Equal(t, []string{filepath.Join(exportDir, "Favorites Queue_"+string(library.queue.ID)+".csv"),
+		filepath.Join(exportDir, "all_playlists.csv")}, files)
+
+	err = run(context.Background(), []string{"export", "-user", "testuser", "-cache-dir", cacheDir, "-columns", "genre"})
+	assert.ErrorContains(t, err, "export.columns")
+}
+
+// Test_Export_Import_JSPF tests that an exported playlist can be imported and restored
+func Test_Export_Import_JSPF(t *testing.T) {
+	server, library := newTestServer(t)
+	cacheDir := t.TempDir()
+	exportDir := t.TempDir()
+	original := server.TrackIDs(library.favorites.ID)
+
+	err := run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"export", "-user", "testuser", "-cache-dir", cacheDir, "-dir", exportDir,
+		"-playlist", "Favorites", "-export-format", "jspf"})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"run", "-user", "testuser", "-cache-dir", cacheDir, "-remove"})
+	assert.NoError(t, err)
+	err = run(context.Background(), []string{"sync", "-user", "testuser", "-cache-dir", cacheDir})
+	assert.NoError(t, err)
+	assert.NotEqual(t, original, server.TrackIDs(library.favorites.ID))
+
+	err = run(context.Background(), []string{"import", "-user", "testuser", "-cache-dir", cacheDir,
+		"-file", filepath.Join(exportDir, "Favorites_"+string(library.favorites.ID)+".jspf")})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"restore", "-user", "testuser", "-cache-dir", cacheDir, "-playlist", "Favorites"})
+	assert.NoError(t, err)
+	assert.Equal(t, original, server.TrackIDs(library.favorites.ID))
+}
+
+// Test_Import_ListenBrainzJSPF tests that tracks identified only by MusicBrainz are
+// matched to Spotify, so the playlist can be restored
+func Test_Import_ListenBrainzJSPF(t *testing.T) {
+	server, _ := newTestServer(t)
+	isrcTrack := fakespotify.NewTrack("isrc1", "Obscure Title", "Someone", 190000)
+	isrcTrack.Track.ExternalIDs = map[string]string{"isrc": "USABC2200001"}
+	server.AddTracks(isrcTrack)
+	cacheDir := t.TempDir()
+	jspfFile := filepath.Join(t.TempDir(), "discovered.jspf")
+	err := os.WriteFile(jspfFile, []byte(`{"playlist": {"title": "Discovered", "creator": "listenbrainz", "track": [
+		{"title": "New Song", "creator": "Artist", "duration": 210000,
+			"identifier": ["https://musicbrainz.org/recording/8f3471b5-7e6a-48da-86a9-c1c07a0f47ae"]},
+		{"title": "Whatever", "identifier": ["https://musicbrainz.org/recording/e2d6d5f3-0d8b-4f2c-a7a1-0f6d1c6c5b34"],
+			"extension": {"https://musicbrainz.org/doc/jspf#track": {"additional_metadata": {"isrc": "USABC2200001"}}}},
+		{"title": "Unknown Song", "creator": "Nobody"}
+	]}}`), 0644)
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"import", "-user", "testuser", "-cache-dir", cacheDir, "-file", jspfFile})
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"restore", "-user", "testuser", "-cache-dir", cacheDir, "-playlist", "Discovered"})
+	assert.NoError(t, err)
+	playlists := server.Playlists()
+	assert.Equal(t, "Discovered", playlists[len(playlists)-1].Name)
+	assert.Equal(t, []spotify.ID{"new", "isrc1"}, server.TrackIDs(playlists[len(playlists)-1].ID))
+}
+
+func Test_Import_CSV(t *testing.T) {
+	server, library := newTestServer(t)
+	isrcTrack := fakespotify.NewTrack("isrc1", "Obscure Title", "Someone", 190000)
+	isrcTrack.Track.ExternalIDs = map[string]string{"isrc": "USABC2200001"}
+	server.AddTracks(isrcTrack)
+
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+	csvFile := filepath.Join(dir, "Spreadsheet.csv")
+	err := os.WriteFile(csvFile, []byte("Title,Artist,ISRC,Duration\n"+
+		"Whatever,,USABC2200001,\n"+
+		"New Song (Remastered),Artist,,3:30\n"+
+		"Unknown Song,Nobody,,4:00\n"), 0644)
+	assert.NoError(t, err)
+
+	err = run(context.Background(), []string{"import", "-user", "testuser", "-cache-dir", cacheDir, "-file", csvFile})
+	assert.NoError(t, err)
+
+	playlists := server.Playlists()
+	assert.Len(t, playlists, 5)
+	assert.Equal(t, "Spreadsheet", playlists[4].Name)
+	assert.Equal(t, []spotify.ID{"isrc1", "new"}, server.TrackIDs(playlists[4].ID))
+
+	report, err := os.ReadFile(filepath.Join(dir, "Spreadsheet_report.csv"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), "4,Unknown Song,Nobody,,,4:00,unresolved,")
+
+	// importing into an existing playlist only adds the tracks it lacks
+	err = run(context.Background(), []string{"import", "-user", "testuser", "-cache-dir", cacheDir, "-file", csvFile,
+		"-playlist", "Favorites Queue"})
+	assert.NoError(t, err)
+	assert.Equal(t, []spotify.ID{"liked", "new", "isrc1"}, server.TrackIDs(library.queue.ID))
+}
@@ -0,0 +1,758 @@
+// Package fakespotify is an in-process stand-in for the parts of the Spotify Web API
+// and accounts service used by this project, for offline end-to-end tests.
+package fakespotify
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	defaultPlaylistLimit = 20
+	maxPlaylistLimit     = 50
+	defaultTrackLimit    = 100
+	maxTrackLimit        = 100
+	maxTracksPerRequest  = 100
+	defaultSearchLimit   = 20
+	maxSearchLimit       = 50
+)
+
+// Server is a fake Spotify Web API and accounts service holding in-memory state
+// which tests can seed and inspect
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	playlists    map[spotify.ID]*playlist
+	order        []spotify.ID
+	codes        map[string]bool   // authorization codes which can be exchanged
+	challenges   map[string]string // PKCE code challenges of authorization codes
+	accessTokens map[string]bool
+	refresh      map[string]bool
+	rotating     map[string]bool // refresh tokens issued to PKCE clients, replaced when used
+	tokenCount   int
+	nextID       int
+	currentUser  string                                // owner of the access tokens
+	catalog      map[spotify.URI]spotify.PlaylistTrack // every known track, for adding to playlists
+	requests     map[string]int                        // count of requests, keyed by "METHOD /path"
+	failures     []int                                 // status codes of the next Web API responses
+}
+
+type playlist struct {
+	simple  spotify.SimplePlaylist
+	history [][]entry // entries of every snapshot, the last is the current one
+	nextKey int
+}
+
+// entry is a track at a position of a playlist. Entries keep their key when tracks
+// before them are removed, so positions of an older snapshot can be resolved.
+type entry struct {
+	key   int
+	track spotify.PlaylistTrack
+}
+
+// NewServer starts a fake server. It must be closed by the caller.
+func NewServer() *Server {
+	s := &Server{
+		playlists:    map[spotify.ID]*playlist{},
+		codes:        map[string]bool{},
+		challenges:   map[string]string{},
+		rotating:     map[string]bool{},
+		accessTokens: map[string]bool{},
+		refresh:      map[string]bool{},
+		requests:     map[string]int{},
+		currentUser:  "user",
+		catalog:      map[spotify.URI]spotify.PlaylistTrack{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", s.handleAuthorize)
+	mux.HandleFunc("/api/token", s.handleToken)
+	mux.HandleFunc("/v1/me", s.authorized(s.handleMe))
+	mux.HandleFunc("/v1/users/", s.authorized(s.handleUsers))
+	mux.HandleFunc("/v1/playlists/", s.authorized(s.handlePlaylists))
+	mux.HandleFunc("/v1/search", s.authorized(s.handleSearch))
+	s.Server = httptest.NewServer(s.count(mux))
+	return s
+}
+
+// APIURL returns the base URL of the fake Web API
+func (s *Server) APIURL() string {
+	return s.URL + "/v1/"
+}
+
+// AccountsURL returns the base URL of the fake accounts service
+func (s *Server) AccountsURL() string {
+	return s.URL
+}
+
+// AddAuthCode allows an authorization code to be exchanged for a token once
+func (s *Server) AddAuthCode(code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[code] = true
+}
+
+// AddRefreshToken allows a refresh token to be used to get new access tokens
+func (s *Server) AddRefreshToken(refreshToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh[refreshToken] = true
+}
+
+// SetCurrentUser sets the user who owns the access tokens, "user" by default
+func (s *Server) SetCurrentUser(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentUser = userID
+}
+
+// AddTracks adds tracks to the catalog, so they can be added to playlists. Tracks
+// of seeded playlists are added automatically.
+func (s *Server) AddTracks(tracks ...spotify.PlaylistTrack) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, track := range tracks {
+		s.catalog[track.Track.URI] = track
+	}
+}
+
+// AddPlaylist seeds a playlist owned by the given user and returns it
+func (s *Server) AddPlaylist(owner string, name string, tracks ...spotify.PlaylistTrack) spotify.SimplePlaylist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addPlaylist(owner, name, tracks...).current()
+}
+
+// Playlists returns the current state of every playlist, in the order they were added
+func (s *Server) Playlists() []spotify.SimplePlaylist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var playlists []spotify.SimplePlaylist
+	for _, id := range s.order {
+		playlists = append(playlists, s.playlists[id].current())
+	}
+	return playlists
+}
+
+func (s *Server) addPlaylist(owner string, name string, tracks ...spotify.PlaylistTrack) *playlist {
+	s.nextID++
+	id := spotify.ID(fmt.Sprintf("playlist%d", s.nextID))
+	p := &playlist{
+		simple: spotify.SimplePlaylist{
+			ID:    id,
+			Name:  name,
+			Owner: spotify.User{ID: owner},
+			URI:   spotify.URI("spotify:playlist:" + id),
+		},
+	}
+	p.history = [][]entry{p.newEntries(tracks)}
+	for _, track := range tracks {
+		s.catalog[track.Track.URI] = track
+	}
+	s.playlists[id] = p
+	s.order = append(s.order, id)
+	return p
+}
+
+// Playlist returns the current state of a playlist
+func (s *Server) Playlist(id spotify.ID) spotify.SimplePlaylist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.playlists[id].current()
+}
+
+// Tracks returns the current tracks of a playlist
+func (s *Server) Tracks(id spotify.ID) []spotify.PlaylistTrack {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.playlists[id].tracks()
+}
+
+// TrackIDs returns the IDs of the current tracks of a playlist, in order
+func (s *Server) TrackIDs(id spotify.ID) []spotify.ID {
+	var ids []spotify.ID
+	for _, track := range s.Tracks(id) {
+		ids = append(ids, track.Track.ID)
+	}
+	return ids
+}
+
+// RequestCount returns the number of requests made to an endpoint, ex: "DELETE /v1/playlists/playlist1/tracks"
+func (s *Server) RequestCount(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[endpoint]
+}
+
+// FailRequests makes the next count Web API requests fail with the given status.
+// Throttled (429) responses ask to retry immediately.
+func (s *Server) FailRequests(status int, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < count; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// NewTrack creates a playlist track for seeding
+func NewTrack(id string, name string, artist string, durationMs int) spotify.PlaylistTrack {
+	return spotify.PlaylistTrack{
+		AddedAt: "2022-01-01T00:00:00Z",
+		Track: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{
+				ID:       spotify.ID(id),
+				Name:     name,
+				Duration: durationMs,
+				URI:      spotify.URI("spotify:track:" + id),
+				Type:     "track",
+				Artists:  []spotify.SimpleArtist{{Name: artist, ID: spotify.ID("artist-" + artist)}},
+			},
+			Album: spotify.SimpleAlbum{Name: "Album of " + name},
+		},
+	}
+}
+
+// current returns the playlist with its current snapshot and track count
+func (p *playlist) current() spotify.SimplePlaylist {
+	simple := p.simple
+	simple.SnapshotID = p.snapshotID(len(p.history) - 1)
+	simple.Tracks = spotify.PlaylistTracks{Total: uint(len(p.entries()))}
+	return simple
+}
+
+func (p *playlist) snapshotID(version int) string {
+	return fmt.Sprintf("%s-snapshot-%d", p.simple.ID, version)
+}
+
+// snapshot returns the entries of a snapshot, or false when the ID is unknown
+func (p *playlist) snapshot(snapshotID string) ([]entry, bool) {
+	for version, entries := range p.history {
+		if p.snapshotID(version) == snapshotID {
+			return entries, true
+		}
+	}
+	return nil, false
+}
+
+// newEntries creates entries with new keys for tracks
+func (p *playlist) newEntries(tracks []spotify.PlaylistTrack) []entry {
+	var entries []entry
+	for _, track := range tracks {
+		entries = append(entries, entry{key: p.nextKey, track: track})
+		p.nextKey++
+	}
+	return entries
+}
+
+// entries returns the entries of the current snapshot
+func (p *playlist) entries() []entry {
+	return p.history[len(p.history)-1]
+}
+
+// tracks returns the tracks of the current snapshot
+func (p *playlist) tracks() []spotify.PlaylistTrack {
+	var tracks []spotify.PlaylistTrack
+	for _, e := range p.entries() {
+		tracks = append(tracks, e.track)
+	}
+	return tracks
+}
+
+// count records every request made to the server
+func (s *Server) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.Method+" "+r.URL.Path]++
+		s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized rejects requests without a valid access token
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		valid := s.accessTokens[token]
+		failure := 0
+		if len(s.failures) > 0 {
+			failure = s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		if failure == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		if failure != 0 {
+			writeError(w, failure, http.StatusText(failure))
+			return
+		}
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAuthorize serves /authorize as if the user had authorized the app, redirecting
+// to the redirect URI with a new authorization code and the state of the request. The
+// code of a request with a PKCE code challenge can only be exchanged with its verifier.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" || query.Get("response_type") != "code" {
+		writeError(w, http.StatusBadRequest, "Invalid authorization request")
+		return
+	}
+
+	s.mu.Lock()
+	s.tokenCount++
+	code := fmt.Sprintf("code-%d", s.tokenCount)
+	s.codes[code] = true
+	if query.Get("code_challenge_method") == "S256" {
+		s.challenges[code] = query.Get("code_challenge")
+	}
+	s.mu.Unlock()
+
+	values := redirectURI.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirectURI.RawQuery = values.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refreshToken := r.PostForm.Get("refresh_token")
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		if !s.codes[code] {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "Invalid authorization code"})
+			return
+		}
+		challenge, pkce := s.challenges[code]
+		if pkce && !verifyChallenge(challenge, r.PostForm.Get("code_verifier")) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code_verifier was incorrect"})
+			return
+		}
+		delete(s.codes, code)
+		delete(s.challenges, code)
+		s.tokenCount++
+		refreshToken = fmt.Sprintf("refresh-%d", s.tokenCount)
+		s.refresh[refreshToken] = true
+		s.rotating[refreshToken] = pkce
+	case "refresh_token":
+		if !s.refresh[refreshToken] {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "Invalid refresh token"})
+			return
+		}
+		if s.rotating[refreshToken] {
+			delete(s.refresh, refreshToken)
+			delete(s.rotating, refreshToken)
+			s.tokenCount++
+			refreshToken = fmt.Sprintf("refresh-%d", s.tokenCount)
+			s.refresh[refreshToken] = true
+			s.rotating[refreshToken] = true
+		}
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	s.tokenCount++
+	accessToken := fmt.Sprintf("access-%d", s.tokenCount)
+	s.accessTokens[accessToken] = true
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"refresh_token": refreshToken,
+	})
+}
+
+// verifyChallenge reports whether a PKCE code verifier matches its S256 code challenge
+func verifyChallenge(challenge string, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier != "" && base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+// handleMe serves /v1/me
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, spotify.User{ID: s.currentUser})
+}
+
+// handleUsers serves /v1/users/{user_id}/playlists
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/users/"), "/")
+	if len(parts) != 2 || parts[1] != "playlists" {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if r.Method == http.MethodPost {
+		s.createPlaylist(w, r, parts[0])
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	var items []interface{}
+	for _, id := range s.order {
+		items = append(items, s.playlists[id].current())
+	}
+	s.mu.Unlock()
+
+	s.writePage(w, r, items, defaultPlaylistLimit, maxPlaylistLimit)
+}
+
+// handlePlaylists serves /v1/playlists/{playlist_id}/tracks
+func (s *Server) handlePlaylists(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/playlists/"), "/")
+	if len(parts) != 2 || parts[1] != "tracks" {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	s.mu.Lock()
+	p, present := s.playlists[spotify.ID(parts[0])]
+	s.mu.Unlock()
+	if !present {
+		writeError(w, http.StatusNotFound, "Playlist not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		var items []interface{}
+		for _, track := range p.tracks() {
+			items = append(items, track)
+		}
+		s.mu.Unlock()
+		s.writePage(w, r, items, defaultTrackLimit, maxTrackLimit)
+	case http.MethodDelete:
+		s.removeTracks(w, r, p)
+	case http.MethodPost:
+		var body struct {
+			URIs []string `json:"uris"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.setTracks(w, p, body.URIs, false)
+	case http.MethodPut:
+		var uris []string
+		if value := r.URL.Query().Get("uris"); value != "" {
+			uris = strings.Split(value, ",")
+		}
+		s.setTracks(w, p, uris, true)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// removeTracks removes every occurrence of the given tracks or, when positions are
+// given, only the tracks at those positions of the given snapshot. Like the real API,
+// positions of an older snapshot are applied to the current playlist.
+func (s *Server) removeTracks(w http.ResponseWriter, r *http.Request, p *playlist) {
+	var body struct {
+		Tracks []struct {
+			URI       string `json:"uri"`
+			Positions []int  `json:"positions"`
+		} `json:"tracks"`
+		SnapshotID string `json:"snapshot_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(body.Tracks) > maxTracksPerRequest {
+		writeError(w, http.StatusBadRequest, "Too many tracks requested")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := p.entries()
+	if body.SnapshotID != "" {
+		var known bool
+		entries, known = p.snapshot(body.SnapshotID)
+		if !known {
+			writeError(w, http.StatusBadRequest, "Invalid snapshot id")
+			return
+		}
+	}
+
+	removeAll := map[string]bool{}
+	removeKeys := map[int]bool{}
+	for _, track := range body.Tracks {
+		if len(track.Positions) == 0 {
+			removeAll[track.URI] = true
+			continue
+		}
+		for _, position := range track.Positions {
+			if position < 0 || position >= len(entries) || string(entries[position].track.Track.URI) != track.URI {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Could not remove track %s at position %d", track.URI, position))
+				return
+			}
+			removeKeys[entries[position].key] = true
+		}
+	}
+
+	var kept []entry
+	for _, e := range p.entries() {
+		if removeAll[string(e.track.Track.URI)] || removeKeys[e.key] {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	p.history = append(p.history, kept)
+
+	writeJSON(w, http.StatusOK, map[string]string{"snapshot_id": p.current().SnapshotID})
+}
+
+// createPlaylist creates an empty playlist for the current user
+func (s *Server) createPlaylist(w http.ResponseWriter, r *http.Request, userID string) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if userID != s.currentUser {
+		writeError(w, http.StatusForbidden, "You cannot create a playlist for another user")
+		return
+	}
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, "Missing playlist name")
+		return
+	}
+	p := s.addPlaylist(userID, body.Name)
+	writeJSON(w, http.StatusCreated, spotify.FullPlaylist{SimplePlaylist: p.current()})
+}
+
+// searchTerm matches a field filter, such as isrc:ABC or track:"Some Song", or a word
+var searchTerm = regexp.MustCompile(`(\w+):"([^"]*)"|(\w+):(\S+)|(\S+)`)
+
+// handleSearch searches the catalog for tracks. The isrc, track, artist and album
+// field filters are supported, and any other word must appear in one of them.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if r.URL.Query().Get("type") != "track" {
+		writeError(w, http.StatusBadRequest, "Only track searches are supported")
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultSearchLimit)
+	if err != nil || limit < 1 || limit > maxSearchLimit {
+		writeError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	filters := map[string]string{}
+	var words []string
+	for _, match := range searchTerm.FindAllStringSubmatch(r.URL.Query().Get("q"), -1) {
+		switch {
+		case match[1] != "":
+			filters[strings.ToLower(match[1])] = strings.ToLower(match[2])
+		case match[3] != "":
+			filters[strings.ToLower(match[3])] = strings.ToLower(match[4])
+		default:
+			words = append(words, strings.ToLower(match[5]))
+		}
+	}
+
+	s.mu.Lock()
+	var uris []string
+	for uri := range s.catalog {
+		uris = append(uris, string(uri))
+	}
+	sort.Strings(uris)
+	tracks := []spotify.FullTrack{}
+	for _, uri := range uris {
+		track := s.catalog[spotify.URI(uri)].Track
+		if len(tracks) < limit && matchesSearch(track, filters, words) {
+			tracks = append(tracks, track)
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"tracks": map[string]interface{}{
+			"href":   s.URL + r.URL.RequestURI(),
+			"items":  tracks,
+			"limit":  limit,
+			"offset": 0,
+			"total":  len(tracks),
+		},
+	})
+}
+
+// matchesSearch checks whether a track matches every filter and word of a search
+func matchesSearch(track spotify.FullTrack, filters map[string]string, words []string) bool {
+	var artists []string
+	for _, artist := range track.Artists {
+		artists = append(artists, artist.Name)
+	}
+	fields := map[string]string{
+		"isrc":   strings.ToLower(track.ExternalIDs["isrc"]),
+		"track":  strings.ToLower(track.Name),
+		"artist": strings.ToLower(strings.Join(artists, " ")),
+		"album":  strings.ToLower(track.Album.Name),
+	}
+
+	for field, value := range filters {
+		if field == "isrc" && fields[field] != value {
+			return false
+		}
+		if !strings.Contains(fields[field], value) {
+			return false
+		}
+	}
+	all := fields["track"] + " " + fields["artist"] + " " + fields["album"]
+	for _, word := range words {
+		if !strings.Contains(all, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// setTracks appends tracks to a playlist, or replaces its tracks
+func (s *Server) setTracks(w http.ResponseWriter, p *playlist, uris []string, replace bool) {
+	if len(uris) > maxTracksPerRequest {
+		writeError(w, http.StatusBadRequest, "Too many tracks requested")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tracks []spotify.PlaylistTrack
+	for _, uri := range uris {
+		track, known := s.catalog[spotify.URI(uri)]
+		if !known {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid track uri: %s", uri))
+			return
+		}
+		tracks = append(tracks, track)
+	}
+
+	var entries []entry
+	if !replace {
+		entries = append(entries, p.entries()...)
+	}
+	p.history = append(p.history, append(entries, p.newEntries(tracks)...))
+
+	writeJSON(w, http.StatusCreated, map[string]string{"snapshot_id": p.current().SnapshotID})
+}
+
+// writePage writes a paging object with the items selected by the limit and offset
+// query parameters
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, items []interface{}, defaultLimit int, maxLimit int) {
+	limit, err := queryInt(r, "limit", defaultLimit)
+	if err != nil || limit < 1 || limit > maxLimit {
+		writeError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid offset")
+		return
+	}
+
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	page := []interface{}{}
+	if offset < len(items) {
+		page = items[offset:end]
+	}
+
+	pageURL := func(offset int) string {
+		return fmt.Sprintf("%s%s?offset=%d&limit=%d", s.URL, r.URL.Path, offset, limit)
+	}
+	var next, previous interface{}
+	if end < len(items) {
+		next = pageURL(end)
+	}
+	if offset > 0 {
+		previous = pageURL(0)
+		if offset > limit {
+			previous = pageURL(offset - limit)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"href":     pageURL(offset),
+		"items":    page,
+		"limit":    limit,
+		"offset":   offset,
+		"total":    len(items),
+		"next":     next,
+		"previous": previous,
+	})
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"status":  status,
+			"message": message,
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -5,11 +5,13 @@
 package mock_adapter
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
-	v2 "github.com/zmb3/spotify/v2"
-	auth "github.com/zmb3/spotify/v2/auth"
+	adapter "github.com/reeves122/spotify-automation-go/adapter"
+	spotify "github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
 	oauth2 "golang.org/x/oauth2"
 )
 
@@ -37,14 +39,14 @@ func (m *MockSpotifyAuthWrapperInterface) EXPECT() *MockSpotifyAuthWrapperInterf
 }
 
 // New mocks base method.
-func (m *MockSpotifyAuthWrapperInterface) New(opts ...auth.AuthenticatorOption) *auth.Authenticator {
+func (m *MockSpotifyAuthWrapperInterface) New(opts ...spotifyauth.AuthenticatorOption) *spotifyauth.Authenticator {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{}
 	for _, a := range opts {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "New", varargs...)
-	ret0, _ := ret[0].(*auth.Authenticator)
+	ret0, _ := ret[0].(*spotifyauth.Authenticator)
 	return ret0
 }
 
@@ -55,10 +57,10 @@ func (mr *MockSpotifyAuthWrapperInterfaceMockRecorder) New(opts ...interface{})
 }
 
 // WithRedirectURL mocks base method.
-func (m *MockSpotifyAuthWrapperInterface) WithRedirectURL(url string) auth.AuthenticatorOption {
+func (m *MockSpotifyAuthWrapperInterface) WithRedirectURL(url string) spotifyauth.AuthenticatorOption {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "WithRedirectURL", url)
-	ret0, _ := ret[0].(auth.AuthenticatorOption)
+	ret0, _ := ret[0].(spotifyauth.AuthenticatorOption)
 	return ret0
 }
 
@@ -69,14 +71,14 @@ func (mr *MockSpotifyAuthWrapperInterfaceMockRecorder) WithRedirectURL(url inter
 }
 
 // WithScopes mocks base method.
-func (m *MockSpotifyAuthWrapperInterface) WithScopes(scopes ...string) auth.AuthenticatorOption {
+func (m *MockSpotifyAuthWrapperInterface) WithScopes(scopes ...string) spotifyauth.AuthenticatorOption {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{}
 	for _, a := range scopes {
 		varargs = append(varargs, a)
 	}
 	ret := m.ctrl.Call(m, "WithScopes", varargs...)
-	ret0, _ := ret[0].(auth.AuthenticatorOption)
+	ret0, _ := ret[0].(spotifyauth.AuthenticatorOption)
 	return ret0
 }
 
@@ -109,6 +111,25 @@ func (m *MockSpotifyWrapperInterface) EXPECT() *MockSpotifyWrapperInterfaceMockR
 	return m.recorder
 }
 
+// AddTracksToPlaylist mocks base method.
+func (m *MockSpotifyWrapperInterface) AddTracksToPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, playlistID}
+	for _, a := range trackIDs {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "AddTracksToPlaylist", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddTracksToPlaylist indicates an expected call of AddTracksToPlaylist.
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) AddTracksToPlaylist(ctx, playlistID interface{}, trackIDs ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, playlistID}, trackIDs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddTracksToPlaylist", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).AddTracksToPlaylist), varargs...)
+}
+
 // CreateAuthenticator mocks base method.
 func (m *MockSpotifyWrapperInterface) CreateAuthenticator(redirectURL string) {
 	m.ctrl.T.Helper()
@@ -121,48 +142,91 @@ func (mr *MockSpotifyWrapperInterfaceMockRecorder) CreateAuthenticator(redirectU
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAuthenticator", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).CreateAuthenticator), redirectURL)
 }
 
+// CreatePlaylist mocks base method.
+func (m *MockSpotifyWrapperInterface) CreatePlaylist(ctx context.Context, name, description string) (spotify.SimplePlaylist, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreatePlaylist", ctx, name, description)
+	ret0, _ := ret[0].(spotify.SimplePlaylist)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreatePlaylist indicates an expected call of CreatePlaylist.
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) CreatePlaylist(ctx, name, description interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePlaylist", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).CreatePlaylist), ctx, name, description)
+}
+
 // GetAllPlaylistTracks mocks base method.
-func (m *MockSpotifyWrapperInterface) GetAllPlaylistTracks(playlistID v2.ID) ([]v2.PlaylistTrack, error) {
+func (m *MockSpotifyWrapperInterface) GetAllPlaylistTracks(ctx context.Context, playlistID spotify.ID) ([]spotify.PlaylistTrack, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllPlaylistTracks", playlistID)
-	ret0, _ := ret[0].([]v2.PlaylistTrack)
+	ret := m.ctrl.Call(m, "GetAllPlaylistTracks", ctx, playlistID)
+	ret0, _ := ret[0].([]spotify.PlaylistTrack)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAllPlaylistTracks indicates an expected call of GetAllPlaylistTracks.
-func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetAllPlaylistTracks(playlistID interface{}) *gomock.Call {
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetAllPlaylistTracks(ctx, playlistID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllPlaylistTracks", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetAllPlaylistTracks), playlistID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllPlaylistTracks", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetAllPlaylistTracks), ctx, playlistID)
 }
 
 // GetAllPlaylistsForUser mocks base method.
-func (m *MockSpotifyWrapperInterface) GetAllPlaylistsForUser(username string) ([]v2.SimplePlaylist, error) {
+func (m *MockSpotifyWrapperInterface) GetAllPlaylistsForUser(ctx context.Context, username string) ([]spotify.SimplePlaylist, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllPlaylistsForUser", username)
-	ret0, _ := ret[0].([]v2.SimplePlaylist)
+	ret := m.ctrl.Call(m, "GetAllPlaylistsForUser", ctx, username)
+	ret0, _ := ret[0].([]spotify.SimplePlaylist)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAllPlaylistsForUser indicates an expected call of GetAllPlaylistsForUser.
-func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetAllPlaylistsForUser(username interface{}) *gomock.Call {
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetAllPlaylistsForUser(ctx, username interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllPlaylistsForUser", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetAllPlaylistsForUser), username)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllPlaylistsForUser", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetAllPlaylistsForUser), ctx, username)
 }
 
 // GetAuthURL mocks base method.
-func (m *MockSpotifyWrapperInterface) GetAuthURL() string {
+func (m *MockSpotifyWrapperInterface) GetAuthURL(state string) string {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAuthURL")
+	ret := m.ctrl.Call(m, "GetAuthURL", state)
 	ret0, _ := ret[0].(string)
 	return ret0
 }
 
 // GetAuthURL indicates an expected call of GetAuthURL.
-func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetAuthURL() *gomock.Call {
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetAuthURL(state interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuthURL", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetAuthURL), state)
+}
+
+// GetCodeVerifier mocks base method.
+func (m *MockSpotifyWrapperInterface) GetCodeVerifier() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCodeVerifier")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// GetCodeVerifier indicates an expected call of GetCodeVerifier.
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetCodeVerifier() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuthURL", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetAuthURL))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCodeVerifier", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetCodeVerifier))
+}
+
+// GetRequestStats mocks base method.
+func (m *MockSpotifyWrapperInterface) GetRequestStats() adapter.RequestStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRequestStats")
+	ret0, _ := ret[0].(adapter.RequestStats)
+	return ret0
+}
+
+// GetRequestStats indicates an expected call of GetRequestStats.
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetRequestStats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRequestStats", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetRequestStats))
 }
 
 // GetToken mocks base method.
@@ -181,36 +245,56 @@ func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetToken() *gomock.Call {
 }
 
 // GetTokenFromResponseCode mocks base method.
-func (m *MockSpotifyWrapperInterface) GetTokenFromResponseCode(responseCode string) (*oauth2.Token, error) {
+func (m *MockSpotifyWrapperInterface) GetTokenFromResponseCode(ctx context.Context, responseCode string) (*oauth2.Token, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetTokenFromResponseCode", responseCode)
+	ret := m.ctrl.Call(m, "GetTokenFromResponseCode", ctx, responseCode)
 	ret0, _ := ret[0].(*oauth2.Token)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetTokenFromResponseCode indicates an expected call of GetTokenFromResponseCode.
-func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetTokenFromResponseCode(responseCode interface{}) *gomock.Call {
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) GetTokenFromResponseCode(ctx, responseCode interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTokenFromResponseCode", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetTokenFromResponseCode), responseCode)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTokenFromResponseCode", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).GetTokenFromResponseCode), ctx, responseCode)
 }
 
 // LoginAndCreateClient mocks base method.
-func (m *MockSpotifyWrapperInterface) LoginAndCreateClient(token *oauth2.Token) {
+func (m *MockSpotifyWrapperInterface) LoginAndCreateClient(ctx context.Context, token *oauth2.Token, saveToken func(*oauth2.Token) error) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "LoginAndCreateClient", token)
+	m.ctrl.Call(m, "LoginAndCreateClient", ctx, token, saveToken)
 }
 
 // LoginAndCreateClient indicates an expected call of LoginAndCreateClient.
-func (mr *MockSpotifyWrapperInterfaceMockRecorder) LoginAndCreateClient(token interface{}) *gomock.Call {
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) LoginAndCreateClient(ctx, token, saveToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoginAndCreateClient", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).LoginAndCreateClient), ctx, token, saveToken)
+}
+
+// RemoveTrackPositionsFromPlaylist mocks base method.
+func (m *MockSpotifyWrapperInterface) RemoveTrackPositionsFromPlaylist(ctx context.Context, playlistID spotify.ID, snapshotID string, tracks ...spotify.TrackToRemove) (string, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, playlistID, snapshotID}
+	for _, a := range tracks {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RemoveTrackPositionsFromPlaylist", varargs...)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveTrackPositionsFromPlaylist indicates an expected call of RemoveTrackPositionsFromPlaylist.
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) RemoveTrackPositionsFromPlaylist(ctx, playlistID, snapshotID interface{}, tracks ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoginAndCreateClient", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).LoginAndCreateClient), token)
+	varargs := append([]interface{}{ctx, playlistID, snapshotID}, tracks...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveTrackPositionsFromPlaylist", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).RemoveTrackPositionsFromPlaylist), varargs...)
 }
 
 // RemoveTracksFromPlaylist mocks base method.
-func (m *MockSpotifyWrapperInterface) RemoveTracksFromPlaylist(playlistID v2.ID, trackIDs ...v2.ID) error {
+func (m *MockSpotifyWrapperInterface) RemoveTracksFromPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) error {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{playlistID}
+	varargs := []interface{}{ctx, playlistID}
 	for _, a := range trackIDs {
 		varargs = append(varargs, a)
 	}
@@ -220,8 +304,54 @@ func (m *MockSpotifyWrapperInterface) RemoveTracksFromPlaylist(playlistID v2.ID,
 }
 
 // RemoveTracksFromPlaylist indicates an expected call of RemoveTracksFromPlaylist.
-func (mr *MockSpotifyWrapperInterfaceMockRecorder) RemoveTracksFromPlaylist(playlistID interface{}, trackIDs ...interface{}) *gomock.Call {
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) RemoveTracksFromPlaylist(ctx, playlistID interface{}, trackIDs ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{playlistID}, trackIDs...)
+	varargs := append([]interface{}{ctx, playlistID}, trackIDs...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveTracksFromPlaylist", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).RemoveTracksFromPlaylist), varargs...)
 }
+
+// ReplacePlaylistTracks mocks base method.
+func (m *MockSpotifyWrapperInterface) ReplacePlaylistTracks(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, playlistID}
+	for _, a := range trackIDs {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ReplacePlaylistTracks", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplacePlaylistTracks indicates an expected call of ReplacePlaylistTracks.
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) ReplacePlaylistTracks(ctx, playlistID interface{}, trackIDs ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, playlistID}, trackIDs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplacePlaylistTracks", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).ReplacePlaylistTracks), varargs...)
+}
+
+// SearchTracks mocks base method.
+func (m *MockSpotifyWrapperInterface) SearchTracks(ctx context.Context, query string, limit int) ([]spotify.FullTrack, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchTracks", ctx, query, limit)
+	ret0, _ := ret[0].([]spotify.FullTrack)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchTracks indicates an expected call of SearchTracks.
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) SearchTracks(ctx, query, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchTracks", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).SearchTracks), ctx, query, limit)
+}
+
+// SetCodeVerifier mocks base method.
+func (m *MockSpotifyWrapperInterface) SetCodeVerifier(verifier string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetCodeVerifier", verifier)
+}
+
+// SetCodeVerifier indicates an expected call of SetCodeVerifier.
+func (mr *MockSpotifyWrapperInterfaceMockRecorder) SetCodeVerifier(verifier interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetCodeVerifier", reflect.TypeOf((*MockSpotifyWrapperInterface)(nil).SetCodeVerifier), verifier)
+}
@@ -1,7 +1,10 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 
 	"github.com/reeves122/spotify-automation-go/adapter"
@@ -10,6 +13,10 @@ import (
 	"golang.org/x/oauth2"
 )
 
+// manualState is the state of the authorize URL when the code is copied by hand, so
+// the callback is never received by this app
+const manualState = "spotify-automation-go"
+
 type auth struct {
 	spotify adapter.SpotifyWrapperInterface
 	storage service.StorageInterface
@@ -22,62 +29,95 @@ func NewAuth(spotify adapter.SpotifyWrapperInterface, storage service.StorageInt
 	}
 }
 
-func (a *auth) Login(redirectURL string, tokenFile string) error {
+func (a *auth) Login(ctx context.Context, redirectURL string, tokenFile string) error {
 	a.spotify.CreateAuthenticator(redirectURL)
+	return a.login(ctx, tokenFile)
+}
 
-	token, err := a.storage.LoadToken(tokenFile)
+// login logs in with the saved token, or with the code in RESPONSE_CODE if there is
+// none. The token is saved when it is created and each time the client refreshes it.
+func (a *auth) login(ctx context.Context, tokenFile string) error {
+	token, err := a.storage.LoadToken(ctx, tokenFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		// a token which can't be read, ex. with the wrong passphrase, is not replaced
+		return fmt.Errorf("unable to load auth token: %w", err)
+	}
 	if err != nil {
-		err = a.checkForResponseUrl()
+		err = a.checkForResponseUrl(ctx, tokenFile)
 		if err != nil {
 			return err
 		}
-		token, err = a.createAndSaveToken(tokenFile)
+		token, err = a.createAndSaveToken(ctx, tokenFile)
 		if err != nil {
 			return err
 		}
 	}
 
 	log.Info("Logging in using saved token")
-	a.spotify.LoginAndCreateClient(token)
-
-	newToken, err := a.spotify.GetToken()
-	if err != nil {
-		return err
-	}
+	a.spotify.LoginAndCreateClient(ctx, token, a.tokenSaver(ctx, tokenFile))
+	return nil
+}
 
-	log.Info("Updating saved token")
-	err = a.storage.SaveToken(newToken, tokenFile)
-	if err != nil {
-		log.Error("Unable to save token to file: ", tokenFile)
-		return err
+// tokenSaver returns the function which saves each token the client refreshes during
+// the run, as Spotify may have replaced the refresh token along with it
+func (a *auth) tokenSaver(ctx context.Context, tokenFile string) func(token *oauth2.Token) error {
+	// a refreshed token is still saved while the run is being cancelled
+	ctx = context.WithoutCancel(ctx)
+	return func(token *oauth2.Token) error {
+		log.Info("Saving refreshed token")
+		return a.storage.SaveToken(ctx, token, tokenFile)
 	}
-
-	return nil
 }
 
-func (a *auth) checkForResponseUrl() error {
+func (a *auth) checkForResponseUrl(ctx context.Context, tokenFile string) error {
 	if responseUrl := os.Getenv("RESPONSE_CODE"); responseUrl == "" {
+		// the code is exchanged by the next run, which needs the verifier of this one
+		if verifier := a.spotify.GetCodeVerifier(); verifier != "" {
+			err := a.storage.SaveCodeVerifier(ctx, verifier, tokenFile)
+			if err != nil {
+				return err
+			}
+		}
 
 		log.Info("Response code not found. Please use the below URL to authorize this " +
 			"application and then set the RESPONSE_CODE env variable to the code " +
 			"spotify responds with and run this application again")
 
-		log.Info(a.spotify.GetAuthURL())
+		log.Info(a.spotify.GetAuthURL(manualState))
 		return fmt.Errorf("response code not found")
 	}
 	return nil
 }
 
-func (a *auth) createAndSaveToken(tokenFile string) (*oauth2.Token, error) {
+func (a *auth) createAndSaveToken(ctx context.Context, tokenFile string) (*oauth2.Token, error) {
+	pkce := a.spotify.GetCodeVerifier() != ""
+	if pkce {
+		verifier, err := a.storage.LoadCodeVerifier(ctx, tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		if verifier == "" {
+			return nil, fmt.Errorf("no code verifier is saved for RESPONSE_CODE, unset it and run again to get a new authorization URL")
+		}
+		a.spotify.SetCodeVerifier(verifier)
+	}
+
 	log.Info("Attempting to get token using RESPONSE_CODE")
-	token, err := a.spotify.GetTokenFromResponseCode(os.Getenv("RESPONSE_CODE"))
+	token, err := a.spotify.GetTokenFromResponseCode(ctx, os.Getenv("RESPONSE_CODE"))
 	if err != nil {
 		log.Error("Unable to get token")
 		return nil, err
 	}
+	if pkce {
+		// the code and its verifier can only be used once
+		err = a.storage.SaveCodeVerifier(ctx, "", tokenFile)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	log.Infof("Saving token to file: %s\n", tokenFile)
-	err = a.storage.SaveToken(token, tokenFile)
+	err = a.storage.SaveToken(ctx, token, tokenFile)
 	if err != nil {
 		log.Error("Unable to save token to file: ", tokenFile)
 		return nil, err
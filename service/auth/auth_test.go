@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
@@ -38,9 +39,10 @@ func Test_CheckForResponseUrl_Missing(t *testing.T) {
 	s := storage.NewStorage("test", true)
 	a := auth{spotify: mockWrapper, storage: s}
 
-	mockWrapper.EXPECT().GetAuthURL().Return("https://dummyurl")
+	mockWrapper.EXPECT().GetCodeVerifier().Return("")
+	mockWrapper.EXPECT().GetAuthURL(manualState).Return("https://dummyurl")
 
-	assert.Error(t, a.checkForResponseUrl())
+	assert.Error(t, a.checkForResponseUrl(context.Background(), "token.json"))
 }
 
 // Test_CheckForResponseUrl_Present tests with RESPONSE_CODE present
@@ -54,7 +56,7 @@ func Test_CheckForResponseUrl_Present(t *testing.T) {
 
 	_ = os.Setenv("RESPONSE_CODE", "abc123")
 
-	assert.NoError(t, a.checkForResponseUrl())
+	assert.NoError(t, a.checkForResponseUrl(context.Background(), "token.json"))
 }
 
 // Test_CreateAndSaveToken_Success tests saving a token without error
@@ -68,9 +70,10 @@ func Test_CreateAndSaveToken_Success(t *testing.T) {
 	a := auth{spotify: mockWrapper, storage: s}
 
 	_ = os.Setenv("RESPONSE_CODE", "abc123")
-	mockWrapper.EXPECT().GetTokenFromResponseCode("abc123").Return(testToken, nil)
+	mockWrapper.EXPECT().GetCodeVerifier().Return("")
+	mockWrapper.EXPECT().GetTokenFromResponseCode(gomock.Any(), "abc123").Return(testToken, nil)
 
-	result, err := a.createAndSaveToken("token.json")
+	result, err := a.createAndSaveToken(context.Background(), "token.json")
 	assert.NoError(t, err)
 	assert.Equal(t, testToken, result)
 }
@@ -85,13 +88,86 @@ func Test_CreateAndSaveToken_MissingEnv(t *testing.T) {
 	defer cleanUp("test")
 	a := auth{spotify: mockWrapper, storage: s}
 
-	mockWrapper.EXPECT().GetTokenFromResponseCode("").Return(nil, fmt.Errorf("test error"))
+	mockWrapper.EXPECT().GetCodeVerifier().Return("")
+	mockWrapper.EXPECT().GetTokenFromResponseCode(gomock.Any(), "").Return(nil, fmt.Errorf("test error"))
 
-	result, err := a.createAndSaveToken("token.json")
+	result, err := a.createAndSaveToken(context.Background(), "token.json")
 	assert.Error(t, err)
 	assert.Nil(t, result)
 }
 
+// Test_CheckForResponseUrl_PKCE tests that the code verifier is saved for the next run
+func Test_CheckForResponseUrl_PKCE(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage(t.TempDir(), false)
+	a := auth{spotify: mockWrapper, storage: s}
+
+	t.Setenv("RESPONSE_CODE", "")
+	mockWrapper.EXPECT().GetCodeVerifier().Return("verifier")
+	mockWrapper.EXPECT().GetAuthURL(manualState).Return("https://dummyurl")
+
+	assert.Error(t, a.checkForResponseUrl(context.Background(), "token.json"))
+	verifier, err := s.LoadCodeVerifier(context.Background(), "token.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "verifier", verifier)
+}
+
+// Test_CreateAndSaveToken_PKCE tests exchanging the code with the saved code verifier
+func Test_CreateAndSaveToken_PKCE(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage(t.TempDir(), false)
+	a := auth{spotify: mockWrapper, storage: s}
+	_ = s.SaveCodeVerifier(context.Background(), "saved", "token.json")
+
+	t.Setenv("RESPONSE_CODE", "abc123")
+	mockWrapper.EXPECT().GetCodeVerifier().Return("new")
+	mockWrapper.EXPECT().SetCodeVerifier("saved")
+	mockWrapper.EXPECT().GetTokenFromResponseCode(gomock.Any(), "abc123").Return(testToken, nil)
+
+	result, err := a.createAndSaveToken(context.Background(), "token.json")
+	assert.NoError(t, err)
+	assert.Equal(t, testToken, result)
+	verifier, _ := s.LoadCodeVerifier(context.Background(), "token.json")
+	assert.Empty(t, verifier)
+}
+
+// Test_CreateAndSaveToken_PKCENoVerifier tests with no code verifier saved for the code
+func Test_CreateAndSaveToken_PKCENoVerifier(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage(t.TempDir(), false)
+	a := auth{spotify: mockWrapper, storage: s}
+
+	t.Setenv("RESPONSE_CODE", "abc123")
+	mockWrapper.EXPECT().GetCodeVerifier().Return("new")
+
+	result, err := a.createAndSaveToken(context.Background(), "token.json")
+	assert.ErrorContains(t, err, "no code verifier is saved")
+	assert.Nil(t, result)
+}
+
+// Test_TokenSaver tests saving a refreshed token, even once the run is cancelled
+func Test_TokenSaver(t *testing.T) {
+	s := storage.NewStorage(t.TempDir(), false)
+	a := auth{storage: s}
+	ctx, cancel := context.WithCancel(context.Background())
+	save := a.tokenSaver(ctx, "token.json")
+	cancel()
+
+	assert.NoError(t, save(testToken))
+	result, err := s.LoadToken(context.Background(), "token.json")
+	assert.NoError(t, err)
+	assert.Equal(t, testToken, result)
+}
+
 // Test_Login_WithoutToken tests without loading a previous token
 func Test_Login_WithoutToken(t *testing.T) {
 	ctrl := gomock.NewController(t)
@@ -104,10 +180,30 @@ func Test_Login_WithoutToken(t *testing.T) {
 
 	_ = os.Setenv("RESPONSE_CODE", "abc123")
 	mockWrapper.EXPECT().CreateAuthenticator("http://test")
-	mockWrapper.EXPECT().GetTokenFromResponseCode("abc123").Return(testToken, nil)
-	mockWrapper.EXPECT().LoginAndCreateClient(testToken)
-	mockWrapper.EXPECT().GetToken().Return(testToken, nil)
+	mockWrapper.EXPECT().GetCodeVerifier().Return("")
+	mockWrapper.EXPECT().GetTokenFromResponseCode(gomock.Any(), "abc123").Return(testToken, nil)
+	mockWrapper.EXPECT().LoginAndCreateClient(gomock.Any(), testToken, gomock.Any())
 
-	err := a.Login("http://test", "token.json")
+	err := a.Login(context.Background(), "http://test", "token.json")
 	assert.NoError(t, err)
 }
+
+// Test_Login_UnreadableToken tests that a token which can't be decrypted is not replaced
+func Test_Login_UnreadableToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cacheDir := t.TempDir()
+	_ = storage.NewStorage(cacheDir, false, storage.WithTokenSecret([]byte("passphrase"))).
+		SaveToken(context.Background(), testToken, "token.json")
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage(cacheDir, false, storage.WithTokenSecret([]byte("wrong")))
+	a := auth{spotify: mockWrapper, storage: s}
+
+	t.Setenv("RESPONSE_CODE", "abc123")
+	mockWrapper.EXPECT().CreateAuthenticator("http://test")
+
+	err := a.Login(context.Background(), "http://test", "token.json")
+	assert.ErrorIs(t, err, storage.ErrTokenKey)
+}
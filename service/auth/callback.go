@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// callbackResult is the outcome of the authorization callback
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// LoginWithCallback authorizes the app without copying the code by hand. It serves
+// the redirect URL on listenAddr (the host and port of the redirect URL if empty),
+// logs the URL at which to authorize the app and waits up to timeout for Spotify to
+// redirect back with the code. The code is exchanged for a token, which replaces any
+// saved token, and the login continues as with Login.
+func (a *auth) LoginWithCallback(ctx context.Context, redirectURL string, listenAddr string, tokenFile string,
+	timeout time.Duration) error {
+
+	a.spotify.CreateAuthenticator(redirectURL)
+
+	code, err := a.waitForCallback(ctx, redirectURL, listenAddr, timeout)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Received authorization code, getting token")
+	token, err := a.spotify.GetTokenFromResponseCode(ctx, code)
+	if err != nil {
+		log.Error("Unable to get token")
+		return err
+	}
+
+	log.Infof("Saving token to file: %s", tokenFile)
+	err = a.storage.SaveToken(ctx, token, tokenFile)
+	if err != nil {
+		log.Error("Unable to save token to file: ", tokenFile)
+		return err
+	}
+	return a.login(ctx, tokenFile)
+}
+
+// waitForCallback serves the path of the redirect URL until a request with the state
+// of the authorize URL arrives, and returns its code. Requests with any other state
+// are rejected and ignored.
+func (a *auth) waitForCallback(ctx context.Context, redirectURL string, listenAddr string, timeout time.Duration) (string, error) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid redirect URL: %w", err)
+	}
+	if u.Scheme != "http" {
+		return "", fmt.Errorf("the redirect URL must be http to receive the callback: %s", redirectURL)
+	}
+	if listenAddr == "" {
+		listenAddr = u.Host
+		if u.Port() == "" {
+			listenAddr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	state, err := newState()
+	if err != nil {
+		return "", err
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return "", fmt.Errorf("unable to listen for the callback: %w", err)
+	}
+
+	results := make(chan callbackResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		result, status := parseCallback(r, state)
+		if status != http.StatusOK {
+			http.Error(w, result.err.Error(), status)
+		} else {
+			_, _ = fmt.Fprintln(w, "Logged in, this window can be closed.")
+		}
+		if status == http.StatusForbidden {
+			log.Warnf("Ignoring callback: %v", result.err)
+			return
+		}
+		select {
+		case results <- result:
+		default:
+		}
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.Infof("Listening for the callback on %s", listener.Addr())
+	log.Infof("Open this URL in a browser to authorize this application: %s", a.spotify.GetAuthURL(state))
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-results:
+		return result.code, result.err
+	case <-timer.C:
+		return "", fmt.Errorf("timed out after %s waiting for authorization", timeout)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// parseCallback returns the code of a callback request, or the error Spotify
+// responded with, along with the status of the response to it. Requests without the
+// expected state are forbidden.
+func parseCallback(r *http.Request, state string) (callbackResult, int) {
+	query := r.URL.Query()
+	if query.Get("state") != state {
+		return callbackResult{err: errors.New("state does not match the authorize URL")}, http.StatusForbidden
+	}
+	if reason := query.Get("error"); reason != "" {
+		return callbackResult{err: fmt.Errorf("authorization failed: %s", reason)}, http.StatusBadRequest
+	}
+	code := query.Get("code")
+	if code == "" {
+		return callbackResult{err: errors.New("callback has no authorization code")}, http.StatusBadRequest
+	}
+	return callbackResult{code: code}, http.StatusOK
+}
+
+// newState returns a random state, which ties the callback to the authorize URL
+func newState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/reeves122/spotify-automation-go/service/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRedirectURL returns a redirect URL on a free local port
+func newRedirectURL(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	_ = listener.Close()
+	return "http://" + addr + "/callback"
+}
+
+// expectAuthURL returns a channel which receives the state of the authorize URL
+func expectAuthURL(mockWrapper *mock_adapter.MockSpotifyWrapperInterface) chan string {
+	states := make(chan string, 1)
+	mockWrapper.EXPECT().GetAuthURL(gomock.Any()).DoAndReturn(func(state string) string {
+		states <- state
+		return "https://dummyurl"
+	})
+	return states
+}
+
+// callback sends a request to the redirect URL with the given query and returns its status
+func callback(t *testing.T, redirectURL string, query url.Values) int {
+	resp, err := http.Get(redirectURL + "?" + query.Encode())
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func Test_LoginWithCallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage(t.TempDir(), false)
+	a := auth{spotify: mockWrapper, storage: s}
+	redirectURL := newRedirectURL(t)
+
+	mockWrapper.EXPECT().CreateAuthenticator(redirectURL)
+	states := expectAuthURL(mockWrapper)
+	mockWrapper.EXPECT().GetTokenFromResponseCode(gomock.Any(), "abc123").Return(testToken, nil)
+	mockWrapper.EXPECT().LoginAndCreateClient(gomock.Any(), testToken, gomock.Any())
+
+	go func() {
+		state := <-states
+		assert.Equal(t, http.StatusForbidden, callback(t, redirectURL, url.Values{"state": {"forged"}, "code": {"bad"}}))
+		assert.Equal(t, http.StatusOK, callback(t, redirectURL, url.Values{"state": {state}, "code": {"abc123"}}))
+	}()
+
+	err := a.LoginWithCallback(context.Background(), redirectURL, "", "token.json", 5*time.Second)
+	assert.NoError(t, err)
+
+	token, _ := s.LoadToken(context.Background(), "token.json")
+	assert.Equal(t, testToken, token)
+}
+
+// Test_LoginWithCallback_Denied tests that an authorization refused by the user ends the login
+func Test_LoginWithCallback_Denied(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	a := auth{spotify: mockWrapper, storage: storage.NewStorage(t.TempDir(), false)}
+	redirectURL := newRedirectURL(t)
+
+	mockWrapper.EXPECT().CreateAuthenticator(redirectURL)
+	states := expectAuthURL(mockWrapper)
+
+	go func() {
+		state := <-states
+		assert.Equal(t, http.StatusBadRequest, callback(t, redirectURL, url.Values{"state": {state}, "error": {"access_denied"}}))
+	}()
+
+	err := a.LoginWithCallback(context.Background(), redirectURL, "", "token.json", 5*time.Second)
+	assert.EqualError(t, err, "authorization failed: access_denied")
+}
+
+func Test_LoginWithCallback_Timeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	a := auth{spotify: mockWrapper, storage: storage.NewStorage(t.TempDir(), false)}
+	redirectURL := newRedirectURL(t)
+
+	mockWrapper.EXPECT().CreateAuthenticator(redirectURL)
+	expectAuthURL(mockWrapper)
+
+	err := a.LoginWithCallback(context.Background(), redirectURL, "", "token.json", 100*time.Millisecond)
+	assert.EqualError(t, err, fmt.Sprintf("timed out after %s waiting for authorization", 100*time.Millisecond))
+}
+
+func Test_LoginWithCallback_HTTPS(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	a := auth{spotify: mockWrapper, storage: storage.NewStorage(t.TempDir(), false)}
+
+	mockWrapper.EXPECT().CreateAuthenticator("https://example.com/callback")
+
+	err := a.LoginWithCallback(context.Background(), "https://example.com/callback", "", "token.json", time.Second)
+	assert.ErrorContains(t, err, "must be http")
+}
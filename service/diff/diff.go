@@ -0,0 +1,246 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Change types
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeMoved   = "moved"
+)
+
+// Sources of a version of a playlist
+const (
+	SourceCache = "cache"
+	SourceLive  = "live"
+)
+
+// Version describes one side of a diff
+type Version struct {
+	Source     string    `json:"source"`
+	SnapshotID string    `json:"snapshot_id"`
+	UpdatedAt  time.Time `json:"updated_at"` // time the version was cached, zero for live versions
+}
+
+// Change is a track added, removed or moved between two versions of a playlist.
+// Positions are of the old version for removed tracks, of the new version for added
+// tracks, and of both for moved tracks.
+type Change struct {
+	Type        string     `json:"type"`
+	TrackID     spotify.ID `json:"track_id"`
+	TrackName   string     `json:"track_name"`
+	Artist      string     `json:"artist"`
+	AddedAt     string     `json:"added_at"`
+	OldPosition *int       `json:"old_position,omitempty"`
+	NewPosition *int       `json:"new_position,omitempty"`
+}
+
+// Diff is every change between two versions of a playlist
+type Diff struct {
+	Playlist string   `json:"playlist"`
+	From     Version  `json:"from"`
+	To       Version  `json:"to"`
+	Changes  []Change `json:"changes"`
+}
+
+// Compare finds the tracks added, removed and moved between the old and new tracks
+// of a playlist. Copies of the same track are paired in order, and the fewest tracks
+// needed to explain the new order are reported as moved.
+func Compare(playlist string, from Version, to Version, oldTracks []spotify.PlaylistTrack, newTracks []spotify.PlaylistTrack) *Diff {
+	d := &Diff{
+		Playlist: playlist,
+		From:     from,
+		To:       to,
+	}
+
+	newPositions := map[string][]int{}
+	for i, track := range newTracks {
+		key := trackKey(track)
+		newPositions[key] = append(newPositions[key], i)
+	}
+
+	// pairs[i] is the new position of the old track at position i, or -1 if removed
+	pairs := make([]int, len(oldTracks))
+	matched := make([]bool, len(newTracks))
+	for i, track := range oldTracks {
+		key := trackKey(track)
+		if len(newPositions[key]) == 0 {
+			oldPosition := i
+			pairs[i] = -1
+			d.Changes = append(d.Changes, newChange(ChangeRemoved, track, &oldPosition, nil))
+			continue
+		}
+		pairs[i] = newPositions[key][0]
+		newPositions[key] = newPositions[key][1:]
+		matched[pairs[i]] = true
+	}
+
+	moved := movedTracks(pairs)
+	var changes []Change
+	for i, track := range newTracks {
+		newPosition := i
+		if !matched[i] {
+			changes = append(changes, newChange(ChangeAdded, track, nil, &newPosition))
+		}
+	}
+	for oldPosition, newPosition := range pairs {
+		if newPosition >= 0 && moved[oldPosition] {
+			oldPosition, newPosition := oldPosition, newPosition
+			changes = append(changes, newChange(ChangeMoved, newTracks[newPosition], &oldPosition, &newPosition))
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return *changes[i].NewPosition < *changes[j].NewPosition
+	})
+
+	d.Changes = append(d.Changes, changes...)
+	return d
+}
+
+// movedTracks returns the old positions of the tracks which are not part of the
+// longest run of tracks kept in their relative order
+func movedTracks(pairs []int) map[int]bool {
+	// tails[k] is the old position ending the best increasing run of length k+1
+	var tails []int
+	previous := make([]int, len(pairs))
+	for i, newPosition := range pairs {
+		if newPosition < 0 {
+			continue
+		}
+		k := sort.Search(len(tails), func(k int) bool {
+			return pairs[tails[k]] >= newPosition
+		})
+		previous[i] = -1
+		if k > 0 {
+			previous[i] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+
+	kept := map[int]bool{}
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = previous[i] {
+			kept[i] = true
+		}
+	}
+
+	moved := map[int]bool{}
+	for i, newPosition := range pairs {
+		if newPosition >= 0 && !kept[i] {
+			moved[i] = true
+		}
+	}
+	return moved
+}
+
+// trackKey identifies a track across versions, by ID or, for local files, by URI
+func trackKey(track spotify.PlaylistTrack) string {
+	if track.Track.ID != "" {
+		return string(track.Track.ID)
+	}
+	return string(track.Track.URI)
+}
+
+func newChange(changeType string, track spotify.PlaylistTrack, oldPosition *int, newPosition *int) Change {
+	return Change{
+		Type:        changeType,
+		TrackID:     track.Track.ID,
+		TrackName:   track.Track.Name,
+		Artist:      service.PrimaryArtist(track.Track),
+		AddedAt:     track.AddedAt,
+		OldPosition: oldPosition,
+		NewPosition: newPosition,
+	}
+}
+
+// Count returns the number of changes of the given type
+func (d *Diff) Count(changeType string) int {
+	count := 0
+	for _, change := range d.Changes {
+		if change.Type == changeType {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteText writes the diff as a human readable table
+func (d *Diff) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s: %s -> %s\n", d.Playlist, d.From, d.To)
+	if err != nil {
+		return err
+	}
+	if len(d.Changes) == 0 {
+		_, err = fmt.Fprintln(w, "No changes")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "CHANGE\tPOSITION\tTRACK\tARTIST\tADDED AT")
+	for _, change := range d.Changes {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", change.Type, change.position(),
+			change.TrackName, change.Artist, change.AddedAt)
+	}
+	_, _ = fmt.Fprintf(tw, "\n%d added, %d removed, %d moved\n",
+		d.Count(ChangeAdded), d.Count(ChangeRemoved), d.Count(ChangeMoved))
+	return tw.Flush()
+}
+
+// WriteJSON writes the diff as indented JSON
+func (d *Diff) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(d)
+}
+
+// Write writes the diff in the given format, either "text" or "json"
+func (d *Diff) Write(w io.Writer, format string) error {
+	switch format {
+	case "text", "":
+		return d.WriteText(w)
+	case "json":
+		return d.WriteJSON(w)
+	default:
+		return fmt.Errorf("unknown diff format: %s", format)
+	}
+}
+
+// String describes the version, such as "cache 2022-02-01 12:00:00 (snapshot1)"
+func (v Version) String() string {
+	description := v.Source
+	if !v.UpdatedAt.IsZero() {
+		description += " " + v.UpdatedAt.Local().Format("2006-01-02 15:04:05")
+	}
+	if v.SnapshotID != "" {
+		description += " (" + v.SnapshotID + ")"
+	}
+	return description
+}
+
+// position describes the position of the change, such as "4" or "2 -> 7"
+func (c Change) position() string {
+	switch {
+	case c.OldPosition != nil && c.NewPosition != nil:
+		return strconv.Itoa(*c.OldPosition) + " -> " + strconv.Itoa(*c.NewPosition)
+	case c.OldPosition != nil:
+		return strconv.Itoa(*c.OldPosition)
+	case c.NewPosition != nil:
+		return strconv.Itoa(*c.NewPosition)
+	}
+	return "-"
+}
@@ -0,0 +1,93 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+func newTestTrack(id string) spotify.PlaylistTrack {
+	return spotify.PlaylistTrack{
+		AddedAt: "2022-02-01T12:00:00Z",
+		Track: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{
+				ID:      spotify.ID(id),
+				Name:    "track " + id,
+				Artists: []spotify.SimpleArtist{{Name: "artist " + id}},
+			},
+		},
+	}
+}
+
+func newTestTracks(ids ...string) []spotify.PlaylistTrack {
+	var tracks []spotify.PlaylistTrack
+	for _, id := range ids {
+		tracks = append(tracks, newTestTrack(id))
+	}
+	return tracks
+}
+
+// summary lists the changes as "type id old new" for easy comparison
+func summary(d *Diff) []string {
+	var result []string
+	for _, change := range d.Changes {
+		result = append(result, change.Type+" "+string(change.TrackID)+" "+change.position())
+	}
+	return result
+}
+
+func Test_Compare(t *testing.T) {
+	d := Compare("test playlist", Version{Source: SourceCache}, Version{Source: SourceLive},
+		newTestTracks("a", "b", "c", "d"), newTestTracks("a", "c", "e", "d"))
+	assert.Equal(t, []string{"removed b 1", "added e 2"}, summary(d))
+}
+
+func Test_Compare_Unchanged(t *testing.T) {
+	d := Compare("test playlist", Version{}, Version{}, newTestTracks("a", "b"), newTestTracks("a", "b"))
+	assert.Empty(t, d.Changes)
+}
+
+// Test_Compare_Moved tests that only the fewest tracks explaining the new order are moved
+func Test_Compare_Moved(t *testing.T) {
+	d := Compare("test playlist", Version{}, Version{},
+		newTestTracks("a", "b", "c", "d", "e"), newTestTracks("b", "c", "d", "a", "e"))
+	assert.Equal(t, []string{"moved a 0 -> 3"}, summary(d))
+}
+
+// Test_Compare_Duplicates tests that copies of the same track are paired in order
+func Test_Compare_Duplicates(t *testing.T) {
+	d := Compare("test playlist", Version{}, Version{},
+		newTestTracks("a", "b", "a", "c"), newTestTracks("a", "b", "c"))
+	assert.Equal(t, []string{"removed a 2"}, summary(d))
+}
+
+func Test_WriteText(t *testing.T) {
+	d := Compare("test playlist", Version{Source: SourceCache, SnapshotID: "snapshot1"}, Version{Source: SourceLive},
+		newTestTracks("a", "b"), newTestTracks("b", "c"))
+
+	var buf bytes.Buffer
+	assert.NoError(t, d.Write(&buf, "text"))
+	assert.Contains(t, buf.String(), "test playlist: cache (snapshot1) -> live")
+	assert.Contains(t, buf.String(), "track c")
+	assert.Contains(t, buf.String(), "1 added, 1 removed, 0 moved")
+}
+
+func Test_WriteJSON(t *testing.T) {
+	d := Compare("test playlist", Version{}, Version{}, newTestTracks("a"), newTestTracks("b"))
+
+	var buf bytes.Buffer
+	assert.NoError(t, d.Write(&buf, "json"))
+
+	var result Diff
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &result))
+	assert.Equal(t, d.Changes, result.Changes)
+	assert.Equal(t, "2022-02-01T12:00:00Z", result.Changes[0].AddedAt)
+}
+
+func Test_Write_UnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Error(t, (&Diff{}).Write(&buf, "yaml"))
+}
@@ -0,0 +1,256 @@
+package formats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Columns which can be exported to CSV
+const (
+	ColumnTrack    = "track"
+	ColumnArtists  = "artists"
+	ColumnAlbum    = "album"
+	ColumnISRC     = "isrc"
+	ColumnDuration = "duration"
+	ColumnAddedAt  = "added_at"
+	ColumnAddedBy  = "added_by"
+	ColumnURI      = "uri"
+)
+
+// ColumnPlaylist is the first column of a CSV file holding several playlists
+const ColumnPlaylist = "playlist"
+
+// Columns lists every column which can be exported, in their default order
+var Columns = []string{ColumnTrack, ColumnArtists, ColumnAlbum, ColumnISRC, ColumnDuration, ColumnAddedAt, ColumnAddedBy, ColumnURI}
+
+// ParseColumns parses a comma separated list of columns. An empty list selects
+// every column.
+func ParseColumns(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return Columns, nil
+	}
+
+	var columns []string
+	for _, column := range strings.Split(value, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !isColumn(column) {
+			return nil, fmt.Errorf("unknown column %q, must be one of: %s", column, strings.Join(Columns, ", "))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func isColumn(column string) bool {
+	for _, c := range Columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteCSV writes the tracks of the playlists as CSV with a header row. With
+// withPlaylist set, every row starts with the name of its playlist.
+func WriteCSV(w io.Writer, playlists []*service.CachedPlaylist, columns []string, withPlaylist bool) error {
+	writer := csv.NewWriter(w)
+
+	header := columns
+	if withPlaylist {
+		header = append([]string{ColumnPlaylist}, columns...)
+	}
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, playlist := range playlists {
+		for _, track := range playlist.Tracks {
+			var row []string
+			if withPlaylist {
+				row = append(row, playlist.Name)
+			}
+			for _, column := range columns {
+				row = append(row, columnValue(track, column))
+			}
+			err = writer.Write(row)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// columnValue returns the value of a column for a track
+func columnValue(track spotify.PlaylistTrack, column string) string {
+	switch column {
+	case ColumnTrack:
+		return track.Track.Name
+	case ColumnArtists:
+		return artistNames(track.Track)
+	case ColumnAlbum:
+		return track.Track.Album.Name
+	case ColumnISRC:
+		return track.Track.ExternalIDs["isrc"]
+	case ColumnDuration:
+		return FormatDuration(track.Track.Duration)
+	case ColumnAddedAt:
+		return track.AddedAt
+	case ColumnAddedBy:
+		return track.AddedBy.ID
+	case ColumnURI:
+		return string(track.Track.URI)
+	}
+	return ""
+}
+
+// artistNames returns the names of every artist of a track, comma separated
+func artistNames(track spotify.FullTrack) string {
+	var names []string
+	for _, artist := range track.Artists {
+		names = append(names, artist.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// SplitArtists splits a list of artists separated by commas, semicolons or
+// ampersands, as read from an imported file
+func SplitArtists(artists string) []string {
+	var split []string
+	for _, artist := range strings.FieldsFunc(artists, func(r rune) bool {
+		return r == ',' || r == ';' || r == '&'
+	}) {
+		if artist = strings.TrimSpace(artist); artist != "" {
+			split = append(split, artist)
+		}
+	}
+	return split
+}
+
+// FormatDuration formats a duration in milliseconds as minutes and seconds, ex: 3:05
+func FormatDuration(ms int) string {
+	d := time.Duration(ms) * time.Millisecond
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// ImportRow is a track read from a CSV file, to be matched to a Spotify track
+type ImportRow struct {
+	Line     int // line of the row in the file
+	Title    string
+	Artist   string
+	Album    string
+	ISRC     string
+	Duration int // milliseconds, 0 if unknown
+}
+
+// importColumns maps the accepted names of each CSV import column
+var importColumns = map[string]string{
+	"title":       "title",
+	"track":       "title",
+	"name":        "title",
+	"track name":  "title",
+	"artist":      "artist",
+	"artists":     "artist",
+	"artist name": "artist",
+	"album":       "album",
+	"album name":  "album",
+	"isrc":        "isrc",
+	"duration":    "duration",
+	"duration_ms": "duration",
+	"length":      "duration",
+}
+
+// ReadCSV reads the tracks of a CSV file with a header row naming its title,
+// artist, album, ISRC and duration columns. Only a title column is required, and
+// other columns are ignored. Files written by WriteCSV can be read.
+func ReadCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		if column, ok := importColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			if _, seen := columns[column]; !seen {
+				columns[column] = i
+			}
+		}
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, fmt.Errorf("CSV has no title column, one of: title, track, name")
+	}
+
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := ImportRow{
+			Line:   line,
+			Title:  value("title"),
+			Artist: value("artist"),
+			Album:  value("album"),
+			ISRC:   strings.ToUpper(value("isrc")),
+		}
+		if row.Title == "" && row.ISRC == "" {
+			continue
+		}
+		if duration := value("duration"); duration != "" {
+			row.Duration, err = ParseDuration(duration)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ParseDuration parses a duration given as minutes and seconds (3:05), hours,
+// minutes and seconds (1:03:05) or milliseconds (185000), into milliseconds
+func ParseDuration(value string) (int, error) {
+	if !strings.Contains(value, ":") {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return ms, nil
+	}
+
+	seconds := 0
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds * 1000, nil
+}
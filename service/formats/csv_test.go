@@ -0,0 +1,102 @@
+package formats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+var testTrack = spotify.PlaylistTrack{
+	AddedAt: "2022-02-01T12:00:00Z",
+	AddedBy: spotify.User{ID: "user1"},
+	Track: spotify.FullTrack{
+		SimpleTrack: spotify.SimpleTrack{
+			ID:   "track1",
+			Name: "Song, Part 1",
+			Artists: []spotify.SimpleArtist{
+				{Name: "artist 1"},
+				{Name: "artist 2"},
+			},
+			Duration: 185000,
+			URI:      "spotify:track:track1",
+		},
+		Album:       spotify.SimpleAlbum{Name: "album 1"},
+		ExternalIDs: map[string]string{"isrc": "USABC2200001"},
+	},
+}
+
+var testCachedPlaylist = &service.CachedPlaylist{
+	ID:     "playlist1",
+	Name:   "test playlist",
+	Tracks: []spotify.PlaylistTrack{testTrack},
+}
+
+func Test_WriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteCSV(&buf, []*service.CachedPlaylist{testCachedPlaylist}, Columns, false))
+	assert.Equal(t, "track,artists,album,isrc,duration,added_at,added_by,uri\n"+
+		"\"Song, Part 1\",\"artist 1, artist 2\",album 1,USABC2200001,3:05,2022-02-01T12:00:00Z,user1,spotify:track:track1\n",
+		buf.String())
+}
+
+func Test_WriteCSV_WithPlaylist(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteCSV(&buf, []*service.CachedPlaylist{testCachedPlaylist}, []string{ColumnURI}, true))
+	assert.Equal(t, "playlist,uri\ntest playlist,spotify:track:track1\n", buf.String())
+}
+
+func Test_ParseColumns(t *testing.T) {
+	columns, err := ParseColumns("track, ISRC,uri")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ColumnTrack, ColumnISRC, ColumnURI}, columns)
+
+	columns, err = ParseColumns("")
+	assert.NoError(t, err)
+	assert.Equal(t, Columns, columns)
+
+	_, err = ParseColumns("track,genre")
+	assert.EqualError(t, err, "unknown column \"genre\", must be one of: track, artists, album, isrc, duration, added_at, added_by, uri")
+}
+
+func Test_ReadCSV(t *testing.T) {
+	rows, err := ReadCSV(strings.NewReader("Track Name,Artist,Album,ISRC,Duration,Genre\n" +
+		"\"Song, Part 1\",artist 1,album 1,usabc2200001,3:05,rock\n" +
+		",,,,,\n" +
+		"Other,artist 2,,,200000\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []ImportRow{
+		{Line: 2, Title: "Song, Part 1", Artist: "artist 1", Album: "album 1", ISRC: "USABC2200001", Duration: 185000},
+		{Line: 4, Title: "Other", Artist: "artist 2", Duration: 200000},
+	}, rows)
+}
+
+// Test_ReadCSV_Export tests that an exported playlist can be read back
+func Test_ReadCSV_Export(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteCSV(&buf, []*service.CachedPlaylist{testCachedPlaylist}, Columns, false))
+
+	rows, err := ReadCSV(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []ImportRow{{Line: 2, Title: "Song, Part 1", Artist: "artist 1, artist 2", Album: "album 1",
+		ISRC: "USABC2200001", Duration: 185000}}, rows)
+}
+
+func Test_ReadCSV_NoTitle(t *testing.T) {
+	_, err := ReadCSV(strings.NewReader("artist,album\nartist 1,album 1\n"))
+	assert.EqualError(t, err, "CSV has no title column, one of: title, track, name")
+}
+
+func Test_ParseDuration(t *testing.T) {
+	for value, expected := range map[string]int{"3:05": 185000, "1:03:05": 3785000, "185000": 185000} {
+		result, err := ParseDuration(value)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, result, value)
+	}
+
+	_, err := ParseDuration("3m5s")
+	assert.EqualError(t, err, "invalid duration \"3m5s\"")
+}
@@ -0,0 +1,148 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/musicbrainz"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Export formats
+const (
+	FormatCSV  = "csv"
+	FormatM3U8 = "m3u8"
+	FormatXSPF = "xspf"
+	FormatJSPF = "jspf"
+)
+
+// ExportOptions controls the contents of exported playlists
+type ExportOptions struct {
+	Columns      []string           // CSV columns
+	PathTemplate *template.Template // local file path of each track, Spotify URLs if nil
+
+	// MusicBrainz looks up the recording of each track's ISRC for JSPF, nil to skip.
+	// The recordings found are kept in Recordings, by ISRC.
+	MusicBrainz musicbrainz.Lookup
+	Recordings  map[string]*musicbrainz.Recording
+}
+
+// PathFields are the fields of a track available to a path template, with any
+// slashes replaced so they can be used as path segments
+type PathFields struct {
+	ID          string
+	Title       string
+	Artist      string // primary artist
+	Artists     string // every artist, comma separated
+	Album       string
+	AlbumArtist string
+	TrackNumber int
+	DiscNumber  int
+	ISRC        string
+}
+
+// ParsePathTemplate parses a template for the local file paths of tracks, ex:
+// /music/{{.AlbumArtist}}/{{.Album}}/{{printf "%02d" .TrackNumber}} {{.Title}}.mp3
+func ParsePathTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("path").Option("missingkey=error").Parse(text)
+}
+
+// WritePlaylist writes a playlist in the given format
+func WritePlaylist(w io.Writer, format string, playlist *service.CachedPlaylist, options ExportOptions) error {
+	switch format {
+	case FormatCSV, "":
+		return WriteCSV(w, []*service.CachedPlaylist{playlist}, options.Columns, false)
+	case FormatM3U8:
+		return WriteM3U8(w, playlist, options)
+	case FormatXSPF:
+		return WriteXSPF(w, playlist, options)
+	case FormatJSPF:
+		return WriteJSPF(w, playlist, options)
+	default:
+		return fmt.Errorf("unknown export format: %s", format)
+	}
+}
+
+// location returns where a track can be found: its local file path from the path
+// template, or else its Spotify URL
+func (o ExportOptions) location(track spotify.PlaylistTrack) (string, error) {
+	if o.PathTemplate != nil {
+		var buf bytes.Buffer
+		err := o.PathTemplate.Execute(&buf, newPathFields(track.Track))
+		if err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	if track.IsLocal || track.Track.ID == "" {
+		return string(track.Track.URI), nil
+	}
+	return "https://open.spotify.com/track/" + string(track.Track.ID), nil
+}
+
+// locationURI returns the location of a track as a URI, as XSPF and JSPF require: a
+// local file path is written as a percent-encoded file URL, or a relative reference if
+// the path is relative
+func (o ExportOptions) locationURI(track spotify.PlaylistTrack) (string, error) {
+	location, err := o.location(track)
+	if err != nil || o.PathTemplate == nil {
+		return location, err
+	}
+
+	path := filepath.ToSlash(location)
+	if !filepath.IsAbs(location) {
+		return (&url.URL{Path: path}).String(), nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		// a Windows path with a drive letter
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String(), nil
+}
+
+// pathReplacer makes field values safe for use as path segments
+var pathReplacer = strings.NewReplacer("/", "-", "\\", "-")
+
+func newPathFields(track spotify.FullTrack) PathFields {
+	fields := PathFields{
+		ID:          string(track.ID),
+		Title:       pathReplacer.Replace(track.Name),
+		Artists:     pathReplacer.Replace(artistNames(track)),
+		Album:       pathReplacer.Replace(track.Album.Name),
+		TrackNumber: track.TrackNumber,
+		DiscNumber:  track.DiscNumber,
+		ISRC:        track.ExternalIDs["isrc"],
+	}
+	fields.Artist = pathReplacer.Replace(service.PrimaryArtist(track))
+	fields.AlbumArtist = fields.Artist
+	if len(track.Album.Artists) > 0 {
+		fields.AlbumArtist = pathReplacer.Replace(track.Album.Artists[0].Name)
+	}
+	return fields
+}
+
+// WriteFile creates a file and writes its contents with write
+func WriteFile(fileName string, write func(w io.Writer) error) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	err = write(file)
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to write %s: %w", fileName, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,81 @@
+package formats
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+func Test_WriteM3U8(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WritePlaylist(&buf, FormatM3U8, testCachedPlaylist, ExportOptions{}))
+	assert.Equal(t, "#EXTM3U\n#PLAYLIST:test playlist\n"+
+		"#EXTINF:185,artist 1 - Song, Part 1\nhttps://open.spotify.com/track/track1\n", buf.String())
+}
+
+// Test_WriteM3U8_PathTemplate tests that tracks are resolved to local file paths
+func Test_WriteM3U8_PathTemplate(t *testing.T) {
+	pathTemplate, err := ParsePathTemplate(`/music/{{.AlbumArtist}}/{{.Album}}/{{printf "%02d" .TrackNumber}} {{.Title}}.mp3`)
+	assert.NoError(t, err)
+
+	track := testTrack
+	track.Track.TrackNumber = 3
+	track.Track.Album.Name = "album 1/2"
+	playlist := &service.CachedPlaylist{Name: "test playlist", Tracks: []spotify.PlaylistTrack{track}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteM3U8(&buf, playlist, ExportOptions{PathTemplate: pathTemplate}))
+	assert.Contains(t, buf.String(), "\n/music/artist 1/album 1-2/03 Song, Part 1.mp3\n")
+}
+
+// Test_WriteXSPF_PathTemplate tests that local file paths are written as file URLs
+func Test_WriteXSPF_PathTemplate(t *testing.T) {
+	pathTemplate, err := ParsePathTemplate(`/music/{{.Artist}}/{{.Title}} #1.mp3`)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteXSPF(&buf, testCachedPlaylist, ExportOptions{PathTemplate: pathTemplate}))
+	assert.Contains(t, buf.String(), "<location>file:///music/artist%201/Song,%20Part%201%20%231.mp3</location>")
+
+	pathTemplate, _ = ParsePathTemplate(`{{.Artist}}/{{.Title}}.mp3`)
+	buf.Reset()
+	assert.NoError(t, WriteXSPF(&buf, testCachedPlaylist, ExportOptions{PathTemplate: pathTemplate}))
+	assert.Contains(t, buf.String(), "<location>artist%201/Song,%20Part%201.mp3</location>")
+}
+
+func Test_ParsePathTemplate_UnknownField(t *testing.T) {
+	pathTemplate, err := ParsePathTemplate("{{.Genre}}")
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.Error(t, WriteM3U8(&buf, testCachedPlaylist, ExportOptions{PathTemplate: pathTemplate}))
+}
+
+func Test_WriteXSPF(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WritePlaylist(&buf, FormatXSPF, testCachedPlaylist, ExportOptions{}))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <title>test playlist</title>
+  <identifier>spotify:playlist:playlist1</identifier>
+  <trackList>
+    <track>
+      <location>https://open.spotify.com/track/track1</location>
+      <identifier>spotify:track:track1</identifier>
+      <title>Song, Part 1</title>
+      <creator>artist 1</creator>
+      <album>album 1</album>
+      <duration>185000</duration>
+    </track>
+  </trackList>
+</playlist>
+`, buf.String())
+}
+
+func Test_WritePlaylist_UnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Error(t, WritePlaylist(&buf, "pls", testCachedPlaylist, ExportOptions{}))
+}
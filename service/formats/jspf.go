@@ -0,0 +1,201 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/zmb3/spotify/v2"
+)
+
+// MusicBrainz JSPF extension keys, as used by ListenBrainz
+const (
+	jspfPlaylistExtensionKey = "https://musicbrainz.org/doc/jspf#playlist"
+	jspfTrackExtensionKey    = "https://musicbrainz.org/doc/jspf#track"
+)
+
+// Prefixes of the identifiers of MusicBrainz recordings and artists, and of the
+// identifiers and locations of Spotify tracks and playlists
+const (
+	musicBrainzRecordingURL = "https://musicbrainz.org/recording/"
+	musicBrainzArtistURL    = "https://musicbrainz.org/artist/"
+
+	spotifyTrackURL    = "https://open.spotify.com/track/"
+	spotifyTrackURI    = "spotify:track:"
+	spotifyPlaylistURL = "https://open.spotify.com/playlist/"
+)
+
+type jspfFile struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title      string                           `json:"title"`
+	Creator    string                           `json:"creator,omitempty"`
+	Identifier string                           `json:"identifier,omitempty"`
+	Date       string                           `json:"date,omitempty"`
+	Extension  map[string]jspfPlaylistExtension `json:"extension,omitempty"`
+	Tracks     []jspfTrack                      `json:"track"`
+}
+
+type jspfPlaylistExtension struct {
+	Creator string `json:"creator,omitempty"`
+}
+
+type jspfTrack struct {
+	Title      string                        `json:"title"`
+	Creator    string                        `json:"creator,omitempty"`
+	Album      string                        `json:"album,omitempty"`
+	Duration   int                           `json:"duration,omitempty"` // milliseconds
+	Identifier []string                      `json:"identifier,omitempty"`
+	Location   []string                      `json:"location,omitempty"`
+	Extension  map[string]jspfTrackExtension `json:"extension,omitempty"`
+}
+
+type jspfTrackExtension struct {
+	AddedAt            string                 `json:"added_at,omitempty"`
+	AddedBy            string                 `json:"added_by,omitempty"`
+	ArtistIdentifiers  []string               `json:"artist_identifiers,omitempty"`
+	AdditionalMetadata map[string]interface{} `json:"additional_metadata,omitempty"`
+}
+
+// WriteJSPF writes a playlist as JSPF with the MusicBrainz extension fields. Tracks
+// whose ISRC has a recording in options.Recordings are identified by the MusicBrainz
+// recording, as well as by Spotify, and credit its MusicBrainz artists. Each track's
+// ISRC and Spotify URI also go in its additional metadata.
+func WriteJSPF(w io.Writer, playlist *service.CachedPlaylist, options ExportOptions) error {
+	p := jspfPlaylist{
+		Title:   playlist.Name,
+		Creator: playlist.OwnerID,
+		Extension: map[string]jspfPlaylistExtension{
+			jspfPlaylistExtensionKey: {Creator: playlist.OwnerID},
+		},
+		Tracks: []jspfTrack{},
+	}
+	if playlist.ID != "" {
+		p.Identifier = spotifyPlaylistURL + string(playlist.ID)
+	}
+	if !playlist.UpdatedAt.IsZero() {
+		p.Date = playlist.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+
+	for _, track := range playlist.Tracks {
+		jt := jspfTrack{
+			Title:    track.Track.Name,
+			Creator:  artistNames(track.Track),
+			Album:    track.Track.Album.Name,
+			Duration: track.Track.Duration,
+		}
+		isrc := track.Track.ExternalIDs["isrc"]
+		recording := options.Recordings[isrc]
+		if recording != nil {
+			jt.Identifier = append(jt.Identifier, musicBrainzRecordingURL+recording.ID)
+		}
+		if track.Track.ID != "" {
+			jt.Identifier = append(jt.Identifier, spotifyTrackURL+string(track.Track.ID))
+		}
+		if options.PathTemplate != nil {
+			location, err := options.locationURI(track)
+			if err != nil {
+				return err
+			}
+			jt.Location = []string{location}
+		}
+
+		extension := jspfTrackExtension{
+			AddedAt: track.AddedAt,
+			AddedBy: track.AddedBy.ID,
+			AdditionalMetadata: map[string]interface{}{
+				"spotify_uri": string(track.Track.URI),
+			},
+		}
+		if recording != nil {
+			for _, artistID := range recording.ArtistIDs {
+				extension.ArtistIdentifiers = append(extension.ArtistIdentifiers, musicBrainzArtistURL+artistID)
+			}
+		}
+		if isrc != "" {
+			extension.AdditionalMetadata["isrc"] = isrc
+		}
+		jt.Extension = map[string]jspfTrackExtension{jspfTrackExtensionKey: extension}
+
+		p.Tracks = append(p.Tracks, jt)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(jspfFile{Playlist: p})
+}
+
+// ReadJSPF reads a JSPF playlist into the cache model. Tracks are identified by a
+// Spotify identifier or location, where there is one, and keep their ISRC from the
+// MusicBrainz extension. The creator of a track is split into its artists. The
+// playlist keeps its Spotify ID if it has one.
+func ReadJSPF(r io.Reader) (*service.CachedPlaylist, error) {
+	var file jspfFile
+	err := json.NewDecoder(r).Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JSPF: %w", err)
+	}
+	if file.Playlist.Title == "" {
+		return nil, fmt.Errorf("unable to read JSPF: playlist has no title")
+	}
+
+	playlist := &service.CachedPlaylist{
+		Name:      file.Playlist.Title,
+		OwnerID:   file.Playlist.Creator,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if strings.HasPrefix(file.Playlist.Identifier, spotifyPlaylistURL) {
+		playlist.ID = spotify.ID(strings.TrimPrefix(file.Playlist.Identifier, spotifyPlaylistURL))
+	}
+
+	for _, jt := range file.Playlist.Tracks {
+		track := spotify.PlaylistTrack{
+			Track: spotify.FullTrack{
+				SimpleTrack: spotify.SimpleTrack{
+					ID:       spotifyTrackID(append(jt.Identifier, jt.Location...)),
+					Name:     jt.Title,
+					Duration: jt.Duration,
+				},
+				Album: spotify.SimpleAlbum{Name: jt.Album},
+			},
+		}
+		if track.Track.ID != "" {
+			track.Track.URI = spotify.URI(spotifyTrackURI + string(track.Track.ID))
+		}
+		for _, artist := range SplitArtists(jt.Creator) {
+			track.Track.Artists = append(track.Track.Artists, spotify.SimpleArtist{Name: artist})
+		}
+
+		extension := jt.Extension[jspfTrackExtensionKey]
+		track.AddedAt = extension.AddedAt
+		track.AddedBy.ID = extension.AddedBy
+		if isrc, ok := extension.AdditionalMetadata["isrc"].(string); ok && isrc != "" {
+			track.Track.ExternalIDs = map[string]string{"isrc": isrc}
+		}
+
+		playlist.Tracks = append(playlist.Tracks, track)
+	}
+	return playlist, nil
+}
+
+// spotifyTrackID returns the Spotify track ID of the first identifier or location
+// which is a Spotify track URL or URI
+func spotifyTrackID(identifiers []string) spotify.ID {
+	for _, identifier := range identifiers {
+		for _, prefix := range []string{spotifyTrackURL, spotifyTrackURI} {
+			if strings.HasPrefix(identifier, prefix) {
+				id := strings.TrimPrefix(identifier, prefix)
+				if i := strings.IndexAny(id, "?#"); i >= 0 {
+					id = id[:i]
+				}
+				return spotify.ID(id)
+			}
+		}
+	}
+	return ""
+}
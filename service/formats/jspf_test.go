@@ -0,0 +1,97 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/reeves122/spotify-automation-go/service/musicbrainz"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+func Test_WriteJSPF(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WritePlaylist(&buf, FormatJSPF, testCachedPlaylist, ExportOptions{}))
+
+	var result map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &result))
+	playlist := result["playlist"].(map[string]interface{})
+	assert.Equal(t, "test playlist", playlist["title"])
+	assert.Equal(t, "https://open.spotify.com/playlist/playlist1", playlist["identifier"])
+	assert.NotContains(t, playlist["extension"].(map[string]interface{})["https://musicbrainz.org/doc/jspf#playlist"], "public")
+
+	track := playlist["track"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "Song, Part 1", track["title"])
+	assert.Equal(t, "artist 1, artist 2", track["creator"])
+	assert.Equal(t, []interface{}{"https://open.spotify.com/track/track1"}, track["identifier"])
+
+	extension := track["extension"].(map[string]interface{})["https://musicbrainz.org/doc/jspf#track"].(map[string]interface{})
+	assert.Equal(t, "2022-02-01T12:00:00Z", extension["added_at"])
+	assert.NotContains(t, extension, "artist_identifiers")
+	assert.Equal(t, map[string]interface{}{"isrc": "USABC2200001", "spotify_uri": "spotify:track:track1"},
+		extension["additional_metadata"])
+}
+
+// Test_WriteJSPF_MusicBrainz tests identifying tracks by the MusicBrainz recording of their ISRC
+func Test_WriteJSPF_MusicBrainz(t *testing.T) {
+	var buf bytes.Buffer
+	options := ExportOptions{Recordings: map[string]*musicbrainz.Recording{
+		"USABC2200001": {ID: "rec1", ArtistIDs: []string{"artist1", "artist2"}},
+	}}
+	assert.NoError(t, WriteJSPF(&buf, testCachedPlaylist, options))
+
+	var result map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &result))
+	track := result["playlist"].(map[string]interface{})["track"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, []interface{}{"https://musicbrainz.org/recording/rec1", "https://open.spotify.com/track/track1"},
+		track["identifier"])
+	extension := track["extension"].(map[string]interface{})["https://musicbrainz.org/doc/jspf#track"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"https://musicbrainz.org/artist/artist1", "https://musicbrainz.org/artist/artist2"},
+		extension["artist_identifiers"])
+
+	// the Spotify track is still found when reading it back
+	playlist, err := ReadJSPF(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, spotify.ID("track1"), playlist.Tracks[0].Track.ID)
+}
+
+// Test_ReadJSPF tests reading back an exported playlist
+func Test_ReadJSPF(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteJSPF(&buf, testCachedPlaylist, ExportOptions{}))
+
+	result, err := ReadJSPF(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, spotify.ID("playlist1"), result.ID)
+	assert.Equal(t, "test playlist", result.Name)
+	assert.Len(t, result.Tracks, 1)
+
+	track := result.Tracks[0]
+	assert.Equal(t, spotify.ID("track1"), track.Track.ID)
+	assert.Equal(t, spotify.URI("spotify:track:track1"), track.Track.URI)
+	assert.Equal(t, "Song, Part 1", track.Track.Name)
+	assert.Equal(t, "USABC2200001", track.Track.ExternalIDs["isrc"])
+	assert.Equal(t, 185000, track.Track.Duration)
+	assert.Equal(t, "2022-02-01T12:00:00Z", track.AddedAt)
+	assert.Equal(t, "user1", track.AddedBy.ID)
+}
+
+// Test_ReadJSPF_MusicBrainz tests reading a playlist without Spotify identifiers
+func Test_ReadJSPF_MusicBrainz(t *testing.T) {
+	result, err := ReadJSPF(strings.NewReader(`{"playlist": {"title": "mb playlist", "track": [
+		{"title": "Song", "creator": "Artist & Guest, Other", "identifier": ["https://musicbrainz.org/recording/1234"]},
+		{"title": "Other", "location": ["https://open.spotify.com/track/track2?si=abc"]}
+	]}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, spotify.ID(""), result.ID)
+	assert.Equal(t, spotify.ID(""), result.Tracks[0].Track.ID)
+	assert.Equal(t, []spotify.SimpleArtist{{Name: "Artist"}, {Name: "Guest"}, {Name: "Other"}}, result.Tracks[0].Track.Artists)
+	assert.Equal(t, spotify.ID("track2"), result.Tracks[1].Track.ID)
+}
+
+func Test_ReadJSPF_Invalid(t *testing.T) {
+	_, err := ReadJSPF(strings.NewReader(`{"playlist": {"track": []}}`))
+	assert.EqualError(t, err, "unable to read JSPF: playlist has no title")
+}
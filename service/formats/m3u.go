@@ -0,0 +1,32 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/reeves122/spotify-automation-go/service"
+)
+
+// WriteM3U8 writes a playlist as extended M3U in UTF-8, with the duration in
+// seconds and the artist and title of every track
+func WriteM3U8(w io.Writer, playlist *service.CachedPlaylist, options ExportOptions) error {
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintln(bw, "#EXTM3U")
+	_, _ = fmt.Fprintf(bw, "#PLAYLIST:%s\n", playlist.Name)
+
+	for _, track := range playlist.Tracks {
+		location, err := options.location(track)
+		if err != nil {
+			return err
+		}
+
+		title := track.Track.Name
+		if artist := service.PrimaryArtist(track.Track); artist != "" {
+			title = artist + " - " + title
+		}
+		_, _ = fmt.Fprintf(bw, "#EXTINF:%d,%s\n", track.Track.Duration/1000, title)
+		_, _ = fmt.Fprintln(bw, location)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,71 @@
+package formats
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/reeves122/spotify-automation-go/service"
+)
+
+// xspfNamespace is the XML namespace of XSPF version 1
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+type xspfPlaylist struct {
+	XMLName    xml.Name    `xml:"playlist"`
+	Version    string      `xml:"version,attr"`
+	Namespace  string      `xml:"xmlns,attr"`
+	Title      string      `xml:"title"`
+	Creator    string      `xml:"creator,omitempty"`
+	Identifier string      `xml:"identifier,omitempty"`
+	Tracks     []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   string `xml:"location"`
+	Identifier string `xml:"identifier,omitempty"`
+	Title      string `xml:"title"`
+	Creator    string `xml:"creator,omitempty"`
+	Album      string `xml:"album,omitempty"`
+	Duration   int    `xml:"duration,omitempty"` // milliseconds
+}
+
+// WriteXSPF writes a playlist as XSPF, identifying every track by its Spotify URI
+func WriteXSPF(w io.Writer, playlist *service.CachedPlaylist, options ExportOptions) error {
+	p := xspfPlaylist{
+		Version:   "1",
+		Namespace: xspfNamespace,
+		Title:     playlist.Name,
+		Creator:   playlist.OwnerID,
+	}
+	if playlist.ID != "" {
+		p.Identifier = "spotify:playlist:" + string(playlist.ID)
+	}
+
+	for _, track := range playlist.Tracks {
+		location, err := options.locationURI(track)
+		if err != nil {
+			return err
+		}
+		p.Tracks = append(p.Tracks, xspfTrack{
+			Location:   location,
+			Identifier: string(track.Track.URI),
+			Title:      track.Track.Name,
+			Creator:    service.PrimaryArtist(track.Track),
+			Album:      track.Track.Album.Name,
+			Duration:   track.Track.Duration,
+		})
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(p)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,120 @@
+package musicbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// DefaultURL is the base URL of the MusicBrainz web service
+const DefaultURL = "https://musicbrainz.org/ws/2"
+
+// userAgent identifies the app to MusicBrainz, which requires a meaningful user agent
+const userAgent = "spotify-automation-go ( https://github.com/reeves122/spotify-automation-go )"
+
+// requestInterval keeps requests within the MusicBrainz limit of one per second
+const requestInterval = time.Second
+
+// Recording is a MusicBrainz recording and the artists credited on it
+type Recording struct {
+	ID        string
+	ArtistIDs []string
+}
+
+// Lookup finds the MusicBrainz recording of an ISRC
+type Lookup interface {
+	LookupISRC(ctx context.Context, isrc string) (*Recording, error)
+}
+
+type client struct {
+	baseURL string
+	http    *http.Client
+	limiter *rate.Limiter
+
+	mu    sync.Mutex
+	cache map[string]*Recording // recordings by ISRC, nil if there is none
+}
+
+// NewClient creates a client of the MusicBrainz web service at the given base URL, or
+// DefaultURL if empty. Lookups are cached, and sent at most once per second.
+func NewClient(baseURL string) *client {
+	if baseURL == "" {
+		baseURL = DefaultURL
+	}
+	return &client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+		limiter: rate.NewLimiter(rate.Every(requestInterval), 1),
+		cache:   map[string]*Recording{},
+	}
+}
+
+type isrcResponse struct {
+	Recordings []struct {
+		ID           string `json:"id"`
+		ArtistCredit []struct {
+			Artist struct {
+				ID string `json:"id"`
+			} `json:"artist"`
+		} `json:"artist-credit"`
+	} `json:"recordings"`
+}
+
+// LookupISRC returns the first recording MusicBrainz has for an ISRC, or nil if it
+// has none
+func (c *client) LookupISRC(ctx context.Context, isrc string) (*Recording, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if recording, found := c.cache[isrc]; found {
+		return recording, nil
+	}
+
+	err := c.limiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.baseURL+"/isrc/"+url.PathEscape(isrc)+"?inc=artist-credits&fmt=json", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	log.Debugf("Looking up ISRC %s in MusicBrainz", isrc)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var recording *Recording
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var body isrcResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read MusicBrainz response for ISRC %s: %w", isrc, err)
+		}
+		if len(body.Recordings) > 0 {
+			recording = &Recording{ID: body.Recordings[0].ID}
+			for _, credit := range body.Recordings[0].ArtistCredit {
+				recording.ArtistIDs = append(recording.ArtistIDs, credit.Artist.ID)
+			}
+		}
+	case http.StatusNotFound:
+	default:
+		return nil, fmt.Errorf("MusicBrainz responded %s to the lookup of ISRC %s", resp.Status, isrc)
+	}
+
+	c.cache[isrc] = recording
+	return recording, nil
+}
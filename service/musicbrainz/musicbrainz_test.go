@@ -0,0 +1,70 @@
+package musicbrainz
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+// newTestClient creates a client of a fake MusicBrainz which knows one ISRC, and
+// counts the requests made to it
+func newTestClient(t *testing.T, status int) (*client, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, userAgent, r.Header.Get("User-Agent"))
+		assert.Equal(t, "artist-credits", r.URL.Query().Get("inc"))
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		if r.URL.Path != "/ws/2/isrc/USABC2200001" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"isrc": "USABC2200001", "recordings": [
+			{"id": "rec1", "title": "Song", "artist-credit": [{"name": "Artist", "artist": {"id": "artist1"}},
+				{"name": "Guest", "artist": {"id": "artist2"}}]},
+			{"id": "rec2", "title": "Song (Live)", "artist-credit": []}]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	c := NewClient(server.URL + "/ws/2/")
+	c.limiter = rate.NewLimiter(rate.Inf, 1)
+	return c, &requests
+}
+
+func Test_LookupISRC(t *testing.T) {
+	c, requests := newTestClient(t, http.StatusOK)
+
+	recording, err := c.LookupISRC(context.Background(), "USABC2200001")
+	assert.NoError(t, err)
+	assert.Equal(t, &Recording{ID: "rec1", ArtistIDs: []string{"artist1", "artist2"}}, recording)
+
+	// lookups are cached
+	_, _ = c.LookupISRC(context.Background(), "USABC2200001")
+	assert.Equal(t, 1, *requests)
+}
+
+func Test_LookupISRC_NotFound(t *testing.T) {
+	c, _ := newTestClient(t, http.StatusOK)
+
+	recording, err := c.LookupISRC(context.Background(), "USABC2200002")
+	assert.NoError(t, err)
+	assert.Nil(t, recording)
+}
+
+func Test_LookupISRC_ServerError(t *testing.T) {
+	c, requests := newTestClient(t, http.StatusServiceUnavailable)
+
+	_, err := c.LookupISRC(context.Background(), "USABC2200001")
+	assert.ErrorContains(t, err, "503")
+
+	// failures are not cached
+	_, _ = c.LookupISRC(context.Background(), "USABC2200001")
+	assert.Equal(t, 2, *requests)
+}
@@ -0,0 +1,133 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Action types
+const (
+	ActionRemove         = "remove"
+	ActionCreatePlaylist = "create_playlist"
+	ActionAdd            = "add"
+	ActionReplace        = "replace"
+)
+
+// Rules which can cause an action to be planned
+const (
+	RuleDisliked  = "disliked"
+	RuleQueue     = "queue"
+	RuleDuplicate = "duplicate"
+	RuleRestore   = "restore"
+	RuleImport    = "import"
+)
+
+// Action is a single intended change to a playlist. A remove action without a
+// position removes every occurrence of the track, with a position it only removes
+// the track at that position of the playlist snapshot. Add actions append tracks
+// in the order of their positions, while the replace actions of a playlist together
+// make up its new contents. A playlist created by a create_playlist action has no
+// ID yet, so the other actions for it only have its name.
+type Action struct {
+	Type         string     `json:"type"`
+	PlaylistID   spotify.ID `json:"playlist_id"`
+	PlaylistName string     `json:"playlist_name"`
+	TrackID      spotify.ID `json:"track_id"`
+	TrackName    string     `json:"track_name"`
+	Artist       string     `json:"artist"`
+	Position     *int       `json:"position,omitempty"`
+	SnapshotID   string     `json:"snapshot_id,omitempty"`
+	Rule         string     `json:"rule"`
+	Reason       string     `json:"reason"`
+}
+
+// Plan is the list of every change a run intends to make
+type Plan struct {
+	CreatedAt time.Time `json:"created_at"`
+	Actions   []Action  `json:"actions"`
+}
+
+func NewPlan() *Plan {
+	return &Plan{
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Add appends an action to the plan
+func (p *Plan) Add(action Action) {
+	p.Actions = append(p.Actions, action)
+}
+
+// WriteText writes the plan as a human readable table
+func (p *Plan) WriteText(w io.Writer) error {
+	if len(p.Actions) == 0 {
+		_, err := fmt.Fprintln(w, "No changes planned")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ACTION\tPLAYLIST\tPOSITION\tTRACK\tARTIST\tRULE\tREASON")
+	for _, action := range p.Actions {
+		position := "all"
+		if action.Type == ActionCreatePlaylist {
+			position = "-"
+		}
+		if action.Position != nil {
+			position = strconv.Itoa(*action.Position)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", action.Type, action.PlaylistName,
+			position, action.TrackName, action.Artist, action.Rule, action.Reason)
+	}
+	_, _ = fmt.Fprintf(tw, "\n%d changes planned\n", len(p.Actions))
+	return tw.Flush()
+}
+
+// WriteJSON writes the plan as indented JSON
+func (p *Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(p)
+}
+
+// Write writes the plan in the given format, either "text" or "json"
+func (p *Plan) Write(w io.Writer, format string) error {
+	switch format {
+	case "text", "":
+		return p.WriteText(w)
+	case "json":
+		return p.WriteJSON(w)
+	default:
+		return fmt.Errorf("unknown plan format: %s", format)
+	}
+}
+
+// Save saves the plan to a JSON file
+func (p *Plan) Save(fileName string) error {
+	jsonData, _ := json.MarshalIndent(p, "", " ")
+	log.Debugf("Saving plan with %d actions to file: %s", len(p.Actions), fileName)
+	return os.WriteFile(fileName, jsonData, 0644)
+}
+
+// Load loads a plan previously written with Save
+func Load(fileName string) (*Plan, error) {
+	log.Infof("Loading plan from file: %s", fileName)
+	bytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Plan
+	err = json.Unmarshal(bytes, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
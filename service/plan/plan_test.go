@@ -0,0 +1,56 @@
+package plan
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testAction = Action{
+	Type:         ActionRemove,
+	PlaylistID:   "playlist1",
+	PlaylistName: "test playlist",
+	TrackID:      "track1",
+	TrackName:    "track 1",
+	Artist:       "artist 1",
+	Rule:         RuleDisliked,
+	Reason:       "track is in a disliked playlist",
+}
+
+func Test_SaveAndLoad(t *testing.T) {
+	fileName := filepath.Join(os.TempDir(), "test_plan.json")
+	defer func() { _ = os.Remove(fileName) }()
+
+	p := NewPlan()
+	p.Add(testAction)
+	assert.NoError(t, p.Save(fileName))
+
+	result, err := Load(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, p.Actions, result.Actions)
+	assert.True(t, p.CreatedAt.Equal(result.CreatedAt))
+}
+
+func Test_WriteText(t *testing.T) {
+	p := NewPlan()
+	p.Add(testAction)
+
+	var buf bytes.Buffer
+	assert.NoError(t, p.Write(&buf, "text"))
+	assert.Contains(t, buf.String(), "test playlist")
+	assert.Contains(t, buf.String(), "1 changes planned")
+}
+
+func Test_WriteText_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, NewPlan().WriteText(&buf))
+	assert.Equal(t, "No changes planned\n", buf.String())
+}
+
+func Test_Write_UnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Error(t, NewPlan().Write(&buf, "yaml"))
+}
@@ -1,13 +1,110 @@
 package service
 
 import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"time"
+
 	"github.com/zmb3/spotify/v2"
 	"golang.org/x/oauth2"
 )
 
+// StorageInterface caches playlists, keyed by the key returned by PlaylistKey, and
+// the auth token
 type StorageInterface interface {
-	LoadToken(fileName string) (*oauth2.Token, error)
-	SaveToken(token *oauth2.Token, fileName string) error
-	LoadTracksFile(playlistName string) ([]spotify.PlaylistTrack, error)
-	SaveTracksFile(playlistName string, tracks []spotify.PlaylistTrack) error
+	LoadToken(ctx context.Context, fileName string) (*oauth2.Token, error)
+	SaveToken(ctx context.Context, token *oauth2.Token, fileName string) error
+	LoadCodeVerifier(ctx context.Context, tokenFile string) (string, error)
+	SaveCodeVerifier(ctx context.Context, verifier string, tokenFile string) error
+	LoadTracksFile(ctx context.Context, playlistID spotify.ID) ([]spotify.PlaylistTrack, error)
+	SaveTracksFile(ctx context.Context, playlistID spotify.ID, tracks []spotify.PlaylistTrack) error
+	LoadPlaylistFile(ctx context.Context, key string) (*CachedPlaylist, error)
+	SavePlaylistFile(ctx context.Context, playlist *CachedPlaylist) error
+	FindPlaylistFiles(ctx context.Context, name string) ([]*CachedPlaylist, error)
+	MigrateLegacyPlaylist(ctx context.Context, id spotify.ID, name string) error
+	ListPlaylistVersions(ctx context.Context, key string) ([]PlaylistVersion, error)
+	LoadPlaylistVersion(ctx context.Context, key string, at time.Time) (*CachedPlaylist, error)
+}
+
+// PlaylistVersion describes a dated version kept of a cached playlist
+type PlaylistVersion struct {
+	UpdatedAt  time.Time `json:"updated_at"`
+	SnapshotID string    `json:"snapshot_id"`
+	Tracks     int       `json:"tracks"`
+}
+
+// CachedPlaylist is the cached copy of a playlist, along with the metadata
+// needed to tell whether it is still current
+type CachedPlaylist struct {
+	ID         spotify.ID              `json:"id"`
+	Name       string                  `json:"name"`
+	OwnerID    string                  `json:"owner_id"`
+	SnapshotID string                  `json:"snapshot_id"`
+	UpdatedAt  time.Time               `json:"updated_at"`
+	Renames    []PlaylistRename        `json:"renames,omitempty"`
+	Tracks     []spotify.PlaylistTrack `json:"tracks"`
+}
+
+// PlaylistRename records a change to the name of a cached playlist
+type PlaylistRename struct {
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	DetectedAt time.Time `json:"detected_at"` // time the new name was first cached
+}
+
+// Key returns the key the playlist is cached under
+func (p *CachedPlaylist) Key() string {
+	return PlaylistKey(p.ID, p.Name)
+}
+
+// RecordRename carries over the renames of the previously cached copy of the playlist,
+// and records a new one if its name has changed since. Returns whether it was renamed.
+func (p *CachedPlaylist) RecordRename(previous *CachedPlaylist) bool {
+	if previous == nil {
+		return false
+	}
+	p.Renames = previous.Renames
+	if previous.Name == p.Name {
+		return false
+	}
+	p.Renames = append(p.Renames, PlaylistRename{From: previous.Name, To: p.Name, DetectedAt: p.UpdatedAt})
+	return true
+}
+
+// PlaylistKey returns the key a playlist is cached under, which is its ID. Playlists
+// without an ID, such as imported ones, are keyed by an encoding of their name.
+func PlaylistKey(id spotify.ID, name string) string {
+	if id != "" {
+		return string(id)
+	}
+	return "name-" + base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+// SafeFileName returns the name of a playlist made safe for use in file names
+func SafeFileName(playlistName string) string {
+	playlistName = strings.ReplaceAll(playlistName, "/", "-")
+	playlistName = strings.ReplaceAll(playlistName, "\\", "-")
+	playlistName = strings.ReplaceAll(playlistName, ".", "-")
+	return playlistName
+}
+
+// NewCachedPlaylist creates the cached copy of a playlist with the given tracks
+func NewCachedPlaylist(playlist spotify.SimplePlaylist, tracks []spotify.PlaylistTrack) *CachedPlaylist {
+	return &CachedPlaylist{
+		ID:         playlist.ID,
+		Name:       playlist.Name,
+		OwnerID:    playlist.Owner.ID,
+		SnapshotID: playlist.SnapshotID,
+		UpdatedAt:  time.Now().UTC(),
+		Tracks:     tracks,
+	}
+}
+
+// PrimaryArtist returns the name of the first artist of a track
+func PrimaryArtist(track spotify.FullTrack) string {
+	if len(track.Artists) == 0 {
+		return ""
+	}
+	return track.Artists[0].Name
 }
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temp file in the dir of the named file, syncs it
+// to disk and renames it over the named file. A crash during the write leaves either
+// the old or the new file in place, never a partial one.
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(fileName)
+	file, err := os.CreateTemp(dir, "."+filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempName := file.Name()
+
+	err = writeAndSync(file, data, perm)
+	if err != nil {
+		_ = os.Remove(tempName)
+		return err
+	}
+	err = os.Rename(tempName, fileName)
+	if err != nil {
+		_ = os.Remove(tempName)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// writeAndSync writes data to a file, syncs it to disk and closes it
+func writeAndSync(file *os.File, data []byte, perm os.FileMode) error {
+	_, err := file.Write(data)
+	if err == nil {
+		err = file.Chmod(perm)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir syncs a directory to disk so that a rename within it is kept after a
+// crash. Platforms which can't sync directories are ignored.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer closeFile(file)
+	_ = file.Sync()
+	return nil
+}
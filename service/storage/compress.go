@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+)
+
+// Compression formats cache files can be written in. Files are read in whichever
+// format they were written in, so files of every format can share a cache dir.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// WithCompression selects the format playlist files and versions are written in:
+// CompressionNone, CompressionGzip or CompressionZstd. Auth tokens are never compressed.
+func WithCompression(compression string) Option {
+	return func(s *storage) {
+		s.compression = compression
+	}
+}
+
+// newCompressor returns the function which compresses cache files in the given format
+func newCompressor(compression string) (func(data []byte) ([]byte, error), error) {
+	switch compression {
+	case CompressionNone:
+		return func(data []byte) ([]byte, error) {
+			return data, nil
+		}, nil
+	case CompressionGzip:
+		return func(data []byte) ([]byte, error) {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			if _, err := w.Write(data); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}, nil
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		return func(data []byte) ([]byte, error) {
+			return encoder.EncodeAll(data, nil), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown cache compression: %s", compression)
+}
+
+// writeCacheFile compresses data in the configured format and writes it to the file
+func (s *storage) writeCacheFile(fileName string, data []byte) error {
+	data, err := s.compress(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fileName, data, 0644)
+}
+
+// readCacheFile reads a cache file in any format, uncompressed
+func readCacheFile(fileName string) ([]byte, error) {
+	reader, err := openCacheFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = reader.Close() }()
+	return io.ReadAll(reader)
+}
+
+// openCacheFile opens a cache file in any format, detected from its first bytes, for
+// reading uncompressed
+func openCacheFile(fileName string) (io.ReadCloser, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := decompress(file)
+	if err != nil {
+		closeFile(file)
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return reader, nil
+}
+
+// decompress returns a reader of the uncompressed contents of a file. Closing the
+// reader closes the file.
+func decompress(file *os.File) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		reader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &compressedReader{Reader: reader, close: reader.Close, file: file}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &compressedReader{Reader: decoder, close: func() error {
+			decoder.Close()
+			return nil
+		}, file: file}, nil
+	}
+	return &compressedReader{Reader: buffered, close: func() error { return nil }, file: file}, nil
+}
+
+// compressedReader reads a file through a decompressor
+type compressedReader struct {
+	io.Reader
+	close func() error
+	file  *os.File
+}
+
+func (r *compressedReader) Close() error {
+	err := r.close()
+	if fileErr := r.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// fileCompression returns the format a cache file was written in
+func fileCompression(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer closeFile(file)
+
+	magic := make([]byte, len(zstdMagic))
+	n, _ := io.ReadFull(file, magic)
+	switch {
+	case bytes.HasPrefix(magic[:n], gzipMagic):
+		return CompressionGzip, nil
+	case bytes.HasPrefix(magic[:n], zstdMagic):
+		return CompressionZstd, nil
+	}
+	return CompressionNone, nil
+}
+
+// ConvertCache rewrites every cached playlist and version which isn't in the configured
+// format, and returns the number of files rewritten
+func (s *storage) ConvertCache(ctx context.Context) (int, error) {
+	fileNames, err := s.cacheFileNames()
+	if err != nil {
+		return 0, err
+	}
+
+	converted := 0
+	for _, fileName := range fileNames {
+		if err := ctx.Err(); err != nil {
+			return converted, err
+		}
+		compression, err := fileCompression(fileName)
+		if err != nil {
+			return converted, err
+		}
+		if compression == s.compression {
+			continue
+		}
+
+		log.Debugf("Converting %s from %s to %s", fileName, compression, s.compression)
+		data, err := readCacheFile(fileName)
+		if err != nil {
+			return converted, err
+		}
+		err = s.writeCacheFile(fileName, data)
+		if err != nil {
+			return converted, err
+		}
+		converted++
+	}
+	return converted, nil
+}
+
+// cacheFileNames returns the file of every cached playlist and version
+func (s *storage) cacheFileNames() ([]string, error) {
+	keys, err := s.playlistKeys()
+	if err != nil {
+		return nil, err
+	}
+	var fileNames []string
+	for _, key := range keys {
+		fileNames = append(fileNames, s.getPlaylistFilename(key))
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.cacheDir, historyDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		files, err := s.versionFiles(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			fileNames = append(fileNames, file.path)
+		}
+	}
+	return fileNames, nil
+}
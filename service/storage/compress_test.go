@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SavePlaylistFile_Compressed(t *testing.T) {
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		ctx := context.Background()
+		s := NewStorage(t.TempDir(), false, WithCompression(compression))
+		playlist := &service.CachedPlaylist{
+			ID:         "playlist1",
+			Name:       "test playlist",
+			SnapshotID: "snapshot1",
+			UpdatedAt:  time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC),
+			Tracks:     testTracks,
+		}
+		assert.NoError(t, s.SavePlaylistFile(ctx, playlist))
+
+		format, _ := fileCompression(s.getPlaylistFilename("playlist1"))
+		assert.Equal(t, compression, format)
+
+		result, err := s.LoadPlaylistFile(ctx, "playlist1")
+		assert.NoError(t, err)
+		assert.Equal(t, playlist, result)
+
+		found, err := s.FindPlaylistFiles(ctx, "test playlist")
+		assert.NoError(t, err)
+		assert.Equal(t, []*service.CachedPlaylist{playlist}, found)
+
+		version, err := s.LoadPlaylistVersion(ctx, "playlist1", playlist.UpdatedAt)
+		assert.NoError(t, err)
+		assert.Equal(t, playlist, version)
+	}
+}
+
+// Test_ConvertCache tests that plain and compressed files are read alongside each
+// other and converted in both directions
+func Test_ConvertCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+
+	plain := NewStorage(cacheDir, false)
+	_ = plain.SavePlaylistFile(ctx, &service.CachedPlaylist{ID: "playlist1", Name: "plain", Tracks: testTracks})
+
+	compressed := NewStorage(cacheDir, false, WithCompression(CompressionZstd))
+	_ = compressed.SavePlaylistFile(ctx, &service.CachedPlaylist{ID: "playlist2", Name: "compressed", Tracks: testTracks})
+
+	for _, s := range []*storage{plain, compressed} {
+		for _, key := range []string{"playlist1", "playlist2"} {
+			result, err := s.LoadPlaylistFile(ctx, key)
+			assert.NoError(t, err)
+			assert.Equal(t, testTracks, result.Tracks)
+		}
+	}
+
+	// a playlist file and a version of each playlist
+	converted, err := compressed.ConvertCache(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, converted)
+	format, _ := fileCompression(compressed.getPlaylistFilename("playlist1"))
+	assert.Equal(t, CompressionZstd, format)
+
+	converted, err = plain.ConvertCache(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, converted)
+	bytes, _ := os.ReadFile(plain.getPlaylistFilename("playlist2"))
+	assert.Contains(t, string(bytes), `"name": "compressed"`)
+
+	result, err := plain.LoadPlaylistFile(ctx, "playlist2")
+	assert.NoError(t, err)
+	assert.Equal(t, testTracks, result.Tracks)
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// historyDir is the directory within the cache dir holding the dated versions of
+// every playlist, in a directory per playlist key
+const historyDir = "history"
+
+// versionLayout is the time format of version file names
+const versionLayout = "2006-01-02T15-04-05.000000000Z"
+
+// versionFile is a dated version of a playlist
+type versionFile struct {
+	path string
+	time time.Time
+}
+
+// ListPlaylistVersions returns every version kept of a playlist, oldest first
+func (s *storage) ListPlaylistVersions(ctx context.Context, key string) ([]service.PlaylistVersion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	files, err := s.versionFiles(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []service.PlaylistVersion
+	for _, file := range files {
+		playlist, err := readVersion(file, "")
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, service.PlaylistVersion{
+			UpdatedAt:  file.time,
+			SnapshotID: playlist.SnapshotID,
+			Tracks:     len(playlist.Tracks),
+		})
+	}
+	return versions, nil
+}
+
+// LoadPlaylistVersion loads the playlist as it was at the given time, from the newest
+// version saved at or before it. Returns nil if there is no such version.
+func (s *storage) LoadPlaylistVersion(ctx context.Context, key string, at time.Time) (*service.CachedPlaylist, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	files, err := s.versionFiles(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].time.After(at) {
+			continue
+		}
+		log.Debugf("Loading playlist %s as of %s from file: %s", key, at, files[i].path)
+		return readVersion(files[i], "")
+	}
+	return nil, nil
+}
+
+// savePlaylistVersion saves a dated copy of a playlist file, unless the newest
+// version already has the same snapshot, then removes the versions which are
+// beyond the retention limits
+func (s *storage) savePlaylistVersion(playlist *service.CachedPlaylist, jsonData []byte) error {
+	key := playlist.Key()
+	files, err := s.versionFiles(key)
+	if err != nil {
+		return err
+	}
+
+	if len(files) > 0 && playlist.SnapshotID != "" {
+		latest, err := readVersion(files[len(files)-1], "")
+		if err == nil && latest.SnapshotID == playlist.SnapshotID {
+			log.Debugf("Playlist %s is unchanged since its last version", playlist.Name)
+			return nil
+		}
+	}
+
+	versionTime := playlist.UpdatedAt
+	if versionTime.IsZero() {
+		versionTime = time.Now()
+	}
+
+	dir := s.getHistoryDir(key)
+	err = os.MkdirAll(dir, 0770)
+	if err != nil {
+		return err
+	}
+
+	fileName := filepath.Join(dir, versionTime.UTC().Format(versionLayout)+".json")
+	log.Debugf("Saving version of playlist %s to file %s", playlist.Name, fileName)
+	err = s.writeCacheFile(fileName, jsonData)
+	if err != nil {
+		return err
+	}
+
+	return s.pruneVersions(key)
+}
+
+// pruneVersions removes the oldest versions of a playlist beyond the maximum number
+// of versions, and every version older than the maximum age, except the newest
+func (s *storage) pruneVersions(key string) error {
+	files, err := s.versionFiles(key)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-s.historyMaxAge)
+	for i := 0; i < len(files)-1; i++ {
+		tooMany := s.historyMaxVersions > 0 && len(files)-i > s.historyMaxVersions
+		tooOld := s.historyMaxAge > 0 && files[i].time.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+
+		log.Debugf("Removing old version of playlist %s: %s", key, files[i].path)
+		err = os.Remove(files[i].path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionFiles returns the version files of a playlist key, oldest first
+func (s *storage) versionFiles(key string) ([]versionFile, error) {
+	dir := s.getHistoryDir(key)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []versionFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		versionTime, err := time.Parse(versionLayout, strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		files = append(files, versionFile{path: filepath.Join(dir, name), time: versionTime})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].time.Before(files[j].time)
+	})
+	return files, nil
+}
+
+// readVersion loads a version file. Versions of playlists saved without metadata
+// are given the fallback name and the time of the version.
+func readVersion(file versionFile, fallbackName string) (*service.CachedPlaylist, error) {
+	bytes, err := readCacheFile(file.path)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := parsePlaylist(bytes, fallbackName)
+	if err != nil {
+		return nil, err
+	}
+	if playlist.UpdatedAt.IsZero() {
+		playlist.UpdatedAt = file.time
+	}
+	return playlist, nil
+}
+
+// getHistoryDir returns the full path to the directory of the versions of a playlist key
+func (s *storage) getHistoryDir(key string) string {
+	return filepath.Join(s.cacheDir, historyDir, key)
+}
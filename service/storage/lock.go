@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// lockFileName is the file within the cache dir which is locked by the run using it
+const lockFileName = "spotify-automation.lock"
+
+// lockPollInterval is how often a locked cache dir is checked while waiting for it
+const lockPollInterval = 250 * time.Millisecond
+
+// ErrLocked is returned when another run holds the lock on the cache dir
+var ErrLocked = errors.New("cache dir is locked by another run")
+
+type lock struct {
+	file *os.File
+}
+
+// Lock locks the cache dir so that only one run uses it at a time, with an exclusive
+// flock on the lock file. If another run holds the lock, it waits up to the given time
+// for it to be released before returning ErrLocked. The lock is released by the
+// kernel when the process exits, so a crashed run never leaves the cache dir locked.
+func Lock(ctx context.Context, cacheDir string, wait time.Duration) (*lock, error) {
+	err := os.MkdirAll(cacheDir, 0770)
+	if err != nil {
+		return nil, err
+	}
+	fileName := filepath.Join(cacheDir, lockFileName)
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	logged := false
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			_ = file.Close()
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			_ = file.Close()
+			return nil, fmt.Errorf("%w: %s", ErrLocked, fileName)
+		}
+		if !logged {
+			log.Infof("Waiting for another run to unlock the cache dir")
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = file.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	// the PID is only recorded to show which process holds the lock
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	log.Debugf("Locked cache dir with file: %s", fileName)
+	return &lock{file: file}, nil
+}
+
+// Close releases the lock. The lock file is left in place, since a run removing it
+// could let the next run lock a new file while another still waits on the old one.
+func (l *lock) Close() error {
+	log.Debugf("Unlocking cache dir")
+	return l.file.Close()
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Lock(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	l, err := Lock(context.Background(), cacheDir, 0)
+	assert.NoError(t, err)
+
+	bytes, _ := os.ReadFile(filepath.Join(cacheDir, lockFileName))
+	assert.Equal(t, strconv.Itoa(os.Getpid()), string(bytes))
+	assert.NoError(t, l.Close())
+
+	l, err = Lock(context.Background(), cacheDir, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Close())
+}
+
+// Test_Lock_Held tests that a lock held by another run is not taken
+func Test_Lock_Held(t *testing.T) {
+	cacheDir := t.TempDir()
+	held, err := Lock(context.Background(), cacheDir, 0)
+	assert.NoError(t, err)
+	defer func() { _ = held.Close() }()
+
+	_, err = Lock(context.Background(), cacheDir, 0)
+	assert.ErrorIs(t, err, ErrLocked)
+}
+
+// Test_Lock_Wait tests that a run waits for the lock to be released
+func Test_Lock_Wait(t *testing.T) {
+	cacheDir := t.TempDir()
+	held, err := Lock(context.Background(), cacheDir, 0)
+	assert.NoError(t, err)
+	time.AfterFunc(300*time.Millisecond, func() { _ = held.Close() })
+
+	l, err := Lock(context.Background(), cacheDir, 5*time.Second)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Close())
+}
+
+// Test_Lock_Wait_Cancelled tests that waiting for the lock stops when the context is cancelled
+func Test_Lock_Wait_Cancelled(t *testing.T) {
+	cacheDir := t.TempDir()
+	held, err := Lock(context.Background(), cacheDir, 0)
+	assert.NoError(t, err)
+	defer func() { _ = held.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	_, err = Lock(ctx, cacheDir, time.Minute)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// Test_Lock_LeftBehind tests that a lock file left by an earlier run, including one
+// holding the PID of this process as runs in separate containers do, is not held
+func Test_Lock_LeftBehind(t *testing.T) {
+	for _, content := range []string{"", "not a pid", strconv.Itoa(os.Getpid()), "999999999"} {
+		cacheDir := t.TempDir()
+		_ = os.WriteFile(filepath.Join(cacheDir, lockFileName), []byte(content), 0644)
+
+		l, err := Lock(context.Background(), cacheDir, 0)
+		assert.NoError(t, err, content)
+		if l != nil {
+			assert.NoError(t, l.Close())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+)
+
+// MigrateLegacyPlaylist moves the cache file written for a playlist by older versions,
+// which was named after the playlist and only held its tracks, to the file of the
+// playlist's ID. The tracks are kept as a version dated when the file was written,
+// and are refreshed on the next sync. Nothing is done if there is no such file or the
+// playlist is already cached under its ID.
+func (s *storage) MigrateLegacyPlaylist(ctx context.Context, id spotify.ID, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id == "" {
+		return nil
+	}
+	if _, err := os.Stat(s.getPlaylistFilename(string(id))); err == nil {
+		return nil
+	}
+	playlist, fileName, err := loadLegacyPlaylist(s.cacheDir, id, name)
+	if err != nil || playlist == nil {
+		return err
+	}
+
+	log.Infof("Migrating playlist %s from file: %s", name, fileName)
+	err = s.SavePlaylistFile(ctx, playlist)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fileName)
+	if os.IsNotExist(err) {
+		// already migrated by a playlist with the same file name
+		return nil
+	}
+	return err
+}
+
+// loadLegacyPlaylist loads the cache file written for a playlist by older versions,
+// along with its file name. Returns nil if there is no such file.
+func loadLegacyPlaylist(cacheDir string, id spotify.ID, name string) (*service.CachedPlaylist, string, error) {
+	fileName := filepath.Join(cacheDir, service.SafeFileName(name)+".json")
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	bytes, err := readCacheFile(fileName)
+	if err != nil {
+		return nil, "", err
+	}
+	if !strings.HasPrefix(strings.TrimSpace(string(bytes)), "[") {
+		// not a list of tracks, ex: the auth token
+		return nil, "", nil
+	}
+	playlist, err := parsePlaylist(bytes, name)
+	if err != nil {
+		return nil, "", err
+	}
+	playlist.ID = id
+	playlist.UpdatedAt = info.ModTime().UTC()
+	return playlist, fileName, nil
+}
@@ -0,0 +1,593 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 driver
+	"github.com/reeves122/spotify-automation-go/service"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+// sqliteTimeLayout is the time format of the database, which sorts in time order
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// sqliteSchema creates the tables of the cache. Playlists are keyed by the key
+// returned by service.PlaylistKey. Tracks, artists and albums are shared by every
+// playlist, and playlist_tracks holds the tracks of each playlist in order. Each
+// track also keeps its full JSON so it can be loaded unchanged. Tracks, artists and
+// albums which are no longer in any playlist are removed.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS playlists (
+	key         TEXT PRIMARY KEY,
+	id          TEXT NOT NULL,
+	name        TEXT NOT NULL,
+	owner_id    TEXT NOT NULL,
+	snapshot_id TEXT NOT NULL,
+	updated_at  TEXT NOT NULL,
+	renames     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS playlists_name ON playlists (name);
+CREATE TABLE IF NOT EXISTS artists (
+	artist_key INTEGER PRIMARY KEY,
+	spotify_id TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	UNIQUE (spotify_id, name)
+);
+CREATE TABLE IF NOT EXISTS albums (
+	album_key  INTEGER PRIMARY KEY,
+	spotify_id TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	UNIQUE (spotify_id, name)
+);
+CREATE TABLE IF NOT EXISTS tracks (
+	track_key   INTEGER PRIMARY KEY,
+	key         TEXT NOT NULL UNIQUE,
+	spotify_id  TEXT NOT NULL,
+	uri         TEXT NOT NULL,
+	name        TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	isrc        TEXT NOT NULL,
+	album_key   INTEGER REFERENCES albums (album_key),
+	data        TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tracks_album ON tracks (album_key);
+CREATE TABLE IF NOT EXISTS track_artists (
+	track_key  INTEGER NOT NULL REFERENCES tracks (track_key),
+	position   INTEGER NOT NULL,
+	artist_key INTEGER NOT NULL REFERENCES artists (artist_key),
+	PRIMARY KEY (track_key, position)
+);
+CREATE INDEX IF NOT EXISTS track_artists_artist ON track_artists (artist_key);
+CREATE TABLE IF NOT EXISTS playlist_tracks (
+	playlist_key TEXT NOT NULL REFERENCES playlists (key) ON DELETE CASCADE,
+	position     INTEGER NOT NULL,
+	track_key    INTEGER NOT NULL REFERENCES tracks (track_key),
+	added_at     TEXT NOT NULL,
+	added_by     TEXT NOT NULL,
+	is_local     INTEGER NOT NULL,
+	PRIMARY KEY (playlist_key, position)
+);
+CREATE INDEX IF NOT EXISTS playlist_tracks_track ON playlist_tracks (track_key);
+CREATE TABLE IF NOT EXISTS playlist_versions (
+	playlist_key TEXT NOT NULL,
+	updated_at   TEXT NOT NULL,
+	snapshot_id  TEXT NOT NULL,
+	track_count  INTEGER NOT NULL,
+	data         TEXT NOT NULL,
+	PRIMARY KEY (playlist_key, updated_at)
+);
+`
+
+// sqliteStorage caches playlists in a SQLite database. Auth tokens are still kept
+// in files in the cache dir.
+type sqliteStorage struct {
+	db    *sql.DB
+	files *storage // holds the auth tokens
+
+	historyMaxVersions int
+	historyMaxAge      time.Duration
+}
+
+// NewSQLiteStorage opens the SQLite database at fileName, relative to the cache dir
+// unless absolute, creating it if needed. A new database is filled with the JSON
+// cache files already in the cache dir, along with their history.
+func NewSQLiteStorage(ctx context.Context, cacheDir string, fileName string, opts ...Option) (*sqliteStorage, error) {
+	files := NewStorage(cacheDir, false, opts...)
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(cacheDir, fileName)
+	}
+	_, err := os.Stat(fileName)
+	isNew := os.IsNotExist(err)
+
+	log.Debugf("Opening SQLite cache: %s", fileName)
+	db, err := sql.Open("sqlite3", "file:"+fileName+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// a single connection serializes the writes of concurrent syncs
+	db.SetMaxOpenConns(1)
+
+	s := &sqliteStorage{
+		db:                 db,
+		files:              files,
+		historyMaxVersions: files.historyMaxVersions,
+		historyMaxAge:      files.historyMaxAge,
+	}
+
+	_, err = db.ExecContext(ctx, sqliteSchema)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	if isNew {
+		count, err := s.ImportJSONCache(ctx, cacheDir)
+		if err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+		if count > 0 {
+			log.Infof("Migrated %d playlists from the JSON cache to SQLite", count)
+		}
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *sqliteStorage) Close() error {
+	return s.db.Close()
+}
+
+// LoadToken loads the auth token from JSON file in the cache dir
+func (s *sqliteStorage) LoadToken(ctx context.Context, fileName string) (*oauth2.Token, error) {
+	return s.files.LoadToken(ctx, fileName)
+}
+
+// SaveToken saves the auth token to JSON file in the cache dir
+func (s *sqliteStorage) SaveToken(ctx context.Context, token *oauth2.Token, fileName string) error {
+	return s.files.SaveToken(ctx, token, fileName)
+}
+
+// LoadCodeVerifier loads the PKCE code verifier saved next to the auth token file
+func (s *sqliteStorage) LoadCodeVerifier(ctx context.Context, tokenFile string) (string, error) {
+	return s.files.LoadCodeVerifier(ctx, tokenFile)
+}
+
+// SaveCodeVerifier saves the PKCE code verifier next to the auth token file
+func (s *sqliteStorage) SaveCodeVerifier(ctx context.Context, verifier string, tokenFile string) error {
+	return s.files.SaveCodeVerifier(ctx, verifier, tokenFile)
+}
+
+// LoadTracksFile loads the tracks of a cached playlist
+func (s *sqliteStorage) LoadTracksFile(ctx context.Context, playlistID spotify.ID) ([]spotify.PlaylistTrack, error) {
+	playlist, err := s.LoadPlaylistFile(ctx, string(playlistID))
+	if err != nil || playlist == nil {
+		return nil, err
+	}
+	return playlist.Tracks, nil
+}
+
+// SaveTracksFile saves the tracks of a playlist, without any playlist metadata
+func (s *sqliteStorage) SaveTracksFile(ctx context.Context, playlistID spotify.ID, tracks []spotify.PlaylistTrack) error {
+	return s.SavePlaylistFile(ctx, &service.CachedPlaylist{
+		ID:     playlistID,
+		Tracks: tracks,
+	})
+}
+
+// LoadPlaylistFile loads the cached playlist with the given key. Returns nil if the
+// playlist has not been cached.
+func (s *sqliteStorage) LoadPlaylistFile(ctx context.Context, key string) (*service.CachedPlaylist, error) {
+	var playlist service.CachedPlaylist
+	var id, updatedAt, renames string
+	err := s.db.QueryRowContext(ctx, `SELECT id, name, owner_id, snapshot_id, updated_at, renames FROM playlists WHERE key = ?`,
+		key).Scan(&id, &playlist.Name, &playlist.OwnerID, &playlist.SnapshotID, &updatedAt, &renames)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	playlist.ID = spotify.ID(id)
+	playlist.UpdatedAt = parseSQLiteTime(updatedAt)
+	err = json.Unmarshal([]byte(renames), &playlist.Renames)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT pt.added_at, pt.added_by, pt.is_local, t.data
+		FROM playlist_tracks pt JOIN tracks t ON t.track_key = pt.track_key
+		WHERE pt.playlist_key = ? ORDER BY pt.position`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var track spotify.PlaylistTrack
+		var addedBy, data string
+		err = rows.Scan(&track.AddedAt, &addedBy, &track.IsLocal, &data)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(addedBy), &track.AddedBy)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(data), &track.Track)
+		if err != nil {
+			return nil, err
+		}
+		playlist.Tracks = append(playlist.Tracks, track)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Loaded %d cached tracks for playlist: %s", len(playlist.Tracks), playlist.Name)
+	return &playlist, nil
+}
+
+// MigrateLegacyPlaylist copies the cache file written for a playlist by older versions,
+// which was named after the playlist and only held its tracks, into the database
+// under the playlist's ID. The file is left in place, like the rest of the JSON cache.
+func (s *sqliteStorage) MigrateLegacyPlaylist(ctx context.Context, id spotify.ID, name string) error {
+	if id == "" {
+		return nil
+	}
+	var cached int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM playlists WHERE key = ?`, string(id)).Scan(&cached)
+	if err != nil || cached > 0 {
+		return err
+	}
+	playlist, fileName, err := loadLegacyPlaylist(s.files.cacheDir, id, name)
+	if err != nil || playlist == nil {
+		return err
+	}
+
+	log.Infof("Migrating playlist %s from file: %s", name, fileName)
+	return s.SavePlaylistFile(ctx, playlist)
+}
+
+// FindPlaylistFiles loads every cached playlist with the given name or key
+func (s *sqliteStorage) FindPlaylistFiles(ctx context.Context, name string) ([]*service.CachedPlaylist, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key FROM playlists WHERE name = ? OR key = ? ORDER BY key`, name, name)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		err = rows.Scan(&key)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var playlists []*service.CachedPlaylist
+	for _, key := range keys {
+		playlist, err := s.LoadPlaylistFile(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, nil
+}
+
+// SavePlaylistFile saves a playlist and its tracks, replacing any cached copy, and
+// keeps a dated version of it in the playlist's history
+func (s *sqliteStorage) SavePlaylistFile(ctx context.Context, playlist *service.CachedPlaylist) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	log.Debugf("Saving playlist %s to SQLite with %d tracks", playlist.Name, len(playlist.Tracks))
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	key := playlist.Key()
+	_, err = tx.ExecContext(ctx, `DELETE FROM playlists WHERE key = ?`, key)
+	if err != nil {
+		return err
+	}
+	err = insertPlaylist(ctx, tx, key, playlist)
+	if err != nil {
+		return err
+	}
+
+	for position, track := range playlist.Tracks {
+		trackKey, err := saveTrack(ctx, tx, track.Track)
+		if err != nil {
+			return err
+		}
+		addedBy, _ := json.Marshal(track.AddedBy)
+		_, err = tx.ExecContext(ctx, `INSERT INTO playlist_tracks (playlist_key, position, track_key, added_at, added_by, is_local)
+			VALUES (?, ?, ?, ?, ?, ?)`, key, position, trackKey, track.AddedAt, string(addedBy), track.IsLocal)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = pruneTracks(ctx, tx)
+	if err != nil {
+		return err
+	}
+	err = s.savePlaylistVersion(ctx, tx, playlist)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// pruneTracks removes the tracks which are no longer in any playlist, then the
+// artists and albums which are no longer on any track
+func pruneTracks(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM track_artists WHERE NOT EXISTS (
+			SELECT 1 FROM playlist_tracks pt WHERE pt.track_key = track_artists.track_key);
+		DELETE FROM tracks WHERE NOT EXISTS (
+			SELECT 1 FROM playlist_tracks pt WHERE pt.track_key = tracks.track_key);
+		DELETE FROM artists WHERE NOT EXISTS (
+			SELECT 1 FROM track_artists ta WHERE ta.artist_key = artists.artist_key);
+		DELETE FROM albums WHERE NOT EXISTS (
+			SELECT 1 FROM tracks t WHERE t.album_key = albums.album_key);`)
+	return err
+}
+
+// insertPlaylist inserts the metadata of a playlist under a key
+func insertPlaylist(ctx context.Context, tx *sql.Tx, key string, playlist *service.CachedPlaylist) error {
+	renames, _ := json.Marshal(playlist.Renames)
+	_, err := tx.ExecContext(ctx, `INSERT INTO playlists (key, id, name, owner_id, snapshot_id, updated_at, renames)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, key, string(playlist.ID), playlist.Name, playlist.OwnerID, playlist.SnapshotID,
+		formatSQLiteTime(playlist.UpdatedAt), string(renames))
+	return err
+}
+
+// saveTrack inserts or updates a track along with its album and artists, and
+// returns its key
+func saveTrack(ctx context.Context, tx *sql.Tx, track spotify.FullTrack) (int64, error) {
+	data, _ := json.Marshal(track)
+	key := string(track.URI)
+	if key == "" {
+		key = string(track.ID)
+	}
+	if key == "" {
+		key = "data:" + string(data)
+	}
+
+	var albumKey int64
+	err := tx.QueryRowContext(ctx, `INSERT INTO albums (spotify_id, name) VALUES (?, ?)
+		ON CONFLICT (spotify_id, name) DO UPDATE SET name = excluded.name RETURNING album_key`,
+		string(track.Album.ID), track.Album.Name).Scan(&albumKey)
+	if err != nil {
+		return 0, err
+	}
+
+	var trackKey int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO tracks (key, spotify_id, uri, name, duration_ms, isrc, album_key, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET spotify_id = excluded.spotify_id, uri = excluded.uri, name = excluded.name,
+			duration_ms = excluded.duration_ms, isrc = excluded.isrc, album_key = excluded.album_key, data = excluded.data
+		RETURNING track_key`,
+		key, string(track.ID), string(track.URI), track.Name, track.Duration, track.ExternalIDs["isrc"], albumKey,
+		string(data)).Scan(&trackKey)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM track_artists WHERE track_key = ?`, trackKey)
+	if err != nil {
+		return 0, err
+	}
+	for position, artist := range track.Artists {
+		var artistKey int64
+		err = tx.QueryRowContext(ctx, `INSERT INTO artists (spotify_id, name) VALUES (?, ?)
+			ON CONFLICT (spotify_id, name) DO UPDATE SET name = excluded.name RETURNING artist_key`,
+			string(artist.ID), artist.Name).Scan(&artistKey)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO track_artists (track_key, position, artist_key) VALUES (?, ?, ?)`,
+			trackKey, position, artistKey)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return trackKey, nil
+}
+
+// ListPlaylistVersions returns every version kept of a playlist, oldest first
+func (s *sqliteStorage) ListPlaylistVersions(ctx context.Context, key string) ([]service.PlaylistVersion, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT updated_at, snapshot_id, track_count FROM playlist_versions
+		WHERE playlist_key = ? ORDER BY updated_at`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var versions []service.PlaylistVersion
+	for rows.Next() {
+		var version service.PlaylistVersion
+		var updatedAt string
+		err = rows.Scan(&updatedAt, &version.SnapshotID, &version.Tracks)
+		if err != nil {
+			return nil, err
+		}
+		version.UpdatedAt = parseSQLiteTime(updatedAt)
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// LoadPlaylistVersion loads the playlist as it was at the given time, from the newest
+// version saved at or before it. Returns nil if there is no such version.
+func (s *sqliteStorage) LoadPlaylistVersion(ctx context.Context, key string, at time.Time) (*service.CachedPlaylist, error) {
+	var updatedAt, data string
+	err := s.db.QueryRowContext(ctx, `SELECT updated_at, data FROM playlist_versions
+		WHERE playlist_key = ? AND updated_at <= ? ORDER BY updated_at DESC LIMIT 1`,
+		key, formatSQLiteTime(at)).Scan(&updatedAt, &data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := parsePlaylist([]byte(data), "")
+	if err != nil {
+		return nil, err
+	}
+	if playlist.UpdatedAt.IsZero() {
+		playlist.UpdatedAt = parseSQLiteTime(updatedAt)
+	}
+	return playlist, nil
+}
+
+// savePlaylistVersion saves a dated copy of a playlist, unless the newest version
+// already has the same snapshot, then removes the versions which are beyond the
+// retention limits
+func (s *sqliteStorage) savePlaylistVersion(ctx context.Context, tx *sql.Tx, playlist *service.CachedPlaylist) error {
+	key := playlist.Key()
+	if playlist.SnapshotID != "" {
+		var latest string
+		err := tx.QueryRowContext(ctx, `SELECT snapshot_id FROM playlist_versions WHERE playlist_key = ?
+			ORDER BY updated_at DESC LIMIT 1`, key).Scan(&latest)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if latest == playlist.SnapshotID {
+			log.Debugf("Playlist %s is unchanged since its last version", playlist.Name)
+			return nil
+		}
+	}
+
+	versionTime := playlist.UpdatedAt
+	if versionTime.IsZero() {
+		versionTime = time.Now()
+	}
+	err := insertVersion(ctx, tx, key, playlist, versionTime)
+	if err != nil {
+		return err
+	}
+
+	if s.historyMaxVersions > 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM playlist_versions WHERE playlist_key = ? AND updated_at NOT IN (
+			SELECT updated_at FROM playlist_versions WHERE playlist_key = ? ORDER BY updated_at DESC LIMIT ?)`,
+			key, key, s.historyMaxVersions)
+		if err != nil {
+			return err
+		}
+	}
+	if s.historyMaxAge > 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM playlist_versions WHERE playlist_key = ? AND updated_at < ?
+			AND updated_at < (SELECT MAX(updated_at) FROM playlist_versions WHERE playlist_key = ?)`,
+			key, formatSQLiteTime(time.Now().Add(-s.historyMaxAge)), key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertVersion saves a version of a playlist under a key at the given time, unless
+// there already is one at that time
+func insertVersion(ctx context.Context, tx *sql.Tx, key string, playlist *service.CachedPlaylist, versionTime time.Time) error {
+	data, _ := json.Marshal(playlist)
+	_, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO playlist_versions (playlist_key, updated_at, snapshot_id, track_count, data)
+		VALUES (?, ?, ?, ?, ?)`, key, formatSQLiteTime(versionTime), playlist.SnapshotID, len(playlist.Tracks), string(data))
+	return err
+}
+
+// ImportJSONCache copies the playlist files in a JSON cache dir, and their history,
+// into the database, replacing any playlists with the same key. Returns the number
+// of playlists imported.
+func (s *sqliteStorage) ImportJSONCache(ctx context.Context, cacheDir string) (int, error) {
+	files := NewStorage(cacheDir, false)
+
+	entries, err := os.ReadDir(filepath.Join(cacheDir, historyDir))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		err = s.importJSONHistory(ctx, files, entry.Name())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	keys, err := files.playlistKeys()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, key := range keys {
+		playlist, err := files.LoadPlaylistFile(ctx, key)
+		if err != nil {
+			return count, err
+		}
+
+		log.Infof("Migrating playlist %s from file: %s", playlist.Name, files.getPlaylistFilename(key))
+		err = s.SavePlaylistFile(ctx, playlist)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// importJSONHistory copies the versions in the history dir of a playlist key
+func (s *sqliteStorage) importJSONHistory(ctx context.Context, files *storage, key string) error {
+	versionFiles, err := files.versionFiles(key)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, file := range versionFiles {
+		playlist, err := readVersion(file, "")
+		if err != nil {
+			return err
+		}
+		err = insertVersion(ctx, tx, key, playlist, file.time)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func formatSQLiteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+func parseSQLiteTime(value string) time.Time {
+	t, _ := time.Parse(sqliteTimeLayout, value)
+	return t
+}
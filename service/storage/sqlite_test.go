@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+func newTestSQLiteStorage(t *testing.T, cacheDir string, opts ...Option) *sqliteStorage {
+	s, err := NewSQLiteStorage(context.Background(), cacheDir, "cache.db", opts...)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func Test_SQLite_SavePlaylistFile(t *testing.T) {
+	s := newTestSQLiteStorage(t, t.TempDir())
+	ctx := context.Background()
+	playlist := &service.CachedPlaylist{
+		ID:         "playlist1",
+		Name:       "test playlist",
+		OwnerID:    "user1",
+		SnapshotID: "snapshot1",
+		UpdatedAt:  time.Unix(1644696995, 0).UTC(),
+		Tracks:     testTracks,
+	}
+	assert.NoError(t, s.SavePlaylistFile(ctx, playlist))
+
+	result, err := s.LoadPlaylistFile(ctx, "playlist1")
+	assert.NoError(t, err)
+	assert.Equal(t, playlist, result)
+
+	found, err := s.FindPlaylistFiles(ctx, "test playlist")
+	assert.NoError(t, err)
+	assert.Equal(t, []*service.CachedPlaylist{playlist}, found)
+
+	tracks, err := s.LoadTracksFile(ctx, "playlist1")
+	assert.NoError(t, err)
+	assert.Equal(t, testTracks, tracks)
+
+	result, err = s.LoadPlaylistFile(ctx, "missing playlist")
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+// Test_SQLite_SharedTracks tests that a track in several playlists is stored once
+// along with its artists and album
+func Test_SQLite_SharedTracks(t *testing.T) {
+	s := newTestSQLiteStorage(t, t.TempDir())
+	ctx := context.Background()
+	track := spotify.PlaylistTrack{
+		AddedAt: "2022-02-01T12:00:00Z",
+		AddedBy: spotify.User{ID: "user1"},
+		Track: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{
+				ID:       "track1",
+				URI:      "spotify:track:track1",
+				Name:     "Song",
+				Duration: 200000,
+				Artists:  []spotify.SimpleArtist{{ID: "artist1", Name: "Artist"}, {ID: "artist2", Name: "Guest"}},
+			},
+			Album:       spotify.SimpleAlbum{ID: "album1", Name: "Album"},
+			ExternalIDs: map[string]string{"isrc": "USABC2200001"},
+		},
+	}
+
+	_ = s.SaveTracksFile(ctx, "playlist1", []spotify.PlaylistTrack{track, track})
+	_ = s.SaveTracksFile(ctx, "playlist2", []spotify.PlaylistTrack{track})
+
+	var tracks, artists, albums, memberships int
+	err := s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM tracks), (SELECT COUNT(*) FROM artists),
+		(SELECT COUNT(*) FROM albums), (SELECT COUNT(*) FROM playlist_tracks)`).Scan(&tracks, &artists, &albums, &memberships)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 1, 3}, []int{tracks, artists, albums, memberships})
+
+	var isrc string
+	err = s.db.QueryRow(`SELECT isrc FROM tracks WHERE spotify_id = 'track1'`).Scan(&isrc)
+	assert.NoError(t, err)
+	assert.Equal(t, "USABC2200001", isrc)
+
+	result, _ := s.LoadTracksFile(ctx, "playlist1")
+	assert.Equal(t, []spotify.PlaylistTrack{track, track}, result)
+}
+
+// Test_SQLite_PruneTracks tests that tracks removed from every playlist are deleted
+// along with the artists and albums no other track has
+func Test_SQLite_PruneTracks(t *testing.T) {
+	s := newTestSQLiteStorage(t, t.TempDir())
+	ctx := context.Background()
+	newTrack := func(id string, artist string, album string) spotify.PlaylistTrack {
+		return spotify.PlaylistTrack{Track: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{ID: spotify.ID(id), URI: spotify.URI("spotify:track:" + id), Name: id,
+				Artists: []spotify.SimpleArtist{{ID: spotify.ID(artist), Name: artist}}},
+			Album: spotify.SimpleAlbum{ID: spotify.ID(album), Name: album},
+		}}
+	}
+	counts := func() []int {
+		var tracks, artists, albums, trackArtists int
+		err := s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM tracks), (SELECT COUNT(*) FROM artists),
+			(SELECT COUNT(*) FROM albums), (SELECT COUNT(*) FROM track_artists)`).Scan(&tracks, &artists, &albums, &trackArtists)
+		assert.NoError(t, err)
+		return []int{tracks, artists, albums, trackArtists}
+	}
+
+	_ = s.SaveTracksFile(ctx, "playlist1", []spotify.PlaylistTrack{
+		newTrack("track1", "artist1", "album1"),
+		newTrack("track2", "artist2", "album2"),
+		newTrack("track3", "artist1", "album2"),
+	})
+	_ = s.SaveTracksFile(ctx, "playlist2", []spotify.PlaylistTrack{newTrack("track2", "artist2", "album2")})
+	assert.Equal(t, []int{3, 2, 2, 3}, counts())
+
+	// track2 is still in playlist2
+	err := s.SaveTracksFile(ctx, "playlist1", []spotify.PlaylistTrack{newTrack("track3", "artist1", "album2")})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 2, 1, 2}, counts())
+
+	err = s.SaveTracksFile(ctx, "playlist2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 1, 1, 1}, counts())
+}
+
+func Test_SQLite_History(t *testing.T) {
+	s := newTestSQLiteStorage(t, t.TempDir(), WithHistoryRetention(2, 0))
+	ctx := context.Background()
+	start := time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, snapshot := range []string{"snapshot1", "snapshot2", "snapshot2", "snapshot3"} {
+		_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{
+			ID:         "playlist1",
+			Name:       "test playlist",
+			SnapshotID: snapshot,
+			UpdatedAt:  start.Add(time.Duration(i) * time.Hour),
+			Tracks:     testTracks[:i%2],
+		})
+	}
+
+	versions, err := s.ListPlaylistVersions(ctx, "playlist1")
+	assert.NoError(t, err)
+	assert.Equal(t, []service.PlaylistVersion{
+		{UpdatedAt: start.Add(time.Hour), SnapshotID: "snapshot2", Tracks: 1},
+		{UpdatedAt: start.Add(3 * time.Hour), SnapshotID: "snapshot3", Tracks: 1},
+	}, versions)
+
+	result, err := s.LoadPlaylistVersion(ctx, "playlist1", start.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot2", result.SnapshotID)
+	assert.Equal(t, testTracks, result.Tracks)
+
+	result, err = s.LoadPlaylistVersion(ctx, "playlist1", start)
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+// Test_SQLite_ImportJSONCache tests that a new database is filled from the JSON cache
+func Test_SQLite_ImportJSONCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	start := time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	files := NewStorage(cacheDir, false)
+	_ = files.SaveToken(ctx, testToken, "auth_token.json")
+	for i := 0; i < 3; i++ {
+		_ = files.SavePlaylistFile(ctx, &service.CachedPlaylist{
+			ID:         "playlist1",
+			Name:       "test / playlist",
+			SnapshotID: fmt.Sprintf("snapshot%d", i),
+			UpdatedAt:  start.Add(time.Duration(i) * time.Hour),
+			Tracks:     testTracks,
+		})
+	}
+	s := newTestSQLiteStorage(t, cacheDir)
+
+	result, err := s.LoadPlaylistFile(ctx, "playlist1")
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot2", result.SnapshotID)
+	assert.Equal(t, testTracks, result.Tracks)
+
+	versions, _ := s.ListPlaylistVersions(ctx, "playlist1")
+	assert.Len(t, versions, 3)
+
+	token, err := s.LoadToken(ctx, "auth_token.json")
+	assert.NoError(t, err)
+	assert.Equal(t, testToken, token)
+
+	found, _ := s.FindPlaylistFiles(ctx, "auth_token")
+	assert.Empty(t, found)
+}
+
+// Test_SQLite_MigrateLegacyPlaylist tests that the cache file of a playlist written by
+// the original version is copied into the database under its ID, leaving the file
+func Test_SQLite_MigrateLegacyPlaylist(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+
+	legacyFile := filepath.Join(cacheDir, "test playlist.json")
+	legacy, _ := json.Marshal(testTracks)
+	_ = os.WriteFile(legacyFile, legacy, 0644)
+
+	s := newTestSQLiteStorage(t, cacheDir)
+	found, _ := s.FindPlaylistFiles(ctx, "test playlist")
+	assert.Empty(t, found)
+
+	err := s.MigrateLegacyPlaylist(ctx, "playlist1", "test playlist")
+	assert.NoError(t, err)
+	result, err := s.LoadPlaylistFile(ctx, "playlist1")
+	assert.NoError(t, err)
+	assert.Equal(t, "test playlist", result.Name)
+	assert.Equal(t, testTracks, result.Tracks)
+	assert.FileExists(t, legacyFile)
+
+	versions, _ := s.ListPlaylistVersions(ctx, "playlist1")
+	assert.Len(t, versions, 1)
+
+	// the playlist is only migrated once
+	_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{ID: "playlist1", Name: "test playlist", SnapshotID: "snapshot1"})
+	err = s.MigrateLegacyPlaylist(ctx, "playlist1", "test playlist")
+	assert.NoError(t, err)
+	result, _ = s.LoadPlaylistFile(ctx, "playlist1")
+	assert.Equal(t, "snapshot1", result.SnapshotID)
+}
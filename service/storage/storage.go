@@ -1,22 +1,49 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/reeves122/spotify-automation-go/service"
 	log "github.com/sirupsen/logrus"
 	"github.com/zmb3/spotify/v2"
 	"golang.org/x/oauth2"
 )
 
+// playlistsDir is the directory within the cache dir holding the cached playlists,
+// in a file per playlist named after its key
+const playlistsDir = "playlists"
+
 type storage struct {
 	cacheDir string
+
+	historyMaxVersions int           // versions kept per playlist, 0 for no limit
+	historyMaxAge      time.Duration // age after which versions are removed, 0 for no limit
+
+	compression string                            // format playlist files are written in
+	compress    func(data []byte) ([]byte, error) // compresses data in that format
+
+	tokenSecret []byte // passphrase or key the auth token is encrypted with, plaintext if empty
 }
 
-func NewStorage(cacheDir string, relativePath bool) *storage {
+// Option configures the storage
+type Option func(s *storage)
+
+// WithHistoryRetention limits the dated versions kept of each playlist to the given
+// number and age. Zero values disable the limit. The newest version is always kept.
+func WithHistoryRetention(maxVersions int, maxAge time.Duration) Option {
+	return func(s *storage) {
+		s.historyMaxVersions = maxVersions
+		s.historyMaxAge = maxAge
+	}
+}
+
+func NewStorage(cacheDir string, relativePath bool, opts ...Option) *storage {
 	if relativePath {
 		cwd, _ := os.Getwd()
 		cacheDir = filepath.Join(cwd, cacheDir)
@@ -24,27 +51,50 @@ func NewStorage(cacheDir string, relativePath bool) *storage {
 
 	createCacheDir(cacheDir)
 
-	return &storage{
-		cacheDir: cacheDir,
+	s := &storage{
+		cacheDir:    cacheDir,
+		compression: CompressionNone,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	compress, err := newCompressor(s.compression)
+	if err != nil {
+		log.Errorf("Writing the cache uncompressed: %v", err)
+		s.compression = CompressionNone
+		compress, _ = newCompressor(CompressionNone)
 	}
+	s.compress = compress
+	return s
 }
 
 func createCacheDir(cacheDir string) {
 	_ = os.Mkdir(cacheDir, 0770)
 }
 
-// LoadToken loads the auth token from JSON file and parses it
-func (s *storage) LoadToken(fileName string) (*oauth2.Token, error) {
+// LoadToken loads the auth token from JSON file and parses it. Encrypted files are
+// decrypted with the configured secret.
+func (s *storage) LoadToken(ctx context.Context, fileName string) (*oauth2.Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	fileName = filepath.Join(s.cacheDir, fileName)
 	log.Infof("Loading auth token from file: %s", fileName)
 
-	rawFile, err := os.Open(fileName)
+	bytes, err := os.ReadFile(fileName)
 	if err != nil {
 		return nil, err
 	}
-	defer closeFile(rawFile)
 
-	bytes, _ := ioutil.ReadAll(rawFile)
+	if isEncryptedToken(bytes) {
+		bytes, err = decryptToken(s.tokenSecret, bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+	} else if len(s.tokenSecret) > 0 {
+		log.Infof("Auth token file is not encrypted, it will be encrypted when next saved")
+	}
 
 	var token oauth2.Token
 	err = json.Unmarshal(bytes, &token)
@@ -55,58 +105,264 @@ func (s *storage) LoadToken(fileName string) (*oauth2.Token, error) {
 	return &token, nil
 }
 
-// SaveToken saves the auth token to JSON file
-func (s *storage) SaveToken(token *oauth2.Token, fileName string) error {
+// SaveToken saves the auth token to JSON file, readable only by the owner, and
+// encrypts it if a secret is configured
+func (s *storage) SaveToken(ctx context.Context, token *oauth2.Token, fileName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	jsonData, _ := json.MarshalIndent(token, "", " ")
+	if len(s.tokenSecret) > 0 {
+		var err error
+		jsonData, err = encryptToken(s.tokenSecret, jsonData)
+		if err != nil {
+			return err
+		}
+	}
 	fileName = filepath.Join(s.cacheDir, fileName)
 	log.Debugf("Saving auth token to file: %s", fileName)
-	err := os.WriteFile(fileName, jsonData, 0644)
+	err := writeFileAtomic(fileName, jsonData, 0600)
 	return err
 }
 
+// LoadCodeVerifier loads the PKCE code verifier of an authorization started by an
+// earlier run, saved next to the auth token file. Returns an empty string if there is none.
+func (s *storage) LoadCodeVerifier(ctx context.Context, tokenFile string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	fileName := s.getCodeVerifierFilename(tokenFile)
+	bytes, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if isEncryptedToken(bytes) {
+		bytes, err = decryptToken(s.tokenSecret, bytes)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", fileName, err)
+		}
+	}
+	return string(bytes), nil
+}
+
+// SaveCodeVerifier saves the PKCE code verifier of an authorization in progress next
+// to the auth token file, protected like the token. An empty verifier removes the file.
+func (s *storage) SaveCodeVerifier(ctx context.Context, verifier string, tokenFile string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fileName := s.getCodeVerifierFilename(tokenFile)
+	if verifier == "" {
+		err := os.Remove(fileName)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	data := []byte(verifier)
+	if len(s.tokenSecret) > 0 {
+		var err error
+		data, err = encryptToken(s.tokenSecret, data)
+		if err != nil {
+			return err
+		}
+	}
+	log.Debugf("Saving code verifier to file: %s", fileName)
+	return writeFileAtomic(fileName, data, 0600)
+}
+
+// getCodeVerifierFilename returns the full path to the code verifier file of a token file
+func (s *storage) getCodeVerifierFilename(tokenFile string) string {
+	return filepath.Join(s.cacheDir, tokenFile+".verifier")
+}
+
 // LoadTracksFile loads the playlist tracks from JSON file
-func (s *storage) LoadTracksFile(playlistName string) ([]spotify.PlaylistTrack, error) {
-	fileName := s.getPlaylistFilename(playlistName)
-	log.Debugf("Loading playlist %s from file: %s", playlistName, fileName)
+func (s *storage) LoadTracksFile(ctx context.Context, playlistID spotify.ID) ([]spotify.PlaylistTrack, error) {
+	playlist, err := s.LoadPlaylistFile(ctx, string(playlistID))
+	if err != nil {
+		return nil, err
+	}
+	if playlist == nil {
+		return nil, nil
+	}
+	return playlist.Tracks, nil
+}
 
-	var tracks []spotify.PlaylistTrack
+// SaveTracksFile saves the playlist tracks to JSON file, without any playlist metadata
+func (s *storage) SaveTracksFile(ctx context.Context, playlistID spotify.ID, tracks []spotify.PlaylistTrack) error {
+	return s.SavePlaylistFile(ctx, &service.CachedPlaylist{
+		ID:     playlistID,
+		Tracks: tracks,
+	})
+}
 
-	rawFile, err := os.Open(fileName)
-	if _, ok := err.(*os.PathError); ok {
-		return tracks, nil
+// LoadPlaylistFile loads the cached playlist with the given key from JSON file.
+// Returns nil if the playlist has not been cached. Files written before metadata
+// was cached, which only hold the list of tracks, are loaded without metadata.
+func (s *storage) LoadPlaylistFile(ctx context.Context, key string) (*service.CachedPlaylist, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fileName := s.getPlaylistFilename(key)
+	log.Debugf("Loading playlist %s from file: %s", key, fileName)
+
+	bytes, err := readCacheFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	defer closeFile(rawFile)
 
-	bytes, _ := ioutil.ReadAll(rawFile)
+	playlist, err := parsePlaylist(bytes, "")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Loaded %d cached tracks for playlist: %s", len(playlist.Tracks), playlist.Name)
+	return playlist, nil
+}
 
-	err = json.Unmarshal(bytes, &tracks)
+// FindPlaylistFiles loads every cached playlist with the given name or key
+func (s *storage) FindPlaylistFiles(ctx context.Context, name string) ([]*service.CachedPlaylist, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	keys, err := s.playlistKeys()
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debugf("Loaded %d cached tracks for playlist: %s", len(tracks), playlistName)
-	return tracks, nil
+	var playlists []*service.CachedPlaylist
+	for _, key := range keys {
+		header, err := readPlaylistHeader(s.getPlaylistFilename(key), "")
+		if err != nil {
+			return nil, err
+		}
+		if key != name && header.Name != name {
+			continue
+		}
+		playlist, err := s.LoadPlaylistFile(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, nil
 }
 
-// SaveTracksFile saves the playlist tracks to JSON file
-func (s *storage) SaveTracksFile(playlistName string, tracks []spotify.PlaylistTrack) error {
-	jsonData, _ := json.MarshalIndent(tracks, "", " ")
-	fileName := s.getPlaylistFilename(playlistName)
-	log.Debugf("Saving playlist %s to file %s with %d tracks", playlistName, fileName, len(tracks))
+// playlistKeys returns the key of every cached playlist
+func (s *storage) playlistKeys() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.cacheDir, playlistsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	err := os.WriteFile(fileName, jsonData, 0644)
-	return err
+	var keys []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			keys = append(keys, strings.TrimSuffix(entry.Name(), ".json"))
+		}
+	}
+	return keys, nil
+}
+
+// readPlaylistHeader reads the metadata of a playlist file without its tracks.
+// Files which only hold the list of tracks are given the fallback name.
+func readPlaylistHeader(fileName string, fallbackName string) (*service.CachedPlaylist, error) {
+	reader, err := openCacheFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = reader.Close() }()
+
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token == json.Delim('[') {
+		return &service.CachedPlaylist{Name: fallbackName}, nil
+	}
+	if token != json.Delim('{') {
+		return nil, fmt.Errorf("%s does not hold a playlist", fileName)
+	}
+
+	// the fields are copied into an object which is decoded without the tracks
+	fields := map[string]json.RawMessage{}
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		err = decoder.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+		if key, _ := token.(string); key != "tracks" {
+			fields[key] = value
+		}
+	}
+	header, _ := json.Marshal(fields)
+
+	var playlist service.CachedPlaylist
+	err = json.Unmarshal(header, &playlist)
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+// parsePlaylist parses a playlist file, which may only hold the list of tracks
+func parsePlaylist(bytes []byte, playlistName string) (*service.CachedPlaylist, error) {
+	var playlist service.CachedPlaylist
+	var err error
+	if trimmed := strings.TrimSpace(string(bytes)); strings.HasPrefix(trimmed, "[") {
+		playlist.Name = playlistName
+		err = json.Unmarshal(bytes, &playlist.Tracks)
+	} else {
+		err = json.Unmarshal(bytes, &playlist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+// SavePlaylistFile saves the playlist tracks and metadata to the JSON file of its key,
+// and keeps a dated version of it in the playlist's history. Once cancelled, no
+// further files are written, but a write in progress is completed.
+func (s *storage) SavePlaylistFile(ctx context.Context, playlist *service.CachedPlaylist) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	jsonData, _ := json.MarshalIndent(playlist, "", " ")
+	fileName := s.getPlaylistFilename(playlist.Key())
+	log.Debugf("Saving playlist %s to file %s with %d tracks", playlist.Name, fileName, len(playlist.Tracks))
+
+	err := os.MkdirAll(filepath.Dir(fileName), 0770)
+	if err != nil {
+		return err
+	}
+	err = s.writeCacheFile(fileName, jsonData)
+	if err != nil {
+		return err
+	}
+	return s.savePlaylistVersion(playlist, jsonData)
 }
 
-// getPlaylistFilename returns the full path to a playlist file
-func (s *storage) getPlaylistFilename(playlistName string) string {
-	playlistName = strings.ReplaceAll(playlistName, "/", "-")
-	playlistName = strings.ReplaceAll(playlistName, "\\", "-")
-	playlistName = strings.ReplaceAll(playlistName, ".", "-")
-	return filepath.Join(s.cacheDir, playlistName+".json")
+// getPlaylistFilename returns the full path to the file of a playlist key
+func (s *storage) getPlaylistFilename(key string) string {
+	return filepath.Join(s.cacheDir, playlistsDir, key+".json")
 }
 
 // closeFile closes an open file and checks for error
@@ -1,11 +1,15 @@
 package storage
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/reeves122/spotify-automation-go/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/zmb3/spotify/v2"
 	"golang.org/x/oauth2"
@@ -43,7 +47,7 @@ func cleanUp(cacheDir string) {
 func Test_SaveTracksFile_RelativePath(t *testing.T) {
 	defer cleanUp("test")
 	s := NewStorage("test", true)
-	err := s.SaveTracksFile("test playlist", testTracks)
+	err := s.SaveTracksFile(context.Background(), "test playlist", testTracks)
 	assert.Nil(t, err)
 }
 
@@ -51,112 +55,301 @@ func Test_SaveTracksFile_AbsolutePath(t *testing.T) {
 	path := filepath.Join(os.TempDir(), "test")
 	defer cleanUp(path)
 	s := NewStorage(path, false)
-	err := s.SaveTracksFile("test playlist", testTracks)
+	err := s.SaveTracksFile(context.Background(), "test playlist", testTracks)
 	assert.Nil(t, err)
 }
 
-func Test_SaveTracksFile_With_Special_Characters(t *testing.T) {
+// Test_SavePlaylistFile_With_Special_Characters tests that playlists without an ID,
+// whose names only differ in characters which are not allowed in file names, are
+// cached separately
+func Test_SavePlaylistFile_With_Special_Characters(t *testing.T) {
 	path := filepath.Join(os.TempDir(), "test")
 	defer cleanUp(path)
 	s := NewStorage(path, false)
+	names := []string{"test / playlist", "test \\ playlist", "test . playlist", "test - playlist"}
 
-	// Test forward slash
-	err := s.SaveTracksFile("test / playlist", testTracks)
-	assert.Nil(t, err)
-
-	// Test backslash
-	err = s.SaveTracksFile("test \\ playlist", testTracks)
-	assert.Nil(t, err)
+	for i, name := range names {
+		err := s.SavePlaylistFile(context.Background(), &service.CachedPlaylist{Name: name, Tracks: testTracks[:i%2]})
+		assert.Nil(t, err)
+	}
 
-	// Test period
-	err = s.SaveTracksFile("test . playlist", testTracks)
-	assert.Nil(t, err)
+	for i, name := range names {
+		result, err := s.LoadPlaylistFile(context.Background(), service.PlaylistKey("", name))
+		assert.Nil(t, err)
+		assert.Equal(t, name, result.Name)
+		assert.Len(t, result.Tracks, i%2)
+	}
 }
 
 func Test_LoadTracksFile(t *testing.T) {
 	defer cleanUp("test")
 	s := NewStorage("test", true)
-	err := s.SaveTracksFile("test playlist", testTracks)
+	err := s.SaveTracksFile(context.Background(), "test playlist", testTracks)
 	assert.Nil(t, err)
 
-	result, err := s.LoadTracksFile("test playlist")
+	result, err := s.LoadTracksFile(context.Background(), "test playlist")
 	assert.Nil(t, err)
 	assert.Equal(t, testTracks, result)
 }
 
-func Test_LoadTracksFile_With_Special_Characters(t *testing.T) {
+func Test_GetPlaylistFilename(t *testing.T) {
 	defer cleanUp("test")
 	s := NewStorage("test", true)
+	cwd, _ := os.Getwd()
+	result := s.getPlaylistFilename("foo")
+	assert.Equal(t, filepath.Join(cwd, "test", "playlists", "foo.json"), result)
+}
 
-	// Test forward slash
-	err := s.SaveTracksFile("test / playlist", testTracks)
-	assert.Nil(t, err)
+func Test_GetPlaylistFilename_Without_ID(t *testing.T) {
+	defer cleanUp("test")
+	s := NewStorage("test", true)
+	cwd, _ := os.Getwd()
+	result := s.getPlaylistFilename(service.PlaylistKey("", "test / playlist"))
+	assert.Equal(t, filepath.Join(cwd, "test", "playlists", "name-dGVzdCAvIHBsYXlsaXN0.json"), result)
+}
 
-	result, err := s.LoadTracksFile("test / playlist")
-	assert.Nil(t, err)
-	assert.Equal(t, testTracks, result)
+func Test_SaveToken(t *testing.T) {
+	defer cleanUp("test")
+	s := NewStorage("test", true)
+	assert.NoError(t, s.SaveToken(context.Background(), testToken, "test.json"))
+}
 
-	// Test backslash
-	err = s.SaveTracksFile("test \\ playlist", testTracks)
-	assert.Nil(t, err)
+// Test_SaveToken_Replace tests that a token file is replaced without leaving temp files
+func Test_SaveToken_Replace(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	s := NewStorage(cacheDir, false)
 
-	result, err = s.LoadTracksFile("test \\ playlist")
-	assert.Nil(t, err)
-	assert.Equal(t, testTracks, result)
+	_ = os.WriteFile(filepath.Join(cacheDir, "test.json"), []byte(`{"access_token":"old"}`), 0644)
+	assert.NoError(t, s.SaveToken(ctx, testToken, "test.json"))
 
-	// Test period
-	err = s.SaveTracksFile("test . playlist", testTracks)
-	assert.Nil(t, err)
+	result, err := s.LoadToken(ctx, "test.json")
+	assert.NoError(t, err)
+	assert.Equal(t, testToken, result)
 
-	result, err = s.LoadTracksFile("test . playlist")
-	assert.Nil(t, err)
-	assert.Equal(t, testTracks, result)
+	temp, _ := filepath.Glob(filepath.Join(cacheDir, "*.tmp"))
+	assert.Empty(t, temp)
 }
 
-func Test_GetPlaylistFilename(t *testing.T) {
+func Test_LoadToken(t *testing.T) {
 	defer cleanUp("test")
 	s := NewStorage("test", true)
-	playlist := "foo"
-	cwd, _ := os.Getwd()
-	result := s.getPlaylistFilename(playlist)
-	assert.Equal(t, filepath.Join(cwd, "test", "foo.json"), result)
+
+	_ = s.SaveToken(context.Background(), testToken, "test.json")
+	result, err := s.LoadToken(context.Background(), "test.json")
+	assert.NoError(t, err)
+	assert.Equal(t, testToken, result)
+}
+
+func Test_SaveCodeVerifier(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	s := NewStorage(cacheDir, false, WithTokenSecret([]byte("passphrase")))
+
+	result, err := s.LoadCodeVerifier(ctx, "test.json")
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+
+	assert.NoError(t, s.SaveCodeVerifier(ctx, "verifier", "test.json"))
+	data, _ := os.ReadFile(filepath.Join(cacheDir, "test.json.verifier"))
+	assert.True(t, isEncryptedToken(data))
+	info, _ := os.Stat(filepath.Join(cacheDir, "test.json.verifier"))
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	result, err = s.LoadCodeVerifier(ctx, "test.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "verifier", result)
+
+	assert.NoError(t, s.SaveCodeVerifier(ctx, "", "test.json"))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "test.json.verifier"))
+	assert.NoError(t, s.SaveCodeVerifier(ctx, "", "test.json"))
 }
 
-func Test_GetPlaylistFilename_With_Special_Characters(t *testing.T) {
+func Test_SavePlaylistFile(t *testing.T) {
 	defer cleanUp("test")
 	s := NewStorage("test", true)
+	playlist := &service.CachedPlaylist{
+		ID:         "playlist1",
+		Name:       "test playlist",
+		OwnerID:    "user1",
+		SnapshotID: "snapshot1",
+		UpdatedAt:  time.Unix(1644696995, 0).UTC(),
+		Tracks:     testTracks,
+	}
+	assert.NoError(t, s.SavePlaylistFile(context.Background(), playlist))
 
-	// Test forward slash
-	playlist := "test / playlist"
-	cwd, _ := os.Getwd()
-	result := s.getPlaylistFilename(playlist)
-	assert.Equal(t, filepath.Join(cwd, "test", "test - playlist.json"), result)
+	result, err := s.LoadPlaylistFile(context.Background(), "playlist1")
+	assert.NoError(t, err)
+	assert.Equal(t, playlist, result)
+
+	tracks, err := s.LoadTracksFile(context.Background(), "playlist1")
+	assert.NoError(t, err)
+	assert.Equal(t, testTracks, tracks)
+}
 
-	// Test backslash
-	playlist = "test \\ playlist"
-	cwd, _ = os.Getwd()
-	result = s.getPlaylistFilename(playlist)
-	assert.Equal(t, filepath.Join(cwd, "test", "test - playlist.json"), result)
+// Test_FindPlaylistFiles tests finding cached playlists by name or key
+func Test_FindPlaylistFiles(t *testing.T) {
+	defer cleanUp("test")
+	s := NewStorage("test", true)
+	ctx := context.Background()
+	_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{ID: "playlist1", Name: "test playlist", Tracks: testTracks})
+	_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{ID: "playlist2", Name: "test playlist"})
+	_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{ID: "playlist3", Name: "other playlist"})
+
+	result, err := s.FindPlaylistFiles(ctx, "test playlist")
+	assert.NoError(t, err)
+	assert.Len(t, result, 2)
+	assert.Equal(t, spotify.ID("playlist1"), result[0].ID)
+	assert.Equal(t, testTracks, result[0].Tracks)
+
+	result, err = s.FindPlaylistFiles(ctx, "playlist3")
+	assert.NoError(t, err)
+	assert.Len(t, result, 1)
+	assert.Equal(t, "other playlist", result[0].Name)
 
-	// Test period
-	playlist = "test . playlist"
-	cwd, _ = os.Getwd()
-	result = s.getPlaylistFilename(playlist)
-	assert.Equal(t, filepath.Join(cwd, "test", "test - playlist.json"), result)
+	result, err = s.FindPlaylistFiles(ctx, "missing playlist")
+	assert.NoError(t, err)
+	assert.Empty(t, result)
 }
 
-func Test_SaveToken(t *testing.T) {
+func Test_LoadPlaylistFile_Missing(t *testing.T) {
 	defer cleanUp("test")
 	s := NewStorage("test", true)
-	assert.NoError(t, s.SaveToken(testToken, "test.json"))
+
+	result, err := s.LoadPlaylistFile(context.Background(), "missing playlist")
+	assert.NoError(t, err)
+	assert.Nil(t, result)
 }
 
-func Test_LoadToken(t *testing.T) {
+// Test_SavePlaylistFile_Cancelled tests that no file is written once the context is cancelled
+func Test_SavePlaylistFile_Cancelled(t *testing.T) {
 	defer cleanUp("test")
 	s := NewStorage("test", true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.SaveTracksFile(ctx, "test playlist", testTracks)
+	assert.ErrorIs(t, err, context.Canceled)
 
-	_ = s.SaveToken(testToken, "test.json")
-	result, err := s.LoadToken("test.json")
+	_, err = os.Stat(s.getPlaylistFilename("test playlist"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_SavePlaylistFile_History(t *testing.T) {
+	defer cleanUp("test")
+	s := NewStorage("test", true)
+	ctx := context.Background()
+	start := time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, snapshot := range []string{"snapshot1", "snapshot2", "snapshot2", "snapshot3"} {
+		_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{
+			ID:         "playlist1",
+			Name:       "test playlist",
+			SnapshotID: snapshot,
+			UpdatedAt:  start.Add(time.Duration(i) * time.Hour),
+			Tracks:     testTracks[:i%2],
+		})
+	}
+
+	versions, err := s.ListPlaylistVersions(ctx, "playlist1")
 	assert.NoError(t, err)
-	assert.Equal(t, testToken, result)
+	assert.Equal(t, []service.PlaylistVersion{
+		{UpdatedAt: start, SnapshotID: "snapshot1", Tracks: 0},
+		{UpdatedAt: start.Add(time.Hour), SnapshotID: "snapshot2", Tracks: 1},
+		{UpdatedAt: start.Add(3 * time.Hour), SnapshotID: "snapshot3", Tracks: 1},
+	}, versions)
+}
+
+func Test_LoadPlaylistVersion(t *testing.T) {
+	defer cleanUp("test")
+	s := NewStorage("test", true)
+	ctx := context.Background()
+	start := time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{ID: "playlist1", Name: "test playlist", SnapshotID: "snapshot1", UpdatedAt: start, Tracks: testTracks})
+	_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{ID: "playlist1", Name: "test playlist", SnapshotID: "snapshot2", UpdatedAt: start.Add(time.Hour)})
+
+	result, err := s.LoadPlaylistVersion(ctx, "playlist1", start.Add(30*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot1", result.SnapshotID)
+	assert.Equal(t, testTracks, result.Tracks)
+
+	result, err = s.LoadPlaylistVersion(ctx, "playlist1", start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot2", result.SnapshotID)
+
+	result, err = s.LoadPlaylistVersion(ctx, "playlist1", start.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+// Test_SavePlaylistFile_HistoryRetention tests that old versions are removed, except the newest
+func Test_SavePlaylistFile_HistoryRetention(t *testing.T) {
+	defer cleanUp("test")
+	ctx := context.Background()
+	start := time.Now().Add(-48 * time.Hour).UTC()
+
+	s := NewStorage("test", true, WithHistoryRetention(2, 0))
+	for i := 0; i < 4; i++ {
+		_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{
+			ID:         "playlist1",
+			Name:       "test playlist",
+			SnapshotID: fmt.Sprintf("snapshot%d", i),
+			UpdatedAt:  start.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	versions, _ := s.ListPlaylistVersions(ctx, "playlist1")
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "snapshot2", versions[0].SnapshotID)
+
+	s = NewStorage("test", true, WithHistoryRetention(0, time.Hour))
+	_ = s.SavePlaylistFile(ctx, &service.CachedPlaylist{
+		ID:         "playlist1",
+		Name:       "test playlist",
+		SnapshotID: "snapshot4",
+		UpdatedAt:  start.Add(4 * time.Hour),
+	})
+	versions, _ = s.ListPlaylistVersions(ctx, "playlist1")
+	assert.Len(t, versions, 1)
+	assert.Equal(t, "snapshot4", versions[0].SnapshotID)
+}
+
+// Test_MigrateLegacyPlaylist tests that the cache file of a playlist written by the
+// original version, named after the playlist and only holding its tracks, is moved to
+// the file of its ID and kept as a version
+func Test_MigrateLegacyPlaylist(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	modTime := time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	_ = os.WriteFile(filepath.Join(cacheDir, "auth_token.json"), []byte(`{"access_token":"test1"}`), 0644)
+	legacyFile := filepath.Join(cacheDir, "test - playlist-old.json")
+	jsonData, _ := json.MarshalIndent(testTracks, "", " ")
+	_ = os.WriteFile(legacyFile, jsonData, 0644)
+	_ = os.Chtimes(legacyFile, modTime, modTime)
+	s := NewStorage(cacheDir, false)
+
+	// nothing is migrated until the playlist is synced
+	assert.FileExists(t, legacyFile)
+
+	err := s.MigrateLegacyPlaylist(ctx, "playlist1", "test / playlist.old")
+	assert.NoError(t, err)
+	result, err := s.LoadPlaylistFile(ctx, "playlist1")
+	assert.NoError(t, err)
+	assert.Equal(t, spotify.ID("playlist1"), result.ID)
+	assert.Equal(t, "test / playlist.old", result.Name)
+	assert.Equal(t, "", result.SnapshotID)
+	assert.Equal(t, modTime, result.UpdatedAt)
+	assert.Equal(t, testTracks, result.Tracks)
+	assert.NoFileExists(t, legacyFile)
+
+	versions, _ := s.ListPlaylistVersions(ctx, "playlist1")
+	assert.Equal(t, []service.PlaylistVersion{{UpdatedAt: modTime, Tracks: 1}}, versions)
+
+	// files which are not playlists are left alone
+	err = s.MigrateLegacyPlaylist(ctx, "playlist2", "auth_token")
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(cacheDir, "auth_token.json"))
+	result, _ = s.LoadPlaylistFile(ctx, "playlist2")
+	assert.Nil(t, result)
 }
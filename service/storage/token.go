@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted token files start with a header of tokenMagic, the format version, the
+// salt the key was derived with and the nonce, followed by the sealed token JSON.
+// The header is authenticated along with the token.
+var tokenMagic = []byte("SATK")
+
+const (
+	tokenVersion1  = 1
+	tokenSaltSize  = 16
+	tokenNonceSize = 12
+	tokenKeySize   = 32
+	tokenHeaderLen = 4 + 1 + tokenSaltSize + tokenNonceSize
+)
+
+// ErrTokenKey is returned when an encrypted token file can't be opened with the
+// configured key
+var ErrTokenKey = errors.New("token file can't be decrypted with the configured passphrase or key file")
+
+// WithTokenSecret encrypts auth token files with a key derived from the given
+// passphrase or key file contents. Plaintext token files are still read, and are
+// encrypted when next saved.
+func WithTokenSecret(secret []byte) Option {
+	return func(s *storage) {
+		s.tokenSecret = secret
+	}
+}
+
+// isEncryptedToken reports whether the contents of a token file are encrypted
+func isEncryptedToken(data []byte) bool {
+	return bytes.HasPrefix(data, tokenMagic)
+}
+
+// encryptToken seals token JSON with AES-256-GCM under a key derived from the secret
+func encryptToken(secret []byte, plaintext []byte) ([]byte, error) {
+	header := make([]byte, tokenHeaderLen)
+	copy(header, tokenMagic)
+	header[len(tokenMagic)] = tokenVersion1
+	salt := header[len(tokenMagic)+1 : len(tokenMagic)+1+tokenSaltSize]
+	nonce := header[len(tokenMagic)+1+tokenSaltSize:]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	aead, err := newTokenCipher(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	// the header is authenticated as additional data, so it is sealed into a copy of it
+	return aead.Seal(append([]byte(nil), header...), nonce, plaintext, header), nil
+}
+
+// decryptToken opens an encrypted token file
+func decryptToken(secret []byte, data []byte) ([]byte, error) {
+	if len(data) < tokenHeaderLen {
+		return nil, fmt.Errorf("encrypted token file is truncated")
+	}
+	if version := data[len(tokenMagic)]; version != tokenVersion1 {
+		return nil, fmt.Errorf("encrypted token file has unknown version %d", version)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("token file is encrypted, but no passphrase or key file is configured")
+	}
+
+	header := data[:tokenHeaderLen]
+	salt := header[len(tokenMagic)+1 : len(tokenMagic)+1+tokenSaltSize]
+	nonce := header[len(tokenMagic)+1+tokenSaltSize:]
+	aead, err := newTokenCipher(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, data[tokenHeaderLen:], header)
+	if err != nil {
+		return nil, ErrTokenKey
+	}
+	return plaintext, nil
+}
+
+// newTokenCipher derives the key of a token file from the secret and its salt
+func newTokenCipher(secret []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, 1<<15, 8, 1, tokenKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
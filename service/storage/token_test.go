@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SaveToken_Encrypted(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	s := NewStorage(cacheDir, false, WithTokenSecret([]byte("passphrase")))
+
+	assert.NoError(t, s.SaveToken(ctx, testToken, "token.json"))
+
+	data, _ := os.ReadFile(filepath.Join(cacheDir, "token.json"))
+	assert.True(t, isEncryptedToken(data))
+	assert.NotContains(t, string(data), testToken.RefreshToken)
+	info, _ := os.Stat(filepath.Join(cacheDir, "token.json"))
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	result, err := s.LoadToken(ctx, "token.json")
+	assert.NoError(t, err)
+	assert.Equal(t, testToken, result)
+}
+
+func Test_LoadToken_WrongSecret(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	_ = NewStorage(cacheDir, false, WithTokenSecret([]byte("passphrase"))).SaveToken(ctx, testToken, "token.json")
+
+	_, err := NewStorage(cacheDir, false, WithTokenSecret([]byte("wrong"))).LoadToken(ctx, "token.json")
+	assert.ErrorIs(t, err, ErrTokenKey)
+
+	_, err = NewStorage(cacheDir, false).LoadToken(ctx, "token.json")
+	assert.ErrorContains(t, err, "no passphrase or key file is configured")
+}
+
+// Test_LoadToken_Tampered tests that a change to the header of an encrypted token is detected
+func Test_LoadToken_Tampered(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	s := NewStorage(cacheDir, false, WithTokenSecret([]byte("passphrase")))
+	_ = s.SaveToken(ctx, testToken, "token.json")
+
+	fileName := filepath.Join(cacheDir, "token.json")
+	data, _ := os.ReadFile(fileName)
+	data[len(tokenMagic)+1] ^= 0xff
+	_ = os.WriteFile(fileName, data, 0600)
+
+	_, err := s.LoadToken(ctx, "token.json")
+	assert.ErrorIs(t, err, ErrTokenKey)
+}
+
+// Test_SaveToken_UpgradePlaintext tests that a plaintext token is read and encrypted when saved
+func Test_SaveToken_UpgradePlaintext(t *testing.T) {
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	_ = NewStorage(cacheDir, false).SaveToken(ctx, testToken, "token.json")
+
+	s := NewStorage(cacheDir, false, WithTokenSecret([]byte("passphrase")))
+	token, err := s.LoadToken(ctx, "token.json")
+	assert.NoError(t, err)
+	assert.Equal(t, testToken, token)
+
+	assert.NoError(t, s.SaveToken(ctx, token, "token.json"))
+	data, _ := os.ReadFile(filepath.Join(cacheDir, "token.json"))
+	assert.True(t, isEncryptedToken(data))
+}
@@ -0,0 +1,166 @@
+package util
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/formats"
+	"github.com/reeves122/spotify-automation-go/service/plan"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+)
+
+// ImportReport lists how every row of an imported CSV file was matched
+type ImportReport struct {
+	PlaylistName string
+	Matches      []ImportMatch
+}
+
+// Resolved returns the number of rows matched to a Spotify track
+func (r *ImportReport) Resolved() int {
+	count := 0
+	for _, match := range r.Matches {
+		if match.Resolved {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteCSV writes the report as CSV, with a row for every imported row giving its
+// status, the matching method and confidence and the track it was matched to. The
+// best candidate of unresolved rows is included for review.
+func (r *ImportReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"line", "title", "artist", "album", "isrc", "duration", "status", "method",
+		"confidence", "track_id", "matched_title", "matched_artist"})
+
+	for _, match := range r.Matches {
+		status := "unresolved"
+		if match.Resolved {
+			status = "matched"
+		}
+		row := []string{strconv.Itoa(match.Row.Line), match.Row.Title, match.Row.Artist, match.Row.Album,
+			match.Row.ISRC, formats.FormatDuration(match.Row.Duration), status, match.Method,
+			fmt.Sprintf("%.2f", match.Confidence), "", "", ""}
+		if match.Track != nil {
+			row[9], row[10], row[11] = string(match.Track.ID), match.Track.Name, service.PrimaryArtist(*match.Track)
+		}
+		_ = writer.Write(row)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// ImportCSVPlaylist matches every row of a CSV file to a Spotify track and adds the
+// matched tracks, in file order, to the user's playlist with the given name (the
+// file name if empty). Tracks already in the playlist are not added again. If the
+// user has no such playlist, a new one is created. Rows are matched when their
+// confidence is at least minConfidence. The report is returned along with any
+// error once the rows are being matched, holding the rows matched so far.
+func (u *util) ImportCSVPlaylist(ctx context.Context, playlists []spotify.SimplePlaylist, username string,
+	fileName string, name string, minConfidence float64) (*ImportReport, error) {
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := formats.ReadCSV(file)
+	_ = file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to import %s: %w", fileName, err)
+	}
+
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	}
+	report := &ImportReport{PlaylistName: name}
+
+	log.Infof("Matching %d tracks from file %s to Spotify", len(rows), fileName)
+	for _, row := range rows {
+		match, err := u.matchRow(ctx, row, minConfidence)
+		if err != nil {
+			return report, err
+		}
+		if !match.Resolved {
+			log.WithFields(log.Fields{
+				"line":   row.Line,
+				"title":  row.Title,
+				"artist": row.Artist}).
+				Warning("Unable to match track")
+		}
+		report.Matches = append(report.Matches, match)
+	}
+	log.Infof("Matched %d of %d tracks", report.Resolved(), len(rows))
+	if report.Resolved() == 0 {
+		return report, fmt.Errorf("none of the tracks in %s could be matched", fileName)
+	}
+
+	var actions []plan.Action
+	existing := map[spotify.ID]bool{}
+	var target *spotify.SimplePlaylist
+	for i, playlist := range playlists {
+		if playlist.Name == name && playlist.Owner.ID == username {
+			target = &playlists[i]
+			break
+		}
+	}
+
+	if target != nil {
+		tracks, err := u.spotify.GetAllPlaylistTracks(ctx, target.ID)
+		if err != nil {
+			return report, err
+		}
+		for _, track := range tracks {
+			existing[track.Track.ID] = true
+		}
+	} else {
+		actions = append(actions, plan.Action{
+			Type:         plan.ActionCreatePlaylist,
+			PlaylistName: name,
+			Rule:         plan.RuleImport,
+			Reason:       "import of " + fileName,
+		})
+		target = &spotify.SimplePlaylist{Name: name}
+	}
+
+	added := 0
+	for _, match := range report.Matches {
+		if !match.Resolved || existing[match.Track.ID] {
+			continue
+		}
+		existing[match.Track.ID] = true
+		position := added
+		added++
+		actions = append(actions, plan.Action{
+			Type:         plan.ActionAdd,
+			PlaylistID:   target.ID,
+			PlaylistName: target.Name,
+			TrackID:      match.Track.ID,
+			TrackName:    match.Track.Name,
+			Artist:       service.PrimaryArtist(*match.Track),
+			Position:     &position,
+			Rule:         plan.RuleImport,
+			Reason: fmt.Sprintf("line %d of %s matched by %s with %.0f%% confidence",
+				match.Row.Line, filepath.Base(fileName), match.Method, match.Confidence*100),
+		})
+	}
+
+	for _, action := range actions {
+		u.plan.Add(action)
+	}
+
+	if u.dryRun {
+		log.Infof("Dry run: not importing playlist %s", name)
+		return report, nil
+	}
+	return report, u.applyActions(ctx, actions)
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Test_ImportCSVPlaylist_MatchError tests that the rows matched before a failed
+// search are still reported
+func Test_ImportCSVPlaylist_MatchError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	u := NewUtil(mockWrapper, nil, "disliked_", " Queue", false, false, 1)
+
+	fileName := filepath.Join(t.TempDir(), "Spreadsheet.csv")
+	_ = os.WriteFile(fileName, []byte("Track Name,Artist Name,ISRC\nSong,Artist,USABC2200001\nOther,Artist,USABC2200002\n"), 0644)
+
+	gomock.InOrder(
+		mockWrapper.EXPECT().SearchTracks(gomock.Any(), "isrc:USABC2200001", searchLimit).Return([]spotify.FullTrack{
+			newTestTrack("a", "Song", "Artist", 200000).Track,
+		}, nil),
+		mockWrapper.EXPECT().SearchTracks(gomock.Any(), "isrc:USABC2200002", searchLimit).Return(nil, fmt.Errorf("test error")),
+	)
+
+	report, err := u.ImportCSVPlaylist(context.Background(), nil, "user1", fileName, "", 0.8)
+	assert.EqualError(t, err, "test error")
+	assert.NotNil(t, report)
+	assert.Len(t, report.Matches, 1)
+	assert.Equal(t, spotify.ID("a"), report.Matches[0].Track.ID)
+}
+
+// Test_ImportCSVPlaylist_NoResults tests that a row without any search results is not
+// matched, even with no minimum confidence
+func Test_ImportCSVPlaylist_NoResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	u := NewUtil(mockWrapper, nil, "disliked_", " Queue", false, false, 1)
+
+	fileName := filepath.Join(t.TempDir(), "Spreadsheet.csv")
+	_ = os.WriteFile(fileName, []byte("Track Name,Artist Name\nSong,Artist\n"), 0644)
+	mockWrapper.EXPECT().SearchTracks(gomock.Any(), gomock.Any(), searchLimit).Return(nil, nil).Times(2)
+
+	report, err := u.ImportCSVPlaylist(context.Background(), nil, "user1", fileName, "", 0)
+	assert.EqualError(t, err, "none of the tracks in "+fileName+" could be matched")
+	assert.Len(t, report.Matches, 1)
+	assert.False(t, report.Matches[0].Resolved)
+	assert.Nil(t, report.Matches[0].Track)
+}
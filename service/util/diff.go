@@ -0,0 +1,67 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/diff"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+)
+
+// DiffPlaylist compares the cached playlist as of from, or the current cache if from
+// is zero, with the cached playlist as of to. If to is zero, it is compared with the
+// live playlist found in playlists instead.
+func (u *util) DiffPlaylist(ctx context.Context, playlists []spotify.SimplePlaylist, name string,
+	from time.Time, to time.Time) (*diff.Diff, error) {
+
+	cached, err := u.loadCachedPlaylist(ctx, playlists, name)
+	if err != nil {
+		return nil, err
+	}
+	oldPlaylist, err := u.loadPlaylistAsOf(ctx, cached, from)
+	if err != nil {
+		return nil, err
+	}
+	fromVersion := diff.Version{Source: diff.SourceCache, SnapshotID: oldPlaylist.SnapshotID, UpdatedAt: oldPlaylist.UpdatedAt}
+
+	if !to.IsZero() {
+		newPlaylist, err := u.loadPlaylistAsOf(ctx, cached, to)
+		if err != nil {
+			return nil, err
+		}
+		toVersion := diff.Version{Source: diff.SourceCache, SnapshotID: newPlaylist.SnapshotID, UpdatedAt: newPlaylist.UpdatedAt}
+		return diff.Compare(name, fromVersion, toVersion, oldPlaylist.Tracks, newPlaylist.Tracks), nil
+	}
+
+	live := findCachedPlaylist(playlists, oldPlaylist)
+	if live == nil {
+		return nil, fmt.Errorf("playlist %s no longer exists", name)
+	}
+	log.Infof("Getting list of tracks for playlist: %s", live.Name)
+	tracks, err := u.spotify.GetAllPlaylistTracks(ctx, live.ID)
+	if err != nil {
+		return nil, err
+	}
+	toVersion := diff.Version{Source: diff.SourceLive, SnapshotID: live.SnapshotID}
+	return diff.Compare(name, fromVersion, toVersion, oldPlaylist.Tracks, tracks), nil
+}
+
+// loadPlaylistAsOf loads the version of a cached playlist at the given time, or the
+// current cache if the time is zero
+func (u *util) loadPlaylistAsOf(ctx context.Context, cached *service.CachedPlaylist, at time.Time) (*service.CachedPlaylist, error) {
+	if at.IsZero() {
+		return cached, nil
+	}
+
+	version, err := u.storage.LoadPlaylistVersion(ctx, cached.Key(), at)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, fmt.Errorf("playlist %s has no cached version at %s", cached.Name, at.Format(time.RFC3339))
+	}
+	return version, nil
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/diff"
+	"github.com/reeves122/spotify-automation-go/service/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Test_DiffPlaylist_Live tests comparing the current cache with the live playlist
+func Test_DiffPlaylist_Live(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+
+	playlist := testPlaylist
+	playlist.SnapshotID = "snapshot1"
+	_ = s.SavePlaylistFile(context.Background(), service.NewCachedPlaylist(playlist, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("b", "Other", "Artist", 200000),
+	}))
+
+	playlist.SnapshotID = "snapshot2"
+	mockWrapper.EXPECT().GetAllPlaylistTracks(gomock.Any(), playlist.ID).Return([]spotify.PlaylistTrack{
+		newTestTrack("b", "Other", "Artist", 200000),
+		newTestTrack("c", "New", "Artist", 200000),
+	}, nil)
+
+	result, err := u.DiffPlaylist(context.Background(), []spotify.SimplePlaylist{playlist}, playlist.Name, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot1", result.From.SnapshotID)
+	assert.Equal(t, diff.Version{Source: diff.SourceLive, SnapshotID: "snapshot2"}, result.To)
+	assert.Equal(t, 1, result.Count(diff.ChangeAdded))
+	assert.Equal(t, 1, result.Count(diff.ChangeRemoved))
+}
+
+// Test_DiffPlaylist_Versions tests comparing two cached versions
+func Test_DiffPlaylist_Versions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+
+	start := time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)
+	_ = s.SavePlaylistFile(context.Background(), &service.CachedPlaylist{
+		Name: testPlaylist.Name, SnapshotID: "snapshot1", UpdatedAt: start,
+		Tracks: []spotify.PlaylistTrack{newTestTrack("a", "Song", "Artist", 200000)},
+	})
+	_ = s.SavePlaylistFile(context.Background(), &service.CachedPlaylist{
+		Name: testPlaylist.Name, SnapshotID: "snapshot2", UpdatedAt: start.Add(time.Hour),
+	})
+
+	result, err := u.DiffPlaylist(context.Background(), nil, testPlaylist.Name, start, start.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Count(diff.ChangeRemoved))
+
+	_, err = u.DiffPlaylist(context.Background(), nil, testPlaylist.Name, start.Add(-time.Hour), start)
+	assert.EqualError(t, err, "playlist test playlist has no cached version at 2022-02-01T11:00:00Z")
+}
@@ -0,0 +1,266 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/plan"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+)
+
+// durationTolerance is the maximum difference in milliseconds between two tracks
+// for them to still be considered the same recording
+const durationTolerance = 3000
+
+// titleNoise matches the parts of a track title which commonly differ between
+// releases of the same recording, ex: "(feat. Someone)" or " - Remastered 2011"
+var titleNoise = regexp.MustCompile(`(?i)\s*[(\[][^)\]]*\b(feat\.?|ft\.?|featuring|with|remaster(ed)?|mix|edit|version|mono|stereo|live|explicit|clean)\b[^)\]]*[)\]]|\s+-\s+.*\b(remaster(ed)?|version|edit|mix|mono|stereo|live)\b.*$`)
+
+// DuplicateTrack is a single occurrence of a track within a playlist
+type DuplicateTrack struct {
+	Position int        `json:"position"`
+	ID       spotify.ID `json:"id"`
+	Name     string     `json:"name"`
+	Artist   string     `json:"artist"`
+	Album    string     `json:"album"`
+	Duration int        `json:"duration_ms"`
+}
+
+// DuplicateGroup is a set of tracks in a playlist considered to be the same song
+type DuplicateGroup struct {
+	Tracks []DuplicateTrack `json:"tracks"`
+}
+
+// DuplicateReport lists the duplicates found in a single playlist. Exact groups
+// are repeated occurrences of the same track ID, probable groups are tracks with
+// different IDs but matching title, primary artist and duration.
+type DuplicateReport struct {
+	PlaylistID   spotify.ID       `json:"playlist_id"`
+	PlaylistName string           `json:"playlist_name"`
+	SnapshotID   string           `json:"snapshot_id"`
+	Exact        []DuplicateGroup `json:"exact"`
+	Probable     []DuplicateGroup `json:"probable"`
+}
+
+// FindPossibleDuplicateTracks finds tracks which are duplicated in a playlist and,
+// if removal is enabled, removes the extra copies from playlists owned by the user
+func (u *util) FindPossibleDuplicateTracks(ctx context.Context, playlists []spotify.SimplePlaylist, username string) ([]DuplicateReport, error) {
+	log.Info("Scanning playlists for duplicate tracks")
+
+	var reports []DuplicateReport
+	for _, playlist := range playlists {
+		cached, err := u.storage.LoadPlaylistFile(ctx, service.PlaylistKey(playlist.ID, playlist.Name))
+		if err != nil {
+			return nil, err
+		}
+		if cached == nil {
+			continue
+		}
+
+		report := findDuplicates(playlist, cached.Tracks)
+		report.SnapshotID = cached.SnapshotID
+		if len(report.Exact) == 0 && len(report.Probable) == 0 {
+			continue
+		}
+		logDuplicateReport(report)
+		reports = append(reports, report)
+
+		if !u.removeDuplicates || playlist.Owner.ID != username {
+			continue
+		}
+
+		err = u.removeDuplicateTracks(ctx, playlist, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("Found duplicate tracks in %d playlists", len(reports))
+	return reports, nil
+}
+
+// removeDuplicateTracks removes every copy of a duplicated song except its first
+// occurrence in the playlist. Copies are removed by position, guarded by the snapshot
+// of the playlist the duplicates were found in.
+func (u *util) removeDuplicateTracks(ctx context.Context, playlist spotify.SimplePlaylist, report DuplicateReport) error {
+	var actions []plan.Action
+	add := func(kind string, group DuplicateGroup) {
+		kept := group.Tracks[0]
+		reason := fmt.Sprintf("%s duplicate of %s at position %d", kind, kept.ID, kept.Position)
+		for _, track := range group.Tracks[1:] {
+			position := track.Position
+			actions = append(actions, plan.Action{
+				Type:         plan.ActionRemove,
+				PlaylistID:   playlist.ID,
+				PlaylistName: playlist.Name,
+				TrackID:      track.ID,
+				TrackName:    track.Name,
+				Artist:       track.Artist,
+				Position:     &position,
+				SnapshotID:   report.SnapshotID,
+				Rule:         plan.RuleDuplicate,
+				Reason:       reason,
+			})
+		}
+	}
+
+	// Later copies of the probable duplicates' own IDs are in the exact groups
+	for _, group := range report.Exact {
+		add("exact", group)
+	}
+	for _, group := range report.Probable {
+		add("probable", group)
+	}
+
+	return u.removeTracks(ctx, actions)
+}
+
+// findDuplicates groups the tracks of a playlist by normalized title, primary artist
+// and duration and returns the groups which contain more than one track
+func findDuplicates(playlist spotify.SimplePlaylist, tracks []spotify.PlaylistTrack) DuplicateReport {
+	report := DuplicateReport{
+		PlaylistID:   playlist.ID,
+		PlaylistName: playlist.Name,
+	}
+
+	buckets := map[string][]DuplicateTrack{}
+	var keys []string
+	for position, track := range tracks {
+		if track.Track.ID == "" {
+			continue
+		}
+		key := normalizeTitle(track.Track.Name) + "|" + normalizeTitle(service.PrimaryArtist(track.Track))
+		if _, present := buckets[key]; !present {
+			keys = append(keys, key)
+		}
+		buckets[key] = append(buckets[key], DuplicateTrack{
+			Position: position,
+			ID:       track.Track.ID,
+			Name:     track.Track.Name,
+			Artist:   service.PrimaryArtist(track.Track),
+			Album:    track.Track.Album.Name,
+			Duration: track.Track.Duration,
+		})
+	}
+
+	for _, key := range keys {
+		for _, cluster := range clusterByDuration(buckets[key]) {
+			exact, probable := splitCluster(cluster)
+			report.Exact = append(report.Exact, exact...)
+			if probable != nil {
+				report.Probable = append(report.Probable, *probable)
+			}
+		}
+	}
+	return report
+}
+
+// clusterByDuration splits tracks into clusters whose durations are all within
+// durationTolerance of the shortest track in the cluster. Clusters keep playlist order.
+func clusterByDuration(tracks []DuplicateTrack) [][]DuplicateTrack {
+	if len(tracks) < 2 {
+		return nil
+	}
+
+	sorted := make([]DuplicateTrack, len(tracks))
+	copy(sorted, tracks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Duration < sorted[j].Duration
+	})
+
+	var clusters [][]DuplicateTrack
+	start := 0
+	for i := 1; i <= len(sorted); i++ {
+		if i < len(sorted) && sorted[i].Duration-sorted[start].Duration <= durationTolerance {
+			continue
+		}
+		if i-start > 1 {
+			cluster := append([]DuplicateTrack{}, sorted[start:i]...)
+			sort.Slice(cluster, func(a, b int) bool {
+				return cluster[a].Position < cluster[b].Position
+			})
+			clusters = append(clusters, cluster)
+		}
+		start = i
+	}
+	return clusters
+}
+
+// splitCluster returns a group for every track ID repeated within the cluster and,
+// when the cluster holds more than one distinct ID, a probable group with the first
+// occurrence of each ID
+func splitCluster(cluster []DuplicateTrack) ([]DuplicateGroup, *DuplicateGroup) {
+	byID := map[spotify.ID][]DuplicateTrack{}
+	var ids []spotify.ID
+	for _, track := range cluster {
+		if _, present := byID[track.ID]; !present {
+			ids = append(ids, track.ID)
+		}
+		byID[track.ID] = append(byID[track.ID], track)
+	}
+
+	var exact []DuplicateGroup
+	var first []DuplicateTrack
+	for _, id := range ids {
+		if len(byID[id]) > 1 {
+			exact = append(exact, DuplicateGroup{Tracks: byID[id]})
+		}
+		first = append(first, byID[id][0])
+	}
+
+	if len(first) < 2 {
+		return exact, nil
+	}
+	return exact, &DuplicateGroup{Tracks: first}
+}
+
+// normalizeTitle lowercases a title and strips featured artists, remaster notes
+// and punctuation so that different releases of a song compare equal
+func normalizeTitle(title string) string {
+	title = titleNoise.ReplaceAllString(title, "")
+	title = strings.ToLower(title)
+
+	var b strings.Builder
+	space := false
+	for _, r := range title {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			space = false
+		case !space && b.Len() > 0:
+			b.WriteRune(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// logDuplicateReport logs every duplicate group found in a playlist
+func logDuplicateReport(report DuplicateReport) {
+	for _, group := range report.Exact {
+		logDuplicateGroup(report.PlaylistName, "exact", group)
+	}
+	for _, group := range report.Probable {
+		logDuplicateGroup(report.PlaylistName, "probable", group)
+	}
+}
+
+func logDuplicateGroup(playlistName string, kind string, group DuplicateGroup) {
+	for _, track := range group.Tracks {
+		log.WithFields(log.Fields{
+			"playlist": playlistName,
+			"kind":     kind,
+			"position": track.Position,
+			"name":     track.Name,
+			"artist":   track.Artist,
+			"album":    track.Album,
+			"id":       track.ID}).
+			Warningf("Duplicate track found")
+	}
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+var testPlaylist = spotify.SimplePlaylist{
+	ID:    "playlist1",
+	Name:  "test playlist",
+	Owner: spotify.User{ID: "user1"},
+}
+
+func cleanUp(cacheDir string) {
+	_ = os.RemoveAll(cacheDir)
+}
+
+func newTestTrack(id string, name string, artist string, duration int) spotify.PlaylistTrack {
+	return spotify.PlaylistTrack{
+		Track: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{
+				ID:       spotify.ID(id),
+				Name:     name,
+				Duration: duration,
+				Artists:  []spotify.SimpleArtist{{Name: artist}},
+			},
+		},
+	}
+}
+
+func Test_NormalizeTitle(t *testing.T) {
+	assert.Equal(t, "come together", normalizeTitle("Come Together - Remastered 2009"))
+	assert.Equal(t, "come together", normalizeTitle("Come Together (2019 Mix)"))
+	assert.Equal(t, "song", normalizeTitle("Song (feat. Someone Else)"))
+	assert.Equal(t, "don t stop", normalizeTitle("Don't Stop!"))
+}
+
+func Test_FindDuplicates_Exact(t *testing.T) {
+	tracks := []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("b", "Other", "Artist", 180000),
+		newTestTrack("a", "Song", "Artist", 200000),
+	}
+
+	report := findDuplicates(testPlaylist, tracks)
+	assert.Len(t, report.Exact, 1)
+	assert.Empty(t, report.Probable)
+	assert.Equal(t, 0, report.Exact[0].Tracks[0].Position)
+	assert.Equal(t, 2, report.Exact[0].Tracks[1].Position)
+}
+
+func Test_FindDuplicates_Probable(t *testing.T) {
+	tracks := []spotify.PlaylistTrack{
+		newTestTrack("a", "Song - Remastered 2011", "Artist", 200000),
+		newTestTrack("b", "Song", "artist", 201500),
+		newTestTrack("c", "Song", "Artist", 260000),
+	}
+
+	report := findDuplicates(testPlaylist, tracks)
+	assert.Empty(t, report.Exact)
+	assert.Len(t, report.Probable, 1)
+	assert.Len(t, report.Probable[0].Tracks, 2)
+	assert.Equal(t, spotify.ID("a"), report.Probable[0].Tracks[0].ID)
+	assert.Equal(t, spotify.ID("b"), report.Probable[0].Tracks[1].ID)
+}
+
+func Test_FindDuplicates_None(t *testing.T) {
+	tracks := []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("b", "Song", "Other Artist", 200000),
+	}
+
+	report := findDuplicates(testPlaylist, tracks)
+	assert.Empty(t, report.Exact)
+	assert.Empty(t, report.Probable)
+}
+
+// Test_FindPossibleDuplicateTracks_ReportOnly tests that nothing is removed by default
+func Test_FindPossibleDuplicateTracks_ReportOnly(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+
+	_ = s.SaveTracksFile(context.Background(), testPlaylist.ID, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("b", "Song", "Artist", 200000),
+	})
+
+	reports, err := u.FindPossibleDuplicateTracks(context.Background(), []spotify.SimplePlaylist{testPlaylist}, "user1")
+	assert.NoError(t, err)
+	assert.Len(t, reports, 1)
+}
+
+// Test_FindPossibleDuplicateTracks_Remove tests removing the extra copy of a probable duplicate
+func Test_FindPossibleDuplicateTracks_Remove(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", true, false, 1)
+
+	playlist := testPlaylist
+	playlist.SnapshotID = "snapshot1"
+	_ = s.SavePlaylistFile(context.Background(), service.NewCachedPlaylist(playlist, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("b", "Song", "Artist", 200000),
+		newTestTrack("c", "Other", "Artist", 200000),
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("b", "Song", "Artist", 200000),
+	}))
+	mockWrapper.EXPECT().RemoveTrackPositionsFromPlaylist(gomock.Any(), playlist.ID, "snapshot1",
+		spotify.NewTrackToRemove("a", []int{3}),
+		spotify.NewTrackToRemove("b", []int{4, 1}),
+	).Return("snapshot2", nil)
+
+	reports, err := u.FindPossibleDuplicateTracks(context.Background(), []spotify.SimplePlaylist{playlist}, "user1")
+	assert.NoError(t, err)
+	assert.Len(t, reports, 1)
+	assert.Len(t, u.Plan().Actions, 3)
+}
+
+// Test_FindPossibleDuplicateTracks_NotOwner tests that nothing is removed from playlists of other users
+func Test_FindPossibleDuplicateTracks_NotOwner(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", true, false, 1)
+
+	_ = s.SaveTracksFile(context.Background(), testPlaylist.ID, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("a", "Song", "Artist", 200000),
+	})
+
+	reports, err := u.FindPossibleDuplicateTracks(context.Background(), []spotify.SimplePlaylist{testPlaylist}, "user2")
+	assert.NoError(t, err)
+	assert.Len(t, reports, 1)
+	assert.Empty(t, u.Plan().Actions)
+}
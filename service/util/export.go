@@ -0,0 +1,109 @@
+package util
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/formats"
+	"github.com/reeves122/spotify-automation-go/service/musicbrainz"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+)
+
+// AllPlaylistsFile is the name of the CSV export file holding every exported playlist
+const AllPlaylistsFile = "all_playlists"
+
+// ExportPlaylists writes the cached copy of each playlist to a file in the given
+// format in dir, named by exportFileName. CSV exports also get a file holding every
+// exported playlist with a playlist name column. JSPF exports identify tracks by the
+// MusicBrainz recording of their ISRC when options.MusicBrainz is set. Playlists
+// which have not been cached are skipped.
+func (u *util) ExportPlaylists(ctx context.Context, playlists []spotify.SimplePlaylist, dir string,
+	format string, options formats.ExportOptions) error {
+
+	err := os.MkdirAll(dir, 0770)
+	if err != nil {
+		return err
+	}
+
+	if format == formats.FormatJSPF && options.MusicBrainz != nil && options.Recordings == nil {
+		options.Recordings = map[string]*musicbrainz.Recording{}
+	}
+
+	var exported []*service.CachedPlaylist
+	for _, playlist := range playlists {
+		cached, err := u.storage.LoadPlaylistFile(ctx, service.PlaylistKey(playlist.ID, playlist.Name))
+		if err != nil {
+			return err
+		}
+		if cached == nil {
+			log.Warningf("Skipping playlist %s which has not been cached", playlist.Name)
+			continue
+		}
+
+		if options.Recordings != nil {
+			err = lookupRecordings(ctx, cached, options)
+			if err != nil {
+				return err
+			}
+		}
+
+		fileName := filepath.Join(dir, exportFileName(playlist, format))
+		log.Infof("Exporting %d tracks of playlist %s to file: %s", len(cached.Tracks), playlist.Name, fileName)
+		err = formats.WriteFile(fileName, func(w io.Writer) error {
+			return formats.WritePlaylist(w, format, cached, options)
+		})
+		if err != nil {
+			return err
+		}
+		exported = append(exported, cached)
+	}
+
+	if format != formats.FormatCSV {
+		return nil
+	}
+	fileName := filepath.Join(dir, AllPlaylistsFile+".csv")
+	log.Infof("Exporting %d playlists to file: %s", len(exported), fileName)
+	return formats.WriteFile(fileName, func(w io.Writer) error {
+		return formats.WriteCSV(w, exported, options.Columns, true)
+	})
+}
+
+// lookupRecordings looks up the MusicBrainz recording of the ISRC of every track of a
+// playlist which has not been looked up yet. A failed lookup leaves the track without
+// one, so an unavailable MusicBrainz doesn't stop the export.
+func lookupRecordings(ctx context.Context, playlist *service.CachedPlaylist, options formats.ExportOptions) error {
+	found := 0
+	for _, track := range playlist.Tracks {
+		isrc := track.Track.ExternalIDs["isrc"]
+		if isrc == "" {
+			continue
+		}
+		if _, done := options.Recordings[isrc]; !done {
+			recording, err := options.MusicBrainz.LookupISRC(ctx, isrc)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				log.Warningf("Unable to look up ISRC %s in MusicBrainz: %v", isrc, err)
+				continue
+			}
+			options.Recordings[isrc] = recording
+		}
+		if options.Recordings[isrc] != nil {
+			found++
+		}
+	}
+	log.Infof("Found MusicBrainz recordings of %d of %d tracks of playlist %s", found, len(playlist.Tracks), playlist.Name)
+	return nil
+}
+
+// exportFileName returns the name of the export file of a playlist, which holds its ID
+// as well as its name so that playlists whose names are the same, or sanitize the same,
+// don't overwrite each other or the file of every playlist
+func exportFileName(playlist spotify.SimplePlaylist, format string) string {
+	return service.SafeFileName(playlist.Name) + "_" + string(playlist.ID) + "." + format
+}
@@ -0,0 +1,96 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/formats"
+	"github.com/reeves122/spotify-automation-go/service/musicbrainz"
+	"github.com/reeves122/spotify-automation-go/service/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Test_ExportPlaylists tests exporting each cached playlist and the combined file,
+// skipping playlists which have not been cached
+func Test_ExportPlaylists(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+	dir := t.TempDir()
+
+	_ = s.SavePlaylistFile(context.Background(), service.NewCachedPlaylist(testPlaylist, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+	}))
+
+	err := u.ExportPlaylists(context.Background(), []spotify.SimplePlaylist{testPlaylist, testQueuePlaylist}, dir, formats.FormatCSV,
+		formats.ExportOptions{Columns: []string{formats.ColumnTrack, formats.ColumnDuration}})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dir, "test playlist_playlist1.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "track,duration\nSong,3:20\n", string(data))
+
+	data, err = os.ReadFile(filepath.Join(dir, "all_playlists.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "playlist,track,duration\ntest playlist,Song,3:20\n", string(data))
+
+	_, err = os.Stat(filepath.Join(dir, "test playlist Queue_queue1.csv"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// fakeMusicBrainz knows the recordings of some ISRCs, fails the lookup of others and
+// counts the lookups made
+type fakeMusicBrainz struct {
+	recordings map[string]*musicbrainz.Recording
+	lookups    int
+}
+
+func (f *fakeMusicBrainz) LookupISRC(_ context.Context, isrc string) (*musicbrainz.Recording, error) {
+	f.lookups++
+	recording, found := f.recordings[isrc]
+	if !found {
+		return nil, fmt.Errorf("test error")
+	}
+	return recording, nil
+}
+
+// Test_ExportPlaylists_MusicBrainz tests that JSPF tracks are identified by the
+// recordings of their ISRCs, each looked up once, and that failed lookups are skipped
+func Test_ExportPlaylists_MusicBrainz(t *testing.T) {
+	s := storage.NewStorage(t.TempDir(), false)
+	u := NewUtil(nil, s, "disliked_", " Queue", false, false, 1)
+	dir := t.TempDir()
+
+	var tracks []spotify.PlaylistTrack
+	for i, isrc := range []string{"USABC2200001", "USABC2200001", "USABC2200002"} {
+		track := newTestTrack(fmt.Sprintf("track%d", i), "Song", "Artist", 200000)
+		track.Track.ExternalIDs = map[string]string{"isrc": isrc}
+		tracks = append(tracks, track)
+	}
+	_ = s.SavePlaylistFile(context.Background(), service.NewCachedPlaylist(testPlaylist, tracks))
+	lookup := &fakeMusicBrainz{recordings: map[string]*musicbrainz.Recording{
+		"USABC2200001": {ID: "rec1", ArtistIDs: []string{"artist1"}},
+	}}
+
+	err := u.ExportPlaylists(context.Background(), []spotify.SimplePlaylist{testPlaylist}, dir, formats.FormatJSPF,
+		formats.ExportOptions{MusicBrainz: lookup})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, lookup.lookups)
+
+	data, err := os.ReadFile(filepath.Join(dir, "test playlist_playlist1.jspf"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "https://musicbrainz.org/recording/rec1"))
+	assert.Equal(t, 2, strings.Count(string(data), "https://musicbrainz.org/artist/artist1"))
+}
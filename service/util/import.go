@@ -0,0 +1,94 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/formats"
+	log "github.com/sirupsen/logrus"
+)
+
+// ImportPlaylist reads a playlist file into the cache, so it can be restored to
+// Spotify. The format is chosen by the file extension: .jspf or .json for JSPF.
+// Tracks without a Spotify identifier, such as those of ListenBrainz playlists, are
+// matched to Spotify like CSV rows, by ISRC or by searching their title and artist.
+// A cached copy of the same playlist, by ID or for playlists without one by name,
+// is replaced, though it is kept in the history.
+func (u *util) ImportPlaylist(ctx context.Context, fileName string, minConfidence float64) (*service.CachedPlaylist, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var playlist *service.CachedPlaylist
+	switch ext := strings.ToLower(filepath.Ext(fileName)); ext {
+	case ".jspf", ".json":
+		playlist, err = formats.ReadJSPF(file)
+	default:
+		return nil, fmt.Errorf("unable to import %s: unknown file extension %q", fileName, ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = u.resolveTracks(ctx, playlist, minConfidence)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, err := u.storage.LoadPlaylistFile(ctx, playlist.Key())
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		log.Warningf("Replacing cached playlist %s with the imported one", cached.Name)
+	}
+
+	log.Infof("Importing %d tracks of playlist %s from file: %s", len(playlist.Tracks), playlist.Name, fileName)
+	return playlist, u.savePlaylist(ctx, playlist, cached)
+}
+
+// resolveTracks matches the tracks of an imported playlist which have no Spotify ID to
+// Spotify tracks. Tracks which can't be matched are kept, but can't be restored.
+func (u *util) resolveTracks(ctx context.Context, playlist *service.CachedPlaylist, minConfidence float64) error {
+	unresolved := 0
+	for i := range playlist.Tracks {
+		track := &playlist.Tracks[i]
+		if track.Track.ID != "" {
+			continue
+		}
+
+		row := formats.ImportRow{
+			Line:     i + 1,
+			Title:    track.Track.Name,
+			Artist:   service.PrimaryArtist(track.Track),
+			Album:    track.Track.Album.Name,
+			ISRC:     track.Track.ExternalIDs["isrc"],
+			Duration: track.Track.Duration,
+		}
+		match, err := u.matchRow(ctx, row, minConfidence)
+		if err != nil {
+			return err
+		}
+		if !match.Resolved || match.Track == nil {
+			log.WithFields(log.Fields{
+				"track":  row.Line,
+				"title":  row.Title,
+				"artist": row.Artist}).
+				Warning("Unable to match track")
+			unresolved++
+			continue
+		}
+
+		log.Debugf("Matched track %d %s by %s with %.0f%% confidence", row.Line, row.Title, match.Method, match.Confidence*100)
+		track.Track = *match.Track
+	}
+	if unresolved > 0 {
+		log.Warningf("%d tracks of playlist %s could not be matched and can't be restored", unresolved, playlist.Name)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Test_ResolveTracks_NoResults tests that a track without any search results is kept
+// unresolved, even with no minimum confidence
+func Test_ResolveTracks_NoResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	u := NewUtil(mockWrapper, nil, "disliked_", " Queue", false, false, 1)
+
+	unmatched := newTestTrack("", "Song", "Artist", 200000)
+	playlist := &service.CachedPlaylist{Name: "Imported", Tracks: []spotify.PlaylistTrack{unmatched}}
+	mockWrapper.EXPECT().SearchTracks(gomock.Any(), gomock.Any(), searchLimit).Return(nil, nil).Times(2)
+
+	err := u.resolveTracks(context.Background(), playlist, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []spotify.PlaylistTrack{unmatched}, playlist.Tracks)
+}
@@ -0,0 +1,174 @@
+package util
+
+import (
+	"context"
+	"strings"
+
+	"github.com/reeves122/spotify-automation-go/service/formats"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Methods by which an imported row is matched to a Spotify track
+const (
+	MatchISRC   = "isrc"
+	MatchSearch = "search"
+)
+
+// searchLimit is the number of search results considered for each row
+const searchLimit = 10
+
+// durationFalloff is how far in milliseconds beyond durationTolerance the duration
+// of a track can be before it no longer counts towards a match
+const durationFalloff = 30000
+
+// ImportMatch is the Spotify track an imported row was matched to, if any, and the
+// confidence of the match from 0 to 1. Rows with a track below the minimum
+// confidence keep their best candidate but are not resolved.
+type ImportMatch struct {
+	Row        formats.ImportRow
+	Track      *spotify.FullTrack
+	Method     string
+	Confidence float64
+	Resolved   bool
+}
+
+// matchRow finds the Spotify track of an imported row: first by searching for its
+// ISRC, then by searching for its title and artist and scoring the results by
+// title, artist and duration
+func (u *util) matchRow(ctx context.Context, row formats.ImportRow, minConfidence float64) (ImportMatch, error) {
+	match := ImportMatch{Row: row}
+
+	if row.ISRC != "" {
+		tracks, err := u.spotify.SearchTracks(ctx, "isrc:"+row.ISRC, searchLimit)
+		if err != nil {
+			return match, err
+		}
+		if best, _ := bestMatch(row, tracks); best != nil {
+			match.Track, match.Method, match.Confidence, match.Resolved = best, MatchISRC, 1, true
+			return match, nil
+		}
+	}
+	if row.Title == "" {
+		return match, nil
+	}
+
+	var queries []string
+	if row.Artist != "" {
+		queries = append(queries, "track:"+quote(row.Title)+" artist:"+quote(firstArtist(row.Artist)))
+	}
+	queries = append(queries, normalizeTitle(row.Title+" "+firstArtist(row.Artist)))
+
+	for _, query := range queries {
+		tracks, err := u.spotify.SearchTracks(ctx, query, searchLimit)
+		if err != nil {
+			return match, err
+		}
+		best, score := bestMatch(row, tracks)
+		if best != nil && score > match.Confidence {
+			match.Track, match.Method, match.Confidence = best, MatchSearch, score
+		}
+		if match.Track != nil && match.Confidence >= minConfidence {
+			match.Resolved = true
+			return match, nil
+		}
+	}
+	return match, nil
+}
+
+// bestMatch returns the track scoring highest against a row, and its score
+func bestMatch(row formats.ImportRow, tracks []spotify.FullTrack) (*spotify.FullTrack, float64) {
+	var best *spotify.FullTrack
+	bestScore := -1.0
+	for i, track := range tracks {
+		if track.ID == "" {
+			continue
+		}
+		if score := matchScore(row, track); score > bestScore {
+			best, bestScore = &tracks[i], score
+		}
+	}
+	return best, bestScore
+}
+
+// matchScore scores how well a track matches a row from 0 to 1, weighing the title
+// most, then the artist and the duration. Fields the row lacks are left out.
+func matchScore(row formats.ImportRow, track spotify.FullTrack) float64 {
+	score := 0.5 * textSimilarity(normalizeTitle(row.Title), normalizeTitle(track.Name))
+	weight := 0.5
+
+	if row.Artist != "" {
+		artistScore := 0.0
+		for _, rowArtist := range formats.SplitArtists(row.Artist) {
+			for _, artist := range track.Artists {
+				if s := textSimilarity(normalizeTitle(rowArtist), normalizeTitle(artist.Name)); s > artistScore {
+					artistScore = s
+				}
+			}
+		}
+		score += 0.3 * artistScore
+		weight += 0.3
+	}
+
+	if row.Duration > 0 {
+		diff := row.Duration - track.Duration
+		if diff < 0 {
+			diff = -diff
+		}
+		durationScore := 1.0
+		if diff > durationTolerance {
+			durationScore = 1 - float64(diff-durationTolerance)/durationFalloff
+		}
+		if durationScore < 0 {
+			durationScore = 0
+		}
+		score += 0.2 * durationScore
+		weight += 0.2
+	}
+	return score / weight
+}
+
+// textSimilarity compares two normalized strings: 1 when equal, otherwise the share
+// of words they have in common
+func textSimilarity(a string, b string) float64 {
+	if a == b {
+		return 1
+	}
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+
+	set := map[string]bool{}
+	for _, word := range wordsA {
+		set[word] = true
+	}
+	common := 0
+	union := len(set)
+	seen := map[string]bool{}
+	for _, word := range wordsB {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		if set[word] {
+			common++
+		} else {
+			union++
+		}
+	}
+	return float64(common) / float64(union)
+}
+
+// firstArtist returns the first of a list of artists
+func firstArtist(artists string) string {
+	split := formats.SplitArtists(artists)
+	if len(split) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(split[0])
+}
+
+// quote quotes a search filter value, dropping any quotes within it
+func quote(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, "") + `"`
+}
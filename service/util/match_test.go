@@ -0,0 +1,89 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/reeves122/spotify-automation-go/service/formats"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+func Test_MatchScore(t *testing.T) {
+	track := newTestTrack("a", "Come Together - Remastered 2009", "The Beatles", 259000).Track
+
+	row := formats.ImportRow{Title: "Come Together", Artist: "The Beatles", Duration: 260000}
+	assert.Equal(t, 1.0, matchScore(row, track))
+
+	row = formats.ImportRow{Title: "Come Together", Artist: "Aerosmith", Duration: 230000}
+	assert.Less(t, matchScore(row, track), 0.8)
+
+	row = formats.ImportRow{Title: "Something", Artist: "The Beatles"}
+	assert.Less(t, matchScore(row, track), 0.5)
+}
+
+// Test_MatchRow_ISRC tests that a row is matched by its ISRC without searching its title
+func Test_MatchRow_ISRC(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	u := NewUtil(mockWrapper, nil, "disliked_", " Queue", false, false, 1)
+
+	track := newTestTrack("a", "Song", "Artist", 200000).Track
+	mockWrapper.EXPECT().SearchTracks(gomock.Any(), "isrc:USABC2200001", searchLimit).Return([]spotify.FullTrack{track}, nil)
+
+	match, err := u.matchRow(context.Background(), formats.ImportRow{Title: "Song (Live)", ISRC: "USABC2200001"}, 0.8)
+	assert.NoError(t, err)
+	assert.True(t, match.Resolved)
+	assert.Equal(t, MatchISRC, match.Method)
+	assert.Equal(t, spotify.ID("a"), match.Track.ID)
+}
+
+// Test_MatchRow_Search tests falling back from the ISRC to a field search and then a
+// plain search, picking the closest result
+func Test_MatchRow_Search(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	u := NewUtil(mockWrapper, nil, "disliked_", " Queue", false, false, 1)
+
+	gomock.InOrder(
+		mockWrapper.EXPECT().SearchTracks(gomock.Any(), "isrc:USABC2200001", searchLimit).Return(nil, nil),
+		mockWrapper.EXPECT().SearchTracks(gomock.Any(), `track:"Don't Stop" artist:"Artist"`, searchLimit).Return(nil, nil),
+		mockWrapper.EXPECT().SearchTracks(gomock.Any(), "don t stop artist", searchLimit).Return([]spotify.FullTrack{
+			newTestTrack("a", "Don't Stop", "Other", 200000).Track,
+			newTestTrack("b", "Don't Stop - 2011 Remaster", "Artist", 201000).Track,
+		}, nil),
+	)
+
+	row := formats.ImportRow{Title: "Don't Stop", Artist: "Artist, Guest", ISRC: "USABC2200001", Duration: 200000}
+	match, err := u.matchRow(context.Background(), row, 0.8)
+	assert.NoError(t, err)
+	assert.True(t, match.Resolved)
+	assert.Equal(t, MatchSearch, match.Method)
+	assert.Equal(t, spotify.ID("b"), match.Track.ID)
+	assert.Equal(t, 1.0, match.Confidence)
+}
+
+// Test_MatchRow_Unresolved tests that the best candidate below the minimum confidence is kept
+func Test_MatchRow_Unresolved(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	u := NewUtil(mockWrapper, nil, "disliked_", " Queue", false, false, 1)
+
+	mockWrapper.EXPECT().SearchTracks(gomock.Any(), gomock.Any(), searchLimit).Return([]spotify.FullTrack{
+		newTestTrack("a", "Song", "Someone Else", 300000).Track,
+	}, nil).Times(2)
+
+	match, err := u.matchRow(context.Background(), formats.ImportRow{Title: "Song", Artist: "Artist", Duration: 200000}, 0.8)
+	assert.NoError(t, err)
+	assert.False(t, match.Resolved)
+	assert.Equal(t, spotify.ID("a"), match.Track.ID)
+	assert.Equal(t, 0.5, match.Confidence)
+}
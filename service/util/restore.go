@@ -0,0 +1,154 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/plan"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+)
+
+// RestorePlaylist brings a playlist back to the contents and order of its cached
+// copy. If the playlist it was cached from still exists and is owned by the user,
+// its tracks are replaced. Otherwise, or when asNew is set, the cached tracks are
+// added to a new playlist named newName, or the cached name if newName is empty.
+func (u *util) RestorePlaylist(ctx context.Context, playlists []spotify.SimplePlaylist, username string,
+	name string, asNew bool, newName string) error {
+
+	cached, err := u.loadCachedPlaylist(ctx, playlists, name)
+	if err != nil {
+		return err
+	}
+
+	tracks := restorableTracks(cached)
+	if len(tracks) == 0 {
+		return fmt.Errorf("cached playlist %s has no tracks which can be restored", name)
+	}
+	reason := fmt.Sprintf("restore of playlist %s cached at %s", cached.Name, cached.UpdatedAt.Format("2006-01-02 15:04:05"))
+
+	var actions []plan.Action
+	target := findCachedPlaylist(playlists, cached)
+	if target != nil && !asNew {
+		if target.Owner.ID != username {
+			return fmt.Errorf("playlist %s is owned by %s, restore it as a new playlist instead", target.Name, target.Owner.ID)
+		}
+		log.Infof("Restoring %d cached tracks to playlist %s", len(tracks), target.Name)
+		actions = newRestoreActions(plan.ActionReplace, target.ID, target.Name, reason, tracks)
+	} else {
+		if newName == "" {
+			newName = cached.Name
+		}
+		log.Infof("Restoring %d cached tracks to new playlist %s", len(tracks), newName)
+		actions = append(actions, plan.Action{
+			Type:         plan.ActionCreatePlaylist,
+			PlaylistName: newName,
+			Rule:         plan.RuleRestore,
+			Reason:       reason,
+		})
+		actions = append(actions, newRestoreActions(plan.ActionAdd, "", newName, reason, tracks)...)
+	}
+
+	for _, action := range actions {
+		u.plan.Add(action)
+	}
+
+	if u.dryRun {
+		log.Infof("Dry run: not restoring playlist %s", cached.Name)
+		return nil
+	}
+	return u.applyActions(ctx, actions)
+}
+
+// loadCachedPlaylist loads the cached playlist with the given name or ID. The cached
+// copy of a current playlist is preferred over any other cached playlist, such as
+// one which has since been deleted. A name shared by several cached playlists must
+// be given by ID instead.
+func (u *util) loadCachedPlaylist(ctx context.Context, playlists []spotify.SimplePlaylist, name string) (*service.CachedPlaylist, error) {
+	var found []*service.CachedPlaylist
+	for _, playlist := range playlists {
+		if playlist.ID.String() != name && playlist.Name != name {
+			continue
+		}
+		cached, err := u.storage.LoadPlaylistFile(ctx, service.PlaylistKey(playlist.ID, playlist.Name))
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			found = append(found, cached)
+		}
+	}
+
+	if len(found) == 0 {
+		var err error
+		found, err = u.storage.FindPlaylistFiles(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("playlist %s is not in the cache", name)
+	case 1:
+		return found[0], nil
+	}
+	var keys []string
+	for _, cached := range found {
+		keys = append(keys, cached.Key())
+	}
+	return nil, fmt.Errorf("%d cached playlists are named %s, choose one by ID: %s", len(found), name, strings.Join(keys, ", "))
+}
+
+// restorableTracks returns the cached tracks which can be added to a playlist.
+// Local files and tracks without an ID are skipped.
+func restorableTracks(cached *service.CachedPlaylist) []spotify.PlaylistTrack {
+	var tracks []spotify.PlaylistTrack
+	for _, track := range cached.Tracks {
+		if track.IsLocal || track.Track.ID == "" {
+			log.WithFields(log.Fields{
+				"name":   track.Track.Name,
+				"artist": service.PrimaryArtist(track.Track)}).
+				Warning("Unable to restore local track")
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// findCachedPlaylist finds the playlist a cached copy was made from, by ID or, for
+// cache files without metadata, by name
+func findCachedPlaylist(playlists []spotify.SimplePlaylist, cached *service.CachedPlaylist) *spotify.SimplePlaylist {
+	for i, playlist := range playlists {
+		if cached.ID != "" && playlist.ID == cached.ID {
+			return &playlists[i]
+		}
+		if cached.ID == "" && playlist.Name == cached.Name {
+			return &playlists[i]
+		}
+	}
+	return nil
+}
+
+// newRestoreActions creates the actions putting the tracks into a playlist in order
+func newRestoreActions(actionType string, playlistID spotify.ID, playlistName string, reason string, tracks []spotify.PlaylistTrack) []plan.Action {
+	var actions []plan.Action
+	for i, track := range tracks {
+		position := i
+		actions = append(actions, plan.Action{
+			Type:         actionType,
+			PlaylistID:   playlistID,
+			PlaylistName: playlistName,
+			TrackID:      track.Track.ID,
+			TrackName:    track.Track.Name,
+			Artist:       service.PrimaryArtist(track.Track),
+			Position:     &position,
+			Rule:         plan.RuleRestore,
+			Reason:       reason,
+		})
+	}
+	return actions
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/plan"
+	"github.com/reeves122/spotify-automation-go/service/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+// Test_RestorePlaylist_Replace tests restoring the cached tracks to the original playlist
+func Test_RestorePlaylist_Replace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+
+	local := newTestTrack("", "Local Song", "Artist", 200000)
+	local.IsLocal = true
+	_ = s.SavePlaylistFile(context.Background(), service.NewCachedPlaylist(testPlaylist, []spotify.PlaylistTrack{
+		newTestTrack("b", "Song B", "Artist", 200000),
+		local,
+		newTestTrack("a", "Song A", "Artist", 200000),
+	}))
+	mockWrapper.EXPECT().ReplacePlaylistTracks(gomock.Any(), testPlaylist.ID, spotify.ID("b"), spotify.ID("a")).Return(nil)
+
+	err := u.RestorePlaylist(context.Background(), []spotify.SimplePlaylist{testPlaylist}, "user1", testPlaylist.Name, false, "")
+	assert.NoError(t, err)
+	assert.Len(t, u.Plan().Actions, 2)
+	assert.Equal(t, plan.ActionReplace, u.Plan().Actions[0].Type)
+}
+
+// Test_RestorePlaylist_New tests restoring the cached tracks to a new playlist
+func Test_RestorePlaylist_New(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+
+	_ = s.SavePlaylistFile(context.Background(), service.NewCachedPlaylist(testPlaylist, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song A", "Artist", 200000),
+		newTestTrack("b", "Song B", "Artist", 200000),
+	}))
+	gomock.InOrder(
+		mockWrapper.EXPECT().CreatePlaylist(gomock.Any(), "restored", gomock.Any()).
+			Return(spotify.SimplePlaylist{ID: "new1", Name: "restored"}, nil),
+		mockWrapper.EXPECT().AddTracksToPlaylist(gomock.Any(), spotify.ID("new1"), spotify.ID("a"), spotify.ID("b")).Return(nil),
+	)
+
+	err := u.RestorePlaylist(context.Background(), []spotify.SimplePlaylist{testPlaylist}, "user1", testPlaylist.Name, true, "restored")
+	assert.NoError(t, err)
+	assert.Equal(t, plan.ActionCreatePlaylist, u.Plan().Actions[0].Type)
+}
+
+// Test_RestorePlaylist_NotCached tests restoring a playlist which is not in the cache
+func Test_RestorePlaylist_NotCached(t *testing.T) {
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(nil, s, "disliked_", " Queue", false, false, 1)
+
+	err := u.RestorePlaylist(context.Background(), nil, "user1", "missing", false, "")
+	assert.EqualError(t, err, "playlist missing is not in the cache")
+}
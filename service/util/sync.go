@@ -0,0 +1,130 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/reeves122/spotify-automation-go/service"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify/v2"
+)
+
+// PlaylistError is the error of a single playlist during a sync
+type PlaylistError struct {
+	Playlist string
+	Err      error
+}
+
+func (e *PlaylistError) Error() string {
+	return fmt.Sprintf("playlist %s: %s", e.Playlist, e.Err)
+}
+
+func (e *PlaylistError) Unwrap() error {
+	return e.Err
+}
+
+// SyncError holds the error of every playlist which failed during a sync, in the
+// order of the playlists
+type SyncError struct {
+	Errors []*PlaylistError
+}
+
+func (e *SyncError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+
+	var messages []string
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d playlists failed: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// Is reports whether the error of any playlist matches the target
+func (e *SyncError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// forEachPlaylist calls fn for every playlist using a pool of syncWorkers goroutines.
+// A playlist listed more than once shares a cache file, so its copies are handled by
+// a single worker in their original order, which keeps the result the same as a
+// sequential run. Every playlist is attempted and the errors are returned together as a
+// SyncError, unless the context is cancelled.
+func (u *util) forEachPlaylist(ctx context.Context, playlists []spotify.SimplePlaylist,
+	fn func(ctx context.Context, playlist spotify.SimplePlaylist) error) error {
+
+	var jobs [][]int
+	jobByKey := map[string]int{}
+	for i, playlist := range playlists {
+		key := service.PlaylistKey(playlist.ID, playlist.Name)
+		j, present := jobByKey[key]
+		if !present {
+			j = len(jobs)
+			jobByKey[key] = j
+			jobs = append(jobs, nil)
+		}
+		jobs[j] = append(jobs[j], i)
+	}
+
+	workers := u.syncWorkers
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	log.Debugf("Processing %d playlists with %d workers", len(playlists), workers)
+
+	errs := make([]error, len(playlists))
+	queue := make(chan []int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				for _, i := range job {
+					if ctx.Err() != nil {
+						return
+					}
+					errs[i] = fn(ctx, playlists[i])
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, job := range jobs {
+		select {
+		case queue <- job:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	syncErr := &SyncError{}
+	for i, err := range errs {
+		if err != nil {
+			syncErr.Errors = append(syncErr.Errors, &PlaylistError{Playlist: playlists[i].Name, Err: err})
+		}
+	}
+	if len(syncErr.Errors) > 0 {
+		return syncErr
+	}
+	return nil
+}
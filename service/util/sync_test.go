@@ -0,0 +1,151 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/reeves122/spotify-automation-go/service/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+// newSyncPlaylists creates playlists with one track each. The last two share a name.
+func newSyncPlaylists(count int) []spotify.SimplePlaylist {
+	var playlists []spotify.SimplePlaylist
+	for i := 0; i < count; i++ {
+		playlists = append(playlists, spotify.SimplePlaylist{
+			ID:         spotify.ID(fmt.Sprintf("playlist%d", i)),
+			Name:       fmt.Sprintf("playlist %d", i),
+			SnapshotID: fmt.Sprintf("snapshot%d", i),
+		})
+	}
+	playlists[count-1].Name = playlists[count-2].Name
+	return playlists
+}
+
+// syncTracks returns the cached tracks of every playlist after a sync with the given number of workers
+func syncTracks(t *testing.T, cacheDir string, workers int, playlists []spotify.SimplePlaylist) map[spotify.ID][]spotify.PlaylistTrack {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage(cacheDir, true)
+	defer cleanUp(cacheDir)
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, workers)
+
+	mockWrapper.EXPECT().GetAllPlaylistTracks(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, id spotify.ID) ([]spotify.PlaylistTrack, error) {
+			return []spotify.PlaylistTrack{newTestTrack(string(id), "Song", "Artist", 200000)}, nil
+		}).Times(len(playlists))
+
+	assert.NoError(t, u.UpdateLocalCache(context.Background(), playlists))
+
+	result := map[spotify.ID][]spotify.PlaylistTrack{}
+	for _, playlist := range playlists {
+		tracks, err := s.LoadTracksFile(context.Background(), playlist.ID)
+		assert.NoError(t, err)
+		result[playlist.ID] = tracks
+	}
+	return result
+}
+
+// Test_UpdateLocalCache_Concurrent tests that a sync with several workers caches the
+// same tracks as a sequential sync
+func Test_UpdateLocalCache_Concurrent(t *testing.T) {
+	playlists := newSyncPlaylists(30)
+
+	sequential := syncTracks(t, "test-sequential", 1, playlists)
+	concurrent := syncTracks(t, "test-concurrent", 8, playlists)
+	assert.Equal(t, sequential, concurrent)
+
+	// playlists sharing a name are cached separately
+	assert.Equal(t, spotify.ID("playlist28"), concurrent["playlist28"][0].Track.ID)
+	assert.Equal(t, spotify.ID("playlist29"), concurrent["playlist29"][0].Track.ID)
+}
+
+// Test_UpdateLocalCache_Errors tests that every playlist is attempted and the errors are aggregated
+func Test_UpdateLocalCache_Errors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 4)
+
+	playlists := newSyncPlaylists(10)
+	failure := errors.New("request failed")
+	mockWrapper.EXPECT().GetAllPlaylistTracks(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, id spotify.ID) ([]spotify.PlaylistTrack, error) {
+			if id == "playlist3" || id == "playlist7" {
+				return nil, failure
+			}
+			return []spotify.PlaylistTrack{newTestTrack(string(id), "Song", "Artist", 200000)}, nil
+		}).Times(len(playlists))
+
+	err := u.UpdateLocalCache(context.Background(), playlists)
+	var syncErr *SyncError
+	assert.True(t, errors.As(err, &syncErr))
+	assert.Len(t, syncErr.Errors, 2)
+	assert.Equal(t, "playlist 3", syncErr.Errors[0].Playlist)
+	assert.Equal(t, "playlist 7", syncErr.Errors[1].Playlist)
+	assert.ErrorIs(t, err, failure)
+	assert.EqualError(t, err, "2 playlists failed: playlist playlist 3: request failed; playlist playlist 7: request failed")
+
+	tracks, err := s.LoadTracksFile(context.Background(), "playlist5")
+	assert.NoError(t, err)
+	assert.Len(t, tracks, 1)
+}
+
+// Test_UpdateLocalCache_Cancelled tests that no playlist is downloaded once the context is cancelled
+func Test_UpdateLocalCache_Cancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	u := NewUtil(mockWrapper, nil, "disliked_", " Queue", false, false, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := u.UpdateLocalCache(ctx, newSyncPlaylists(10))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// Test_UpdateLocalCache_SharedLegacyFile tests that a cache file of an older version
+// named after several playlists is not migrated to either of them
+func Test_UpdateLocalCache_SharedLegacyFile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	cacheDir := t.TempDir()
+	s := storage.NewStorage(cacheDir, false)
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+
+	legacyFile := filepath.Join(cacheDir, "Mix-2022.json")
+	legacy, _ := json.Marshal([]spotify.PlaylistTrack{newTestTrack("old", "Old Song", "Artist", 200000)})
+	_ = os.WriteFile(legacyFile, legacy, 0644)
+	playlists := []spotify.SimplePlaylist{
+		{ID: "playlist1", Name: "Mix/2022", SnapshotID: "snapshot1"},
+		{ID: "playlist2", Name: "Mix.2022", SnapshotID: "snapshot2"},
+	}
+	mockWrapper.EXPECT().GetAllPlaylistTracks(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, id spotify.ID) ([]spotify.PlaylistTrack, error) {
+			return []spotify.PlaylistTrack{newTestTrack(string(id), "Song", "Artist", 200000)}, nil
+		}).Times(len(playlists))
+
+	assert.NoError(t, u.UpdateLocalCache(context.Background(), playlists))
+	assert.FileExists(t, legacyFile)
+	for _, playlist := range playlists {
+		versions, err := s.ListPlaylistVersions(context.Background(), string(playlist.ID))
+		assert.NoError(t, err)
+		assert.Len(t, versions, 1)
+	}
+}
@@ -1,10 +1,15 @@
 package util
 
 import (
+	"context"
+	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/reeves122/spotify-automation-go/adapter"
 	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/plan"
 	log "github.com/sirupsen/logrus"
 	"github.com/zmb3/spotify/v2"
 )
@@ -14,62 +19,189 @@ type util struct {
 	storage        service.StorageInterface
 	dislikedPrefix string // ex: 'disliked_'
 	queueSuffix    string // ex: ' Queue'
+
+	removeDuplicates bool
+	syncWorkers      int        // number of playlists downloaded at the same time
+	dryRun           bool       // when set, changes are only recorded in the plan
+	plan             *plan.Plan // every change made (or intended, in dry-run mode)
 }
 
-func NewUtil(spotify adapter.SpotifyWrapperInterface, storage service.StorageInterface, dislikedPrefix string, queueSuffix string) *util {
+func NewUtil(spotify adapter.SpotifyWrapperInterface, storage service.StorageInterface, dislikedPrefix string, queueSuffix string, removeDuplicates bool, dryRun bool, syncWorkers int) *util {
 	return &util{
-		spotify:        spotify,
-		storage:        storage,
-		dislikedPrefix: dislikedPrefix,
-		queueSuffix:    queueSuffix,
+		spotify:          spotify,
+		storage:          storage,
+		dislikedPrefix:   dislikedPrefix,
+		queueSuffix:      queueSuffix,
+		removeDuplicates: removeDuplicates,
+		dryRun:           dryRun,
+		syncWorkers:      syncWorkers,
+		plan:             plan.NewPlan(),
 	}
 }
 
-func (u *util) GetAllPlaylistsForUser(username string) ([]spotify.SimplePlaylist, error) {
-	return u.spotify.GetAllPlaylistsForUser(username)
+// Plan returns the changes made, or planned when in dry-run mode, so far
+func (u *util) Plan() *plan.Plan {
+	return u.plan
 }
 
-// UpdateLocalCache saves the contents of all playlists to a file
-func (u *util) UpdateLocalCache(playlists []spotify.SimplePlaylist) error {
-	log.Info("Updating local cache of playlists")
+func (u *util) GetAllPlaylistsForUser(ctx context.Context, username string) ([]spotify.SimplePlaylist, error) {
+	return u.spotify.GetAllPlaylistsForUser(ctx, username)
+}
 
-	for _, playlist := range playlists {
+// UpdateLocalCache saves the contents of all playlists whose snapshot ID has
+// changed since they were cached to a file. Playlists are downloaded in parallel.
+func (u *util) UpdateLocalCache(ctx context.Context, playlists []spotify.SimplePlaylist) error {
+	log.Info("Updating local cache of playlists")
 
+	shared := sharedLegacyNames(playlists)
+	return u.forEachPlaylist(ctx, playlists, func(ctx context.Context, playlist spotify.SimplePlaylist) error {
 		log.Infof("Getting list of tracks for playlist: %s", playlist.Name)
-		cached, err := u.storage.LoadTracksFile(playlist.Name)
+		cached, err := u.loadSyncedPlaylist(ctx, playlist, shared)
 		if err != nil {
 			return err
 		}
 
-		// Compare the count of tracks to detect changes, for lack of a better option
-		if len(cached) == int(playlist.Tracks.Total) {
-			continue
+		if isCacheCurrent(playlist, cached) {
+			if cached.Name == playlist.Name {
+				return nil
+			}
+			// the tracks are unchanged, so only the new name is cached
+			renamed := *cached
+			renamed.Name = playlist.Name
+			renamed.UpdatedAt = time.Now().UTC()
+			return u.savePlaylist(ctx, &renamed, cached)
 		}
 
 		log.Infof("Detected changes in playlist: %s", playlist.Name)
-		tracks, err := u.spotify.GetAllPlaylistTracks(playlist.ID)
+		err = u.cachePlaylist(ctx, playlist, cached)
 		if err != nil {
 			return err
 		}
+		log.Infof("Done updating cache for playlist: %s", playlist.Name)
+		return nil
+	})
+}
+
+// loadSyncedPlaylist loads the cached copy of a playlist, first moving any cache file
+// written for it by older versions to its key. The file is left alone when it is
+// named after several playlists, since it can't be told which one it holds.
+func (u *util) loadSyncedPlaylist(ctx context.Context, playlist spotify.SimplePlaylist,
+	shared map[string]bool) (*service.CachedPlaylist, error) {
+
+	if shared[service.SafeFileName(playlist.Name)] {
+		log.Debugf("Not migrating the cache file of playlist %s, whose name another playlist shares", playlist.Name)
+	} else {
+		err := u.storage.MigrateLegacyPlaylist(ctx, playlist.ID, playlist.Name)
+		if err != nil {
+			log.Warnf("Unable to migrate the cache file of playlist %s: %v", playlist.Name, err)
+		}
+	}
+	return u.storage.LoadPlaylistFile(ctx, service.PlaylistKey(playlist.ID, playlist.Name))
+}
+
+// sharedLegacyNames returns the cache file names of older versions which several of
+// the playlists map to
+func sharedLegacyNames(playlists []spotify.SimplePlaylist) map[string]bool {
+	counts := map[string]int{}
+	for _, playlist := range playlists {
+		counts[service.SafeFileName(playlist.Name)]++
+	}
+	shared := map[string]bool{}
+	for name, count := range counts {
+		if count > 1 {
+			shared[name] = true
+		}
+	}
+	return shared
+}
+
+// isCacheCurrent checks whether the cached copy of a playlist matches its current snapshot
+func isCacheCurrent(playlist spotify.SimplePlaylist, cached *service.CachedPlaylist) bool {
+	return cached != nil && cached.SnapshotID != "" && cached.SnapshotID == playlist.SnapshotID
+}
+
+// cachePlaylist gets all tracks of a playlist and saves them along with its metadata,
+// replacing its previously cached copy, if any
+func (u *util) cachePlaylist(ctx context.Context, playlist spotify.SimplePlaylist, cached *service.CachedPlaylist) error {
+	tracks, err := u.spotify.GetAllPlaylistTracks(ctx, playlist.ID)
+	if err != nil {
+		return err
+	}
 
-		err = u.storage.SaveTracksFile(playlist.Name, tracks)
+	return u.savePlaylist(ctx, service.NewCachedPlaylist(playlist, tracks), cached)
+}
+
+// savePlaylist saves a playlist to the cache, recording a rename if its name has
+// changed since its previously cached copy
+func (u *util) savePlaylist(ctx context.Context, playlist *service.CachedPlaylist, previous *service.CachedPlaylist) error {
+	if playlist.RecordRename(previous) {
+		log.Infof("Playlist %s was renamed to %s", previous.Name, playlist.Name)
+	}
+	return u.storage.SavePlaylistFile(ctx, playlist)
+}
+
+// BackupPlaylists saves the full contents of all playlists to a file, regardless
+// of whether the cache appears to be up to date
+func (u *util) BackupPlaylists(ctx context.Context, playlists []spotify.SimplePlaylist) error {
+	log.Infof("Backing up %d playlists", len(playlists))
+
+	shared := sharedLegacyNames(playlists)
+	return u.forEachPlaylist(ctx, playlists, func(ctx context.Context, playlist spotify.SimplePlaylist) error {
+		log.Infof("Getting list of tracks for playlist: %s", playlist.Name)
+		cached, err := u.loadSyncedPlaylist(ctx, playlist, shared)
 		if err != nil {
 			return err
 		}
-		log.Infof("Done updating cache for playlist: %s", playlist.Name)
+		return u.cachePlaylist(ctx, playlist, cached)
+	})
+}
+
+// CacheStatus compares the cached and live contents of a playlist
+type CacheStatus struct {
+	Name   string
+	Owner  string
+	Live   int
+	Cached int
+	State  string // "ok", "stale" or "missing"
+}
+
+// GetCacheStatus returns the cache state of every playlist
+func (u *util) GetCacheStatus(ctx context.Context, playlists []spotify.SimplePlaylist) ([]CacheStatus, error) {
+	var statuses []CacheStatus
+	for _, playlist := range playlists {
+		cached, err := u.storage.LoadPlaylistFile(ctx, service.PlaylistKey(playlist.ID, playlist.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		status := CacheStatus{
+			Name:  playlist.Name,
+			Owner: playlist.Owner.ID,
+			Live:  int(playlist.Tracks.Total),
+			State: "ok",
+		}
+		if cached == nil {
+			status.State = "missing"
+		} else {
+			status.Cached = len(cached.Tracks)
+			if !isCacheCurrent(playlist, cached) {
+				status.State = "stale"
+			}
+		}
+		statuses = append(statuses, status)
 	}
-	return nil
+	return statuses, nil
 }
 
 // LoadAllDislikedTracks loads tracks from all playlists matching the dislikedPrefix pattern
-func (u *util) LoadAllDislikedTracks(playlists []spotify.SimplePlaylist) ([]spotify.PlaylistTrack, error) {
+func (u *util) LoadAllDislikedTracks(ctx context.Context, playlists []spotify.SimplePlaylist) ([]spotify.PlaylistTrack, error) {
 	log.Info("Building list of all disliked tracks")
 
 	var allTracks []spotify.PlaylistTrack
 
 	for _, playlist := range playlists {
 		if strings.HasPrefix(playlist.Name, u.dislikedPrefix) {
-			tracks, err := u.storage.LoadTracksFile(playlist.Name)
+			tracks, err := u.storage.LoadTracksFile(ctx, playlist.ID)
 			if err != nil {
 				return nil, err
 			}
@@ -82,7 +214,7 @@ func (u *util) LoadAllDislikedTracks(playlists []spotify.SimplePlaylist) ([]spot
 }
 
 // ScanPlaylistsForDislikedTracks checks all playlists for any disliked tracks
-func (u *util) ScanPlaylistsForDislikedTracks(playlists []spotify.SimplePlaylist, disliked []spotify.PlaylistTrack, username string) error {
+func (u *util) ScanPlaylistsForDislikedTracks(ctx context.Context, playlists []spotify.SimplePlaylist, disliked []spotify.PlaylistTrack, username string) error {
 	log.Infof("Scanning playlists for disliked tracks")
 	dislikedHash := createTrackIdHash(disliked)
 
@@ -95,7 +227,7 @@ func (u *util) ScanPlaylistsForDislikedTracks(playlists []spotify.SimplePlaylist
 			continue
 		}
 
-		err := u.scanPlaylistForDislikedTracks(playlist, dislikedHash)
+		err := u.scanPlaylistForDislikedTracks(ctx, playlist, dislikedHash)
 		if err != nil {
 			return err
 		}
@@ -105,33 +237,32 @@ func (u *util) ScanPlaylistsForDislikedTracks(playlists []spotify.SimplePlaylist
 
 // scanPlaylistForDislikedTracks scans the playlist for any track whose ID
 // is in the disliked map and removes it
-func (u *util) scanPlaylistForDislikedTracks(playlist spotify.SimplePlaylist, disliked map[string]bool) error {
+func (u *util) scanPlaylistForDislikedTracks(ctx context.Context, playlist spotify.SimplePlaylist, disliked map[string]bool) error {
 	log.Infof("Scanning playlist %s for disliked tracks", playlist.Name)
 
-	tracks, err := u.storage.LoadTracksFile(playlist.Name)
+	tracks, err := u.storage.LoadTracksFile(ctx, playlist.ID)
 	if err != nil {
-		return nil
+		return err
 	}
+
+	var found []spotify.PlaylistTrack
 	for _, track := range tracks {
 		if _, present := disliked[track.Track.ID.String()]; present {
 			log.WithFields(log.Fields{
 				"name":   track.Track.Name,
-				"artist": track.Track.Artists[0].Name,
+				"artist": service.PrimaryArtist(track.Track),
 				"album":  track.Track.Album.Name,
 				"id":     track.Track.ID}).
 				Warningf("Disliked track found")
-
-			err := u.spotify.RemoveTracksFromPlaylist(playlist.ID, track.Track.ID)
-			if err != nil {
-				return nil
-			}
+			found = append(found, track)
 		}
 	}
-	return nil
+
+	return u.removeTracks(ctx, newRemoveActions(playlist, plan.RuleDisliked, "track is in a disliked playlist", found...))
 }
 
 // ProcessQueuePlaylists checks all queue playlists
-func (u *util) ProcessQueuePlaylists(playlists []spotify.SimplePlaylist, username string) error {
+func (u *util) ProcessQueuePlaylists(ctx context.Context, playlists []spotify.SimplePlaylist, username string) error {
 	for _, playlist := range playlists {
 		if !strings.HasSuffix(playlist.Name, u.queueSuffix) {
 			continue
@@ -141,7 +272,7 @@ func (u *util) ProcessQueuePlaylists(playlists []spotify.SimplePlaylist, usernam
 			continue
 		}
 
-		err := u.processQueuePlaylist(playlist)
+		err := u.processQueuePlaylist(ctx, playlists, playlist)
 		if err != nil {
 			return err
 		}
@@ -153,44 +284,250 @@ func (u *util) ProcessQueuePlaylists(playlists []spotify.SimplePlaylist, usernam
 // which have been added to the corresponding destination playlist. For example, the user may
 // have "Favorites" and "Favorites Queue" playlists. The latter being songs the user has not
 // heard and rated before. If the user likes a song, they add it to the "Favorites" list and this
-// function will then remove it from the "Favorites Queue" playlist.
-func (u *util) processQueuePlaylist(playlist spotify.SimplePlaylist) error {
+// function will then remove it from the "Favorites Queue" playlist. When several
+// playlists have the destination name, the tracks of all of them are used.
+func (u *util) processQueuePlaylist(ctx context.Context, playlists []spotify.SimplePlaylist, playlist spotify.SimplePlaylist) error {
 	log.Infof("Processing queue playlist: %s", playlist.Name)
 
-	destPlaylistTracks, err := u.storage.LoadTracksFile(strings.Replace(playlist.Name, u.queueSuffix, "", 1))
-	if err != nil {
-		return err
+	destPlaylistName := strings.Replace(playlist.Name, u.queueSuffix, "", 1)
+	var destPlaylistTracks []spotify.PlaylistTrack
+	for _, dest := range playlists {
+		if dest.Name != destPlaylistName {
+			continue
+		}
+		tracks, err := u.storage.LoadTracksFile(ctx, dest.ID)
+		if err != nil {
+			return err
+		}
+		destPlaylistTracks = append(destPlaylistTracks, tracks...)
 	}
 	destPlaylistTracksHash := createTrackIdHash(destPlaylistTracks)
 
-	playlistTracks, err := u.storage.LoadTracksFile(playlist.Name)
+	playlistTracks, err := u.storage.LoadTracksFile(ctx, playlist.ID)
 	if err != nil {
 		return err
 	}
+
+	var found []spotify.PlaylistTrack
 	for _, track := range playlistTracks {
 		if _, present := destPlaylistTracksHash[track.Track.ID.String()]; present {
 			log.WithFields(log.Fields{
 				"name":   track.Track.Name,
-				"artist": track.Track.Artists[0].Name,
+				"artist": service.PrimaryArtist(track.Track),
 				"album":  track.Track.Album.Name,
 				"id":     track.Track.ID}).
 				Warningf("Queue track found in destination playlist")
+			found = append(found, track)
+		}
+	}
 
-			err := u.spotify.RemoveTracksFromPlaylist(playlist.ID, track.Track.ID)
-			if err != nil {
-				return nil
-			}
+	reason := fmt.Sprintf("track is in destination playlist %s", destPlaylistName)
+	return u.removeTracks(ctx, newRemoveActions(playlist, plan.RuleQueue, reason, found...))
+}
+
+// newRemoveActions creates the actions removing every occurrence of the tracks from a playlist
+func newRemoveActions(playlist spotify.SimplePlaylist, rule string, reason string, tracks ...spotify.PlaylistTrack) []plan.Action {
+	var actions []plan.Action
+	for _, track := range tracks {
+		actions = append(actions, plan.Action{
+			Type:         plan.ActionRemove,
+			PlaylistID:   playlist.ID,
+			PlaylistName: playlist.Name,
+			TrackID:      track.Track.ID,
+			TrackName:    track.Track.Name,
+			Artist:       service.PrimaryArtist(track.Track),
+			Rule:         rule,
+			Reason:       reason,
+		})
+	}
+	return actions
+}
+
+// removeTracks records the removals in the plan and makes them. In dry-run mode
+// the removals are only recorded.
+func (u *util) removeTracks(ctx context.Context, actions []plan.Action) error {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	for _, action := range actions {
+		u.plan.Add(action)
+	}
+
+	if u.dryRun {
+		log.Infof("Dry run: not removing %d tracks from playlist %s", len(actions), actions[0].PlaylistName)
+		return nil
+	}
+	return u.applyActions(ctx, actions)
+}
+
+// ApplyPlan makes every change recorded in a previously saved plan
+func (u *util) ApplyPlan(ctx context.Context, p *plan.Plan) error {
+	log.Infof("Applying plan with %d actions", len(p.Actions))
+
+	for _, action := range p.Actions {
+		switch action.Type {
+		case plan.ActionRemove, plan.ActionCreatePlaylist, plan.ActionAdd, plan.ActionReplace:
+		default:
+			return fmt.Errorf("unknown plan action type: %s", action.Type)
+		}
+	}
+
+	for _, action := range p.Actions {
+		log.WithFields(log.Fields{
+			"playlist": action.PlaylistName,
+			"name":     action.TrackName,
+			"id":       action.TrackID,
+			"type":     action.Type,
+			"position": action.Position,
+			"rule":     action.Rule}).
+			Info("Applying planned change")
+	}
+
+	if u.dryRun {
+		return nil
+	}
+	return u.applyActions(ctx, p.Actions)
+}
+
+// applyActions makes the changes of each playlist in turn, with one batched call
+// per kind of change. A playlist to be created is created first. Removals by
+// position are made next, as they are only valid for the snapshot of the playlist
+// they were planned against, and positions of tracks which are also removed
+// entirely are skipped. Replacements and additions are made last.
+func (u *util) applyActions(ctx context.Context, actions []plan.Action) error {
+	var keys []string
+	byPlaylist := map[string][]plan.Action{}
+	for _, action := range actions {
+		key := playlistKey(action)
+		if _, present := byPlaylist[key]; !present {
+			keys = append(keys, key)
+		}
+		byPlaylist[key] = append(byPlaylist[key], action)
+	}
+
+	for _, key := range keys {
+		err := u.applyPlaylistActions(ctx, byPlaylist[key])
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// FindPossibleDuplicateTracks finds tracks which are duplicated in a playlist
-func (u *util) FindPossibleDuplicateTracks(playlists []spotify.SimplePlaylist) error {
-	// TODO: Implement
+// playlistKey identifies the playlist of an action. Playlists which are yet to be
+// created are identified by name.
+func playlistKey(action plan.Action) string {
+	if action.PlaylistID != "" {
+		return "id:" + action.PlaylistID.String()
+	}
+	return "new:" + action.PlaylistName
+}
+
+// applyPlaylistActions makes the changes to a single playlist
+func (u *util) applyPlaylistActions(ctx context.Context, actions []plan.Action) error {
+	playlistID := actions[0].PlaylistID
+	for _, action := range actions {
+		if action.Type != plan.ActionCreatePlaylist {
+			continue
+		}
+		created, err := u.spotify.CreatePlaylist(ctx, action.PlaylistName, action.Reason)
+		if err != nil {
+			return err
+		}
+		playlistID = created.ID
+		log.Infof("Created playlist %s with ID %s", action.PlaylistName, playlistID)
+	}
+	if playlistID == "" {
+		return fmt.Errorf("playlist %s has no ID and is not created by the plan", actions[0].PlaylistName)
+	}
+
+	var ids []spotify.ID
+	removed := map[spotify.ID]bool{}
+	for _, action := range actions {
+		if action.Type == plan.ActionRemove && action.Position == nil && !removed[action.TrackID] {
+			ids = append(ids, action.TrackID)
+			removed[action.TrackID] = true
+		}
+	}
+
+	var positions []spotify.TrackToRemove
+	index := map[spotify.ID]int{}
+	snapshotID := ""
+	for _, action := range actions {
+		if action.Type != plan.ActionRemove || action.Position == nil || removed[action.TrackID] {
+			continue
+		}
+		if snapshotID != "" && action.SnapshotID != snapshotID {
+			return fmt.Errorf("removals from playlist %s were planned against different snapshots", action.PlaylistName)
+		}
+		snapshotID = action.SnapshotID
+
+		i, present := index[action.TrackID]
+		if !present {
+			i = len(positions)
+			index[action.TrackID] = i
+			positions = append(positions, spotify.NewTrackToRemove(action.TrackID.String(), nil))
+		}
+		positions[i].Positions = append(positions[i].Positions, *action.Position)
+	}
+
+	if len(positions) > 0 {
+		_, err := u.spotify.RemoveTrackPositionsFromPlaylist(ctx, playlistID, snapshotID, positions...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(ids) > 0 {
+		err := u.spotify.RemoveTracksFromPlaylist(ctx, playlistID, ids...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if replace := orderedTrackIDs(actions, plan.ActionReplace); len(replace) > 0 {
+		err := u.spotify.ReplacePlaylistTracks(ctx, playlistID, replace...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if add := orderedTrackIDs(actions, plan.ActionAdd); len(add) > 0 {
+		err := u.spotify.AddTracksToPlaylist(ctx, playlistID, add...)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// orderedTrackIDs returns the track IDs of the actions of a type, ordered by position
+func orderedTrackIDs(actions []plan.Action, actionType string) []spotify.ID {
+	var matching []plan.Action
+	for _, action := range actions {
+		if action.Type == actionType {
+			matching = append(matching, action)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return position(matching[i]) < position(matching[j])
+	})
+
+	var ids []spotify.ID
+	for _, action := range matching {
+		ids = append(ids, action.TrackID)
+	}
+	return ids
+}
+
+func position(action plan.Action) int {
+	if action.Position == nil {
+		return 0
+	}
+	return *action.Position
+}
+
 // createTrackIdHash creates a map of track ID for quick lookups
 func createTrackIdHash(tracks []spotify.PlaylistTrack) map[string]bool {
 	dislikedHash := map[string]bool{}
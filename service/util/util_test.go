@@ -0,0 +1,162 @@
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/reeves122/spotify-automation-go/mocks/mock_adapter"
+	"github.com/reeves122/spotify-automation-go/service"
+	"github.com/reeves122/spotify-automation-go/service/plan"
+	"github.com/reeves122/spotify-automation-go/service/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/zmb3/spotify/v2"
+)
+
+var testQueuePlaylist = spotify.SimplePlaylist{
+	ID:    "queue1",
+	Name:  "test playlist Queue",
+	Owner: spotify.User{ID: "user1"},
+}
+
+// Test_ScanPlaylistsForDislikedTracks_DryRun tests that a dry run only records the removal
+func Test_ScanPlaylistsForDislikedTracks_DryRun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, true, 1)
+
+	disliked := []spotify.PlaylistTrack{newTestTrack("a", "Song", "Artist", 200000)}
+	_ = s.SaveTracksFile(context.Background(), testPlaylist.ID, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("b", "Other", "Artist", 200000),
+	})
+
+	err := u.ScanPlaylistsForDislikedTracks(context.Background(), []spotify.SimplePlaylist{testPlaylist}, disliked, "user1")
+	assert.NoError(t, err)
+	assert.Len(t, u.Plan().Actions, 1)
+	assert.Equal(t, plan.RuleDisliked, u.Plan().Actions[0].Rule)
+	assert.Equal(t, spotify.ID("a"), u.Plan().Actions[0].TrackID)
+}
+
+// Test_ProcessQueuePlaylists tests removing queue tracks found in the destination playlist
+func Test_ProcessQueuePlaylists(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+
+	_ = s.SaveTracksFile(context.Background(), testPlaylist.ID, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+	})
+	_ = s.SaveTracksFile(context.Background(), testQueuePlaylist.ID, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("b", "Other", "Artist", 200000),
+		newTestTrack("c", "Song", "Artist", 200000),
+	})
+	_ = s.SaveTracksFile(context.Background(), testPlaylist.ID, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+		newTestTrack("c", "Song", "Artist", 200000),
+	})
+	mockWrapper.EXPECT().RemoveTracksFromPlaylist(gomock.Any(), testQueuePlaylist.ID, spotify.ID("a"), spotify.ID("c")).Return(nil)
+
+	err := u.ProcessQueuePlaylists(context.Background(), []spotify.SimplePlaylist{testPlaylist, testQueuePlaylist}, "user1")
+	assert.NoError(t, err)
+	assert.Len(t, u.Plan().Actions, 2)
+	assert.Equal(t, plan.RuleQueue, u.Plan().Actions[0].Rule)
+}
+
+// Test_ApplyPlan tests applying a saved plan
+func Test_ApplyPlan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	u := NewUtil(mockWrapper, nil, "disliked_", " Queue", false, false, 1)
+
+	position := 2
+	p := plan.NewPlan()
+	p.Add(plan.Action{Type: plan.ActionRemove, PlaylistID: "playlist1", TrackID: "a"})
+	p.Add(plan.Action{Type: plan.ActionRemove, PlaylistID: "playlist2", TrackID: "b"})
+	p.Add(plan.Action{Type: plan.ActionRemove, PlaylistID: "playlist1", TrackID: "c"})
+	p.Add(plan.Action{Type: plan.ActionRemove, PlaylistID: "playlist1", TrackID: "a"})
+	p.Add(plan.Action{Type: plan.ActionRemove, PlaylistID: "playlist1", TrackID: "d", Position: &position, SnapshotID: "snapshot1"})
+	p.Add(plan.Action{Type: plan.ActionRemove, PlaylistID: "playlist1", TrackID: "c", Position: &position, SnapshotID: "snapshot1"})
+
+	gomock.InOrder(
+		mockWrapper.EXPECT().RemoveTrackPositionsFromPlaylist(gomock.Any(), spotify.ID("playlist1"), "snapshot1",
+			spotify.NewTrackToRemove("d", []int{2})).Return("snapshot2", nil),
+		mockWrapper.EXPECT().RemoveTracksFromPlaylist(gomock.Any(), spotify.ID("playlist1"), spotify.ID("a"), spotify.ID("c")).Return(nil),
+		mockWrapper.EXPECT().RemoveTracksFromPlaylist(gomock.Any(), spotify.ID("playlist2"), spotify.ID("b")).Return(nil),
+	)
+
+	assert.NoError(t, u.ApplyPlan(context.Background(), p))
+}
+
+// Test_ApplyPlan_UnknownAction tests that nothing is applied when the plan has an unknown action
+func Test_ApplyPlan_UnknownAction(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	u := NewUtil(mockWrapper, nil, "disliked_", " Queue", false, false, 1)
+
+	p := plan.NewPlan()
+	p.Add(plan.Action{Type: plan.ActionRemove, PlaylistID: "playlist1", TrackID: "a"})
+	p.Add(plan.Action{Type: "rename", PlaylistID: "playlist1"})
+
+	assert.Error(t, u.ApplyPlan(context.Background(), p))
+}
+
+// Test_UpdateLocalCache_SnapshotUnchanged tests that a playlist with the cached snapshot is not downloaded
+func Test_UpdateLocalCache_SnapshotUnchanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+
+	playlist := testPlaylist
+	playlist.SnapshotID = "snapshot1"
+	_ = s.SavePlaylistFile(context.Background(), service.NewCachedPlaylist(playlist, nil))
+
+	assert.NoError(t, u.UpdateLocalCache(context.Background(), []spotify.SimplePlaylist{playlist}))
+}
+
+// Test_UpdateLocalCache_SnapshotChanged tests that a playlist with a new snapshot is downloaded
+// even when the number of tracks is the same
+func Test_UpdateLocalCache_SnapshotChanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockWrapper := mock_adapter.NewMockSpotifyWrapperInterface(ctrl)
+	s := storage.NewStorage("test", true)
+	defer cleanUp("test")
+	u := NewUtil(mockWrapper, s, "disliked_", " Queue", false, false, 1)
+
+	playlist := testPlaylist
+	playlist.SnapshotID = "snapshot1"
+	playlist.Tracks.Total = 1
+	_ = s.SavePlaylistFile(context.Background(), service.NewCachedPlaylist(playlist, []spotify.PlaylistTrack{
+		newTestTrack("a", "Song", "Artist", 200000),
+	}))
+
+	playlist.SnapshotID = "snapshot2"
+	newTracks := []spotify.PlaylistTrack{newTestTrack("b", "Other", "Artist", 200000)}
+	mockWrapper.EXPECT().GetAllPlaylistTracks(gomock.Any(), playlist.ID).Return(newTracks, nil)
+
+	assert.NoError(t, u.UpdateLocalCache(context.Background(), []spotify.SimplePlaylist{playlist}))
+
+	cached, err := s.LoadPlaylistFile(context.Background(), string(playlist.ID))
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot2", cached.SnapshotID)
+	assert.Equal(t, newTracks, cached.Tracks)
+}